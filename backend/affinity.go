@@ -0,0 +1,100 @@
+package backend
+
+import (
+	"hash/fnv"
+	"math"
+	"strings"
+	"time"
+)
+
+// Affinity describes how requests from the same client are routed to the same
+// alternative of an endpoint.
+type Affinity struct {
+	Mode AffinityMode
+
+	// CookieName is the name of the cookie used when Mode is AffinityCookie.
+	CookieName string
+
+	// CookieTTL is the amount of time for which a client remains pinned to an
+	// alternative when Mode is AffinityCookie. If it is zero, the client is
+	// pinned for the lifetime of its browser session.
+	CookieTTL time.Duration
+}
+
+// AffinityMode is an enumeration of the ways in which clients are pinned to an
+// alternative.
+type AffinityMode int
+
+const (
+	// AffinityNone indicates that each request is routed independently.
+	AffinityNone AffinityMode = iota
+
+	// AffinityCookie indicates that clients are pinned to an alternative using
+	// a signed cookie.
+	AffinityCookie
+
+	// AffinityClientIP indicates that clients are pinned to an alternative
+	// based on a hash of their IP address.
+	AffinityClientIP
+)
+
+// DefaultAffinityCookieName is the default name of the affinity cookie.
+const DefaultAffinityCookieName = "honeycomb-affinity"
+
+// ParseAffinityMode parses a textual representation of an affinity mode.
+func ParseAffinityMode(value string) (AffinityMode, bool) {
+	switch strings.ToLower(value) {
+	case "none", "false", "disabled":
+		return AffinityNone, true
+	case "cookie":
+		return AffinityCookie, true
+	case "client-ip", "ip":
+		return AffinityClientIP, true
+	default:
+		return AffinityNone, false
+	}
+}
+
+// PickFor returns one of the endpoint's immediate alternatives, chosen
+// deterministically for the given key, or ep itself if it has no
+// alternatives.
+//
+// Alternatives are chosen in proportion to their weights, as per Pick(). When
+// an alternative is removed, only the keys that were mapped to it are mapped to
+// a different alternative. It returns nil if none of the alternatives has a
+// positive weight.
+func (ep *Endpoint) PickFor(key string) *Endpoint {
+	if ep == nil || len(ep.Alternatives) == 0 {
+		return ep
+	}
+
+	var (
+		result *Endpoint
+		best   = math.Inf(1)
+	)
+
+	// Weighted rendezvous hashing, each alternative is scored using a hash of
+	// the key and its ID, and the alternative with the lowest score is used.
+	for _, alt := range ep.Alternatives {
+		if alt.Weight <= 0 {
+			continue
+		}
+		weight := float64(alt.Weight)
+
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(alt.ID()))
+
+		// Map the hash to the open interval (0, 1).
+		u := (float64(h.Sum64()>>11) + 0.5) / (1 << 53)
+		score := -math.Log(u) / weight
+
+		if score < best {
+			result = alt
+			best = score
+		}
+	}
+
+	return result
+}
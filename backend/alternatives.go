@@ -0,0 +1,110 @@
+package backend
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+)
+
+// Choose returns the endpoint to use for a single request.
+//
+// If ep has alternatives, one of them is chosen at random in proportion to
+// their weights, otherwise ep itself is returned. It returns nil if none of
+// the alternatives has a positive weight, such as when they have all been
+// drained, in which case the request should not be routed.
+func (ep *Endpoint) Choose() *Endpoint {
+	if ep == nil || len(ep.Alternatives) == 0 {
+		return ep
+	}
+
+	return ep.Pick().Choose()
+}
+
+// Pick returns one of the endpoint's immediate alternatives, chosen as per
+// Choose(), or ep itself if it has no alternatives. Unlike Choose(), it does
+// not choose between the alternatives of the returned endpoint.
+func (ep *Endpoint) Pick() *Endpoint {
+	if ep == nil || len(ep.Alternatives) == 0 {
+		return ep
+	}
+
+	total := 0
+	for _, alt := range ep.Alternatives {
+		if alt.Weight > 0 {
+			total += alt.Weight
+		}
+	}
+
+	if total == 0 {
+		return nil
+	}
+
+	n := rand.Intn(total)
+	for _, alt := range ep.Alternatives {
+		if alt.Weight <= 0 {
+			continue
+		}
+
+		if n < alt.Weight {
+			return alt
+		}
+
+		n -= alt.Weight
+	}
+
+	panic("unreachable")
+}
+
+// Alternative returns the alternative with the given ID, as returned by ID(),
+// or nil if there is no such alternative, or it is not eligible to receive
+// requests because its weight is zero.
+func (ep *Endpoint) Alternative(id string) *Endpoint {
+	if ep == nil {
+		return nil
+	}
+
+	for _, alt := range ep.Alternatives {
+		if alt.ID() == id {
+			if alt.Weight <= 0 {
+				return nil
+			}
+
+			return alt
+		}
+	}
+
+	return nil
+}
+
+// ID returns an opaque identifier for the endpoint, derived from its address,
+// that is suitable for sharing with clients.
+func (ep *Endpoint) ID() string {
+	h := fnv.New64a()
+	h.Write([]byte(ep.Address))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// Filter returns an endpoint equivalent to ep, but without the immediate
+// alternatives for which fn returns false. If fn returns false for all of the
+// alternatives, ep itself is returned.
+func (ep *Endpoint) Filter(fn func(*Endpoint) bool) *Endpoint {
+	if ep == nil || len(ep.Alternatives) == 0 {
+		return ep
+	}
+
+	var alternatives []*Endpoint
+	for _, alt := range ep.Alternatives {
+		if fn(alt) {
+			alternatives = append(alternatives, alt)
+		}
+	}
+
+	if len(alternatives) == 0 || len(alternatives) == len(ep.Alternatives) {
+		return ep
+	}
+
+	filtered := *ep
+	filtered.Alternatives = alternatives
+
+	return &filtered
+}
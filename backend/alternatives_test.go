@@ -0,0 +1,185 @@
+package backend_test
+
+import (
+	"fmt"
+
+	"github.com/icecave/honeycomb/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Endpoint", func() {
+	Describe("Choose", func() {
+		It("returns the endpoint itself if it has no alternatives", func() {
+			subject := &backend.Endpoint{Address: "foo:443"}
+			Expect(subject.Choose()).To(BeIdenticalTo(subject))
+		})
+
+		It("returns one of the alternatives", func() {
+			alternatives := []*backend.Endpoint{
+				{Address: "foo1:443", Weight: 1},
+				{Address: "foo2:443", Weight: 1},
+			}
+			subject := &backend.Endpoint{Alternatives: alternatives}
+
+			seen := map[string]bool{}
+			for i := 0; i < 100; i++ {
+				seen[subject.Choose().Address] = true
+			}
+
+			Expect(seen).To(Equal(map[string]bool{
+				"foo1:443": true,
+				"foo2:443": true,
+			}))
+		})
+
+		It("returns nil when called on a nil endpoint", func() {
+			var subject *backend.Endpoint
+			Expect(subject.Choose()).To(BeNil())
+		})
+
+		It("chooses alternatives in proportion to their weights", func() {
+			subject := &backend.Endpoint{
+				Alternatives: []*backend.Endpoint{
+					{Address: "v1:443", Weight: 3},
+					{Address: "v2:443", Weight: 1},
+					{Address: "v3:443", Weight: 0},
+				},
+			}
+
+			counts := map[string]int{}
+			for i := 0; i < 4000; i++ {
+				counts[subject.Choose().Address]++
+			}
+
+			Expect(counts["v1:443"]).To(BeNumerically("~", 3000, 200))
+			Expect(counts["v2:443"]).To(BeNumerically("~", 1000, 200))
+			Expect(counts).NotTo(HaveKey("v3:443"))
+		})
+
+		It("returns nil if all of the alternatives have a weight of zero", func() {
+			subject := &backend.Endpoint{
+				Alternatives: []*backend.Endpoint{
+					{Address: "v1:443", Weight: 0},
+					{Address: "v2:443", Weight: 0},
+				},
+			}
+
+			Expect(subject.Choose()).To(BeNil())
+		})
+	})
+
+	Describe("Alternative", func() {
+		var subject *backend.Endpoint
+
+		BeforeEach(func() {
+			subject = &backend.Endpoint{
+				Alternatives: []*backend.Endpoint{
+					{Address: "v1:443", Weight: 1},
+					{Address: "v2:443", Weight: 0},
+				},
+			}
+		})
+
+		It("returns the alternative with the given ID", func() {
+			alt := subject.Alternatives[0]
+			Expect(subject.Alternative(alt.ID())).To(BeIdenticalTo(alt))
+		})
+
+		It("returns nil if the alternative has a weight of zero", func() {
+			alt := subject.Alternatives[1]
+			Expect(subject.Alternative(alt.ID())).To(BeNil())
+		})
+
+		It("returns nil if there is no such alternative", func() {
+			Expect(subject.Alternative("<unknown>")).To(BeNil())
+		})
+	})
+
+	Describe("PickFor", func() {
+		It("returns the same alternative for the same key", func() {
+			subject := &backend.Endpoint{
+				Alternatives: []*backend.Endpoint{
+					{Address: "foo1:443", Weight: 1},
+					{Address: "foo2:443", Weight: 1},
+					{Address: "foo3:443", Weight: 1},
+				},
+			}
+
+			first := subject.PickFor("10.0.0.1")
+			for i := 0; i < 10; i++ {
+				Expect(subject.PickFor("10.0.0.1")).To(BeIdenticalTo(first))
+			}
+		})
+
+		It("only remaps keys that were mapped to a removed alternative", func() {
+			alternatives := []*backend.Endpoint{
+				{Address: "foo1:443", Weight: 1},
+				{Address: "foo2:443", Weight: 1},
+				{Address: "foo3:443", Weight: 1},
+			}
+			before := &backend.Endpoint{Alternatives: alternatives}
+			after := &backend.Endpoint{Alternatives: alternatives[:2]}
+
+			for i := 0; i < 100; i++ {
+				key := fmt.Sprintf("10.0.0.%d", i)
+				if ep := before.PickFor(key); ep != alternatives[2] {
+					Expect(after.PickFor(key)).To(BeIdenticalTo(ep))
+				}
+			}
+		})
+
+		It("never returns an alternative with a weight of zero", func() {
+			subject := &backend.Endpoint{
+				Alternatives: []*backend.Endpoint{
+					{Address: "foo1:443", Weight: 1},
+					{Address: "foo2:443", Weight: 0},
+				},
+			}
+
+			for i := 0; i < 100; i++ {
+				key := fmt.Sprintf("10.0.0.%d", i)
+				Expect(subject.PickFor(key).Address).To(Equal("foo1:443"))
+			}
+		})
+
+		It("returns nil if all of the alternatives have a weight of zero", func() {
+			subject := &backend.Endpoint{
+				Alternatives: []*backend.Endpoint{
+					{Address: "foo1:443", Weight: 0},
+					{Address: "foo2:443", Weight: 0},
+				},
+			}
+
+			Expect(subject.PickFor("10.0.0.1")).To(BeNil())
+		})
+	})
+
+	Describe("Filter", func() {
+		var subject *backend.Endpoint
+
+		BeforeEach(func() {
+			subject = &backend.Endpoint{
+				Alternatives: []*backend.Endpoint{
+					{Address: "foo1:443", Weight: 1},
+					{Address: "foo2:443", Weight: 1},
+				},
+			}
+		})
+
+		It("excludes alternatives for which the function returns false", func() {
+			ep := subject.Filter(func(alt *backend.Endpoint) bool {
+				return alt.Address != "foo1:443"
+			})
+
+			Expect(ep.Alternatives).To(HaveLen(1))
+			Expect(ep.Alternatives[0].Address).To(Equal("foo2:443"))
+			Expect(subject.Alternatives).To(HaveLen(2))
+		})
+
+		It("returns the endpoint itself if all alternatives would be excluded", func() {
+			ep := subject.Filter(func(*backend.Endpoint) bool { return false })
+			Expect(ep).To(BeIdenticalTo(subject))
+		})
+	})
+})
@@ -1,5 +1,10 @@
 package backend
 
+import (
+	"reflect"
+	"time"
+)
+
 // Endpoint holds information about a back-end HTTP(s) server.
 type Endpoint struct {
 	// A human readable description of what the end-point is, not necessarily
@@ -13,6 +18,74 @@ type Endpoint struct {
 	// TLSMode indicates whether or not the back-end server is expecting a TLS
 	// connection.
 	TLSMode TLSMode
+
+	// Paths holds endpoints that are used in place of this endpoint for
+	// requests with specific URL paths. If Address is empty, only requests that
+	// match one of these paths are routed.
+	Paths []PathEndpoint
+
+	// Alternatives holds equivalent endpoints between which requests are
+	// distributed, such as the instances of a replicated service. If it is
+	// non-empty, Address is ignored.
+	Alternatives []*Endpoint
+
+	// Weight is the relative proportion of requests that are routed to this
+	// endpoint when it is one of several alternatives. Alternatives with a
+	// weight of zero do not receive any requests. See Choose().
+	Weight int
+
+	// Protocol is the HTTP protocol version used to communicate with the
+	// endpoint when it uses TLS.
+	Protocol Protocol
+
+	// HTTPMode determines how requests for the endpoint that are received over
+	// plain HTTP are handled. By default they are redirected to HTTPS.
+	HTTPMode HTTPMode
+
+	// Timeouts overrides the default upstream timeouts for requests to this
+	// endpoint. Zero values indicate that the defaults are used.
+	Timeouts Timeouts
+
+	// WebSocket overrides the default limits for websocket connections, and
+	// other upgraded connections, to this endpoint. Zero values indicate that
+	// the defaults are used.
+	WebSocket WebSocketLimits
+
+	// Origins, if non-nil, restricts the origins from which browsers may open
+	// websocket connections to the endpoint.
+	Origins *OriginPolicy
+
+	// SecurityHeaders overrides the default security headers that are added
+	// to responses from this endpoint.
+	SecurityHeaders SecurityHeaders
+
+	// FlushInterval overrides the default interval at which streaming
+	// responses are flushed to the client. A negative value, such as
+	// FlushImmediately, flushes after every write. A zero value indicates that
+	// the default is used.
+	FlushInterval time.Duration
+
+	// UpgradeProtocols is the list of protocols, other than websockets, that
+	// clients may upgrade their connection to, such as "tcp" or "SPDY/3.1".
+	// A protocol without a version matches any version of that protocol.
+	UpgradeProtocols []string
+
+	// GRPCWeb, if true, causes gRPC-Web requests to be translated into native
+	// gRPC requests before they are sent to the endpoint.
+	GRPCWeb bool
+
+	// Affinity, if non-nil, describes how requests from the same client are
+	// routed to the same alternative.
+	Affinity *Affinity
+
+	// Maintenance, if non-nil, indicates that the endpoint is offline for
+	// maintenance. It applies to any alternatives of the endpoint.
+	Maintenance *Maintenance
+}
+
+// Equal checks if two endpoints are identical.
+func (ep *Endpoint) Equal(other *Endpoint) bool {
+	return reflect.DeepEqual(ep, other)
 }
 
 // TLSMode is an enumerationo of the TLS "modes" used by an endpoint.
@@ -0,0 +1,26 @@
+package backend
+
+import (
+	"strings"
+	"time"
+)
+
+// FlushImmediately is a flush interval that causes streaming responses to be
+// flushed to the client after every write.
+const FlushImmediately time.Duration = -1
+
+// ParseFlushInterval parses the interval at which streaming responses are
+// flushed to the client. It accepts "immediate", or a positive duration such
+// as "100ms".
+func ParseFlushInterval(value string) (time.Duration, bool) {
+	if strings.EqualFold(value, "immediate") {
+		return FlushImmediately, true
+	}
+
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval <= 0 {
+		return 0, false
+	}
+
+	return interval, true
+}
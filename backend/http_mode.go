@@ -0,0 +1,28 @@
+package backend
+
+// HTTPMode is an enumeration of the ways in which requests for an endpoint
+// that are received over plain HTTP, rather than HTTPS, are handled.
+type HTTPMode int
+
+const (
+	// HTTPRedirect indicates that requests received over plain HTTP are
+	// redirected to HTTPS.
+	HTTPRedirect HTTPMode = iota
+
+	// HTTPServe indicates that requests received over plain HTTP are proxied
+	// to the endpoint.
+	HTTPServe
+)
+
+// ParseHTTPMode parses the textual representation of an HTTP mode, as used in
+// service labels.
+func ParseHTTPMode(value string) (HTTPMode, bool) {
+	switch value {
+	case "redirect":
+		return HTTPRedirect, true
+	case "serve":
+		return HTTPServe, true
+	default:
+		return HTTPRedirect, false
+	}
+}
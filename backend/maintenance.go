@@ -0,0 +1,77 @@
+package backend
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Maintenance holds information about an endpoint that is offline for
+// maintenance.
+type Maintenance struct {
+	// Message is shown to clients in place of the default status page
+	// message. It may be empty.
+	Message string
+
+	// RetryAfter is the amount of time after which clients should retry the
+	// request. It may be zero if the duration of the maintenance is unknown.
+	RetryAfter time.Duration
+
+	// AllowedNetworks is a list of networks from which clients are still
+	// routed to the endpoint.
+	AllowedNetworks []*net.IPNet
+}
+
+// Allows returns true if clients with the given IP address are still routed to
+// the endpoint.
+func (m *Maintenance) Allows(ip net.IP) bool {
+	for _, n := range m.AllowedNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseNetworks parses a comma-separated list of IP addresses and CIDR network
+// addresses.
+func ParseNetworks(value string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+
+	for _, s := range strings.Split(value, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		if strings.Contains(s, "/") {
+			_, n, err := net.ParseCIDR(s)
+			if err != nil {
+				return nil, err
+			}
+
+			networks = append(networks, n)
+			continue
+		}
+
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address: %s", s)
+		}
+
+		bits := 8 * net.IPv6len
+		if v4 := ip.To4(); v4 != nil {
+			ip = v4
+			bits = 8 * net.IPv4len
+		}
+
+		networks = append(networks, &net.IPNet{
+			IP:   ip,
+			Mask: net.CIDRMask(bits, bits),
+		})
+	}
+
+	return networks, nil
+}
@@ -0,0 +1,40 @@
+package backend_test
+
+import (
+	"net"
+
+	"github.com/icecave/honeycomb/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Maintenance", func() {
+	DescribeTable(
+		"Allows",
+		func(networks string, ip string, expected bool) {
+			n, err := backend.ParseNetworks(networks)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			subject := &backend.Maintenance{AllowedNetworks: n}
+			Expect(subject.Allows(net.ParseIP(ip))).To(Equal(expected))
+		},
+		Entry("no networks", "", "10.0.0.1", false),
+		Entry("matching address", "10.0.0.1", "10.0.0.1", true),
+		Entry("non-matching address", "10.0.0.1", "10.0.0.2", false),
+		Entry("matching network", "192.168.0.0/16, 10.0.0.0/8", "10.1.2.3", true),
+		Entry("non-matching network", "10.0.0.0/8", "11.0.0.1", false),
+		Entry("matching IPv6 address", "::1", "::1", true),
+		Entry("matching IPv6 network", "fd00::/8", "fd12::1", true),
+	)
+
+	DescribeTable(
+		"ParseNetworks returns an error for invalid values",
+		func(networks string) {
+			_, err := backend.ParseNetworks(networks)
+			Expect(err).Should(HaveOccurred())
+		},
+		Entry("invalid address", "10.0.0"),
+		Entry("invalid network", "10.0.0.0/33"),
+	)
+})
@@ -0,0 +1,99 @@
+package backend
+
+import (
+	"net"
+	"net/url"
+	"strings"
+)
+
+// OriginPolicy describes the origins from which browsers may open websocket
+// connections to an endpoint, as a defence against cross-site websocket
+// hijacking.
+//
+// Requests without an Origin header are always allowed, as they are not made
+// by browsers.
+type OriginPolicy struct {
+	// Allowed is a list of origin patterns, such as "https://example.com". The
+	// left-most label of the host may be a wildcard, such as
+	// "https://*.example.com", which matches any subdomain. A pattern without
+	// a scheme, such as "example.com", matches any scheme.
+	Allowed []string
+
+	// SameHost, if true, allows origins with the same host as the request,
+	// ignoring the port.
+	SameHost bool
+}
+
+// Allows returns true if a websocket connection with the given Origin header
+// value may be made to host, which is the host of the request.
+func (p *OriginPolicy) Allows(origin, host string) bool {
+	if origin == "" {
+		return true
+	}
+
+	for _, pattern := range p.Allowed {
+		if matchOrigin(pattern, origin) {
+			return true
+		}
+	}
+
+	if p.SameHost {
+		if u, err := url.Parse(origin); err == nil && u.Host != "" {
+			return strings.EqualFold(u.Hostname(), stripPort(host))
+		}
+	}
+
+	return false
+}
+
+// matchOrigin returns true if origin matches pattern.
+func matchOrigin(pattern, origin string) bool {
+	if strings.EqualFold(pattern, origin) {
+		return true
+	}
+
+	scheme, host := "", pattern
+	if i := strings.Index(pattern, "://"); i != -1 {
+		scheme, host = pattern[:i], pattern[i+3:]
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	if scheme != "" && !strings.EqualFold(scheme, u.Scheme) {
+		return false
+	}
+
+	if strings.HasPrefix(host, "*.") {
+		suffix := host[1:]
+		return len(u.Host) > len(suffix) &&
+			strings.HasSuffix(strings.ToLower(u.Host), strings.ToLower(suffix))
+	}
+
+	return strings.EqualFold(host, u.Host)
+}
+
+// stripPort returns host without its port number, if any.
+func stripPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+
+	return host
+}
+
+// ParseOrigins parses a comma-separated list of origin patterns, as used in
+// service labels.
+func ParseOrigins(value string) []string {
+	var origins []string
+
+	for _, o := range strings.Split(value, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			origins = append(origins, o)
+		}
+	}
+
+	return origins
+}
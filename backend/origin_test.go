@@ -0,0 +1,53 @@
+package backend_test
+
+import (
+	"github.com/icecave/honeycomb/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OriginPolicy", func() {
+	Describe("Allows", func() {
+		policy := &backend.OriginPolicy{
+			Allowed: []string{
+				"https://app.example.com",
+				"https://*.example.org",
+				"example.net",
+			},
+		}
+
+		DescribeTable(
+			"matches origins against the allowed patterns",
+			func(origin string, expected bool) {
+				Expect(policy.Allows(origin, "api.example.com")).To(Equal(expected))
+			},
+			Entry("no origin", "", true),
+			Entry("exact match", "https://app.example.com", true),
+			Entry("exact match, different case", "https://APP.example.com", true),
+			Entry("exact match, different scheme", "http://app.example.com", false),
+			Entry("exact match, different port", "https://app.example.com:8443", false),
+			Entry("wildcard match", "https://a.b.example.org", true),
+			Entry("wildcard does not match the parent domain", "https://example.org", false),
+			Entry("pattern without a scheme", "http://example.net", true),
+			Entry("same host when not enabled", "https://api.example.com", false),
+			Entry("opaque origin", "null", false),
+		)
+
+		It("allows origins with the same host as the request if enabled", func() {
+			policy := &backend.OriginPolicy{SameHost: true}
+
+			Expect(policy.Allows("https://api.example.com", "api.example.com:443")).To(BeTrue())
+			Expect(policy.Allows("http://api.example.com:8080", "api.example.com")).To(BeTrue())
+			Expect(policy.Allows("https://evil.example.com", "api.example.com")).To(BeFalse())
+		})
+	})
+
+	Describe("ParseOrigins", func() {
+		It("parses a comma-separated list", func() {
+			Expect(backend.ParseOrigins(" https://a.com, ,*.b.com ")).To(Equal(
+				[]string{"https://a.com", "*.b.com"},
+			))
+		})
+	})
+})
@@ -0,0 +1,66 @@
+package backend
+
+import "strings"
+
+// PathEndpoint associates an endpoint with a URL path.
+type PathEndpoint struct {
+	// Path is the URL path to match. Unless Exact is true, it matches any
+	// request path that begins with the same path elements.
+	Path string
+
+	// Exact indicates that Path must match the request path exactly.
+	Exact bool
+
+	// Endpoint is the endpoint used for requests that match Path.
+	Endpoint *Endpoint
+}
+
+// Match checks if the path matches the given request path.
+//
+// It returns a score indicating the strength of the match. A value of 0 or less
+// indicates that no match was made.
+func (p PathEndpoint) Match(path string) int {
+	if p.Exact {
+		if path == p.Path {
+			// exact matches always take precedence over prefix matches of the
+			// same length
+			return 2 + 2*len(p.Path)
+		}
+
+		return 0
+	}
+
+	prefix := strings.TrimSuffix(p.Path, "/")
+	if path == prefix || strings.HasPrefix(path, prefix+"/") {
+		return 1 + 2*len(prefix)
+	}
+
+	return 0
+}
+
+// ForPath returns the endpoint to use for a request with the given URL path.
+//
+// It returns the endpoint from ep.Paths that best matches path. If none match,
+// ep itself is returned, unless it has neither an address nor alternatives, in
+// which case it returns nil.
+func (ep *Endpoint) ForPath(path string) *Endpoint {
+	if ep == nil {
+		return nil
+	}
+
+	match := ep
+	score := 0
+
+	for _, p := range ep.Paths {
+		if s := p.Match(path); s > score {
+			match = p.Endpoint.ForPath(path)
+			score = s
+		}
+	}
+
+	if match == ep && ep.Address == "" && len(ep.Alternatives) == 0 {
+		return nil
+	}
+
+	return match
+}
@@ -0,0 +1,61 @@
+package backend_test
+
+import (
+	"github.com/icecave/honeycomb/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Endpoint", func() {
+	Describe("ForPath", func() {
+		var subject *backend.Endpoint
+
+		BeforeEach(func() {
+			subject = &backend.Endpoint{
+				Address: "default:443",
+				Paths: []backend.PathEndpoint{
+					{Path: "/api", Endpoint: &backend.Endpoint{Address: "api:443"}},
+					{Path: "/api/v2/", Endpoint: &backend.Endpoint{Address: "api-v2:443"}},
+					{Path: "/api/v2", Exact: true, Endpoint: &backend.Endpoint{Address: "api-v2-exact:443"}},
+					{Path: "/offline", Endpoint: nil},
+				},
+			}
+		})
+
+		DescribeTable(
+			"it returns the endpoint that best matches the path",
+			func(path, expected string) {
+				endpoint := subject.ForPath(path)
+				Expect(endpoint).ShouldNot(BeNil())
+				Expect(endpoint.Address).To(Equal(expected))
+			},
+			Entry("no matching path", "/", "default:443"),
+			Entry("prefix match", "/api/users", "api:443"),
+			Entry("prefix match without trailing slash", "/api", "api:443"),
+			Entry("partial path element", "/apiary", "default:443"),
+			Entry("longest prefix match", "/api/v2/users", "api-v2:443"),
+			Entry("exact match", "/api/v2", "api-v2-exact:443"),
+		)
+
+		It("returns nil if the matching path has no endpoint", func() {
+			Expect(subject.ForPath("/offline")).To(BeNil())
+		})
+
+		It("returns nil if no path matches and the endpoint has no address", func() {
+			subject.Address = ""
+			Expect(subject.ForPath("/")).To(BeNil())
+		})
+
+		It("returns the endpoint itself if it has alternatives but no address", func() {
+			subject.Address = ""
+			subject.Alternatives = []*backend.Endpoint{{Address: "foo1:443"}}
+			Expect(subject.ForPath("/")).To(BeIdenticalTo(subject))
+		})
+
+		It("returns nil when called on a nil endpoint", func() {
+			subject = nil
+			Expect(subject.ForPath("/")).To(BeNil())
+		})
+	})
+})
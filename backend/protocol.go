@@ -0,0 +1,32 @@
+package backend
+
+// Protocol is an enumeration of the HTTP protocol versions used to communicate
+// with an endpoint that uses TLS.
+type Protocol int
+
+const (
+	// ProtocolAuto indicates that the protocol is negotiated using ALPN,
+	// preferring HTTP/2.
+	ProtocolAuto Protocol = iota
+
+	// ProtocolHTTP1 indicates that the endpoint only supports HTTP/1.1.
+	ProtocolHTTP1
+
+	// ProtocolHTTP2 indicates that the endpoint only supports HTTP/2.
+	ProtocolHTTP2
+)
+
+// ParseProtocol parses the textual representation of a protocol, as used in
+// service labels.
+func ParseProtocol(value string) (Protocol, bool) {
+	switch value {
+	case "auto":
+		return ProtocolAuto, true
+	case "h1", "http/1.1":
+		return ProtocolHTTP1, true
+	case "h2", "http/2":
+		return ProtocolHTTP2, true
+	default:
+		return ProtocolAuto, false
+	}
+}
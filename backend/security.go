@@ -0,0 +1,158 @@
+package backend
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HeaderDisabled is a security header value that prevents the header from
+// being added to responses, overriding the default.
+const HeaderDisabled = "off"
+
+// SecurityHeaders describes the security-related headers that are added to
+// responses from an endpoint. Empty values indicate that the defaults are
+// used.
+type SecurityHeaders struct {
+	// HSTS is the policy sent in the Strict-Transport-Security header. It is
+	// only sent in responses to requests received over HTTPS.
+	HSTS *HSTSPolicy
+
+	// ContentSecurityPolicy is the value of the Content-Security-Policy
+	// header.
+	ContentSecurityPolicy string
+
+	// FrameOptions is the value of the X-Frame-Options header, such as
+	// "DENY" or "SAMEORIGIN".
+	FrameOptions string
+
+	// ContentTypeOptions is the value of the X-Content-Type-Options header,
+	// which should be "nosniff".
+	ContentTypeOptions string
+
+	// ReferrerPolicy is the value of the Referrer-Policy header.
+	ReferrerPolicy string
+
+	// PermissionsPolicy is the value of the Permissions-Policy header.
+	PermissionsPolicy string
+
+	// Preserve, if true, leaves headers that are set by the endpoint itself
+	// untouched. If false, they are replaced with the values above. If nil,
+	// the default is used.
+	Preserve *bool
+}
+
+// PreservesHeaders returns true if headers that are set by the endpoint itself
+// are left untouched.
+func (h SecurityHeaders) PreservesHeaders() bool {
+	return h.Preserve != nil && *h.Preserve
+}
+
+// Merge returns a copy of h with any empty values replaced with those from
+// defaults.
+func (h SecurityHeaders) Merge(defaults SecurityHeaders) SecurityHeaders {
+	if h.HSTS == nil {
+		h.HSTS = defaults.HSTS
+	}
+
+	if h.ContentSecurityPolicy == "" {
+		h.ContentSecurityPolicy = defaults.ContentSecurityPolicy
+	}
+
+	if h.FrameOptions == "" {
+		h.FrameOptions = defaults.FrameOptions
+	}
+
+	if h.ContentTypeOptions == "" {
+		h.ContentTypeOptions = defaults.ContentTypeOptions
+	}
+
+	if h.ReferrerPolicy == "" {
+		h.ReferrerPolicy = defaults.ReferrerPolicy
+	}
+
+	if h.PermissionsPolicy == "" {
+		h.PermissionsPolicy = defaults.PermissionsPolicy
+	}
+
+	if h.Preserve == nil {
+		h.Preserve = defaults.Preserve
+	}
+
+	return h
+}
+
+// HSTSPolicy is an HTTP Strict Transport Security policy, as per RFC 6797.
+type HSTSPolicy struct {
+	// MaxAge is the amount of time that browsers only connect to the host
+	// using HTTPS. A negative value, such as HSTSDisabled, prevents the
+	// header from being sent.
+	MaxAge time.Duration
+
+	// IncludeSubDomains, if true, applies the policy to subdomains of the
+	// host.
+	IncludeSubDomains bool
+
+	// Preload, if true, consents to the host being included in the HSTS
+	// preload lists built into browsers.
+	Preload bool
+}
+
+// HSTSDisabled is an HSTS max-age that prevents the Strict-Transport-Security
+// header from being sent.
+const HSTSDisabled time.Duration = -1
+
+// ParseHSTS parses an HSTS policy in the format of the Strict-Transport-Security
+// header, such as "max-age=31536000; includeSubDomains", or "off" to disable
+// the header.
+func ParseHSTS(value string) (*HSTSPolicy, bool) {
+	if strings.EqualFold(value, HeaderDisabled) {
+		return &HSTSPolicy{MaxAge: HSTSDisabled}, true
+	}
+
+	p := &HSTSPolicy{MaxAge: HSTSDisabled}
+
+	for _, d := range strings.Split(value, ";") {
+		d = strings.TrimSpace(d)
+		n := strings.ToLower(d)
+
+		switch {
+		case strings.HasPrefix(n, "max-age="):
+			seconds, err := strconv.ParseUint(n[len("max-age="):], 10, 32)
+			if err != nil {
+				return nil, false
+			}
+			p.MaxAge = time.Duration(seconds) * time.Second
+		case n == "includesubdomains":
+			p.IncludeSubDomains = true
+		case n == "preload":
+			p.Preload = true
+		case n == "":
+		default:
+			return nil, false
+		}
+	}
+
+	// The max-age directive is required.
+	if p.MaxAge < 0 {
+		return nil, false
+	}
+
+	return p, true
+}
+
+// String returns the policy in the format of the Strict-Transport-Security
+// header.
+func (p *HSTSPolicy) String() string {
+	s := "max-age=" + strconv.FormatInt(int64(p.MaxAge/time.Second), 10)
+
+	if p.IncludeSubDomains {
+		s += "; includeSubDomains"
+	}
+
+	if p.Preload {
+		s += "; preload"
+	}
+
+	return s
+}
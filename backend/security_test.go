@@ -0,0 +1,88 @@
+package backend_test
+
+import (
+	"time"
+
+	"github.com/icecave/honeycomb/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SecurityHeaders", func() {
+	Describe("Merge", func() {
+		It("replaces empty values with the defaults", func() {
+			h := backend.SecurityHeaders{
+				FrameOptions:   "SAMEORIGIN",
+				ReferrerPolicy: backend.HeaderDisabled,
+			}
+
+			hsts := &backend.HSTSPolicy{MaxAge: time.Hour}
+			preserve := true
+
+			Expect(h.Merge(backend.SecurityHeaders{
+				HSTS:               hsts,
+				FrameOptions:       "DENY",
+				ContentTypeOptions: "nosniff",
+				ReferrerPolicy:     "no-referrer",
+				Preserve:           &preserve,
+			})).To(Equal(backend.SecurityHeaders{
+				HSTS:               hsts,
+				FrameOptions:       "SAMEORIGIN",
+				ContentTypeOptions: "nosniff",
+				ReferrerPolicy:     backend.HeaderDisabled,
+				Preserve:           &preserve,
+			}))
+		})
+
+		It("does not replace a preserve setting of false with the default", func() {
+			preserve, override := true, false
+			h := backend.SecurityHeaders{Preserve: &override}
+
+			merged := h.Merge(backend.SecurityHeaders{Preserve: &preserve})
+
+			Expect(merged.PreservesHeaders()).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("HSTSPolicy", func() {
+	DescribeTable(
+		"ParseHSTS parses valid policies",
+		func(value string, expected backend.HSTSPolicy) {
+			p, ok := backend.ParseHSTS(value)
+			Expect(ok).To(BeTrue())
+			Expect(*p).To(Equal(expected))
+		},
+		Entry("max-age only", "max-age=300", backend.HSTSPolicy{MaxAge: 5 * time.Minute}),
+		Entry("all directives", "max-age=31536000; includeSubDomains; preload", backend.HSTSPolicy{
+			MaxAge:            365 * 24 * time.Hour,
+			IncludeSubDomains: true,
+			Preload:           true,
+		}),
+		Entry("different case", "Max-Age=0;INCLUDESUBDOMAINS", backend.HSTSPolicy{IncludeSubDomains: true}),
+		Entry("disabled", "off", backend.HSTSPolicy{MaxAge: backend.HSTSDisabled}),
+	)
+
+	DescribeTable(
+		"ParseHSTS rejects invalid policies",
+		func(value string) {
+			_, ok := backend.ParseHSTS(value)
+			Expect(ok).To(BeFalse())
+		},
+		Entry("empty", ""),
+		Entry("missing max-age", "includeSubDomains"),
+		Entry("negative max-age", "max-age=-1"),
+		Entry("unknown directive", "max-age=300; always"),
+	)
+
+	It("formats the policy as a header value", func() {
+		p := &backend.HSTSPolicy{
+			MaxAge:            365 * 24 * time.Hour,
+			IncludeSubDomains: true,
+			Preload:           true,
+		}
+
+		Expect(p.String()).To(Equal("max-age=31536000; includeSubDomains; preload"))
+	})
+})
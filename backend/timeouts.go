@@ -0,0 +1,54 @@
+package backend
+
+import "time"
+
+// Timeouts holds the maximum amount of time allowed for each phase of a
+// request to an upstream server. A zero value indicates that there is no
+// limit.
+type Timeouts struct {
+	// Dial is the maximum amount of time to wait for a TCP connection to be
+	// established.
+	Dial time.Duration
+
+	// TLSHandshake is the maximum amount of time to wait for a TLS handshake
+	// to complete.
+	TLSHandshake time.Duration
+
+	// ResponseHeader is the maximum amount of time to wait for the response
+	// headers after the request has been written.
+	ResponseHeader time.Duration
+
+	// Idle is the maximum amount of time to wait for more of the response body
+	// to be received.
+	Idle time.Duration
+
+	// Total is the maximum amount of time for the entire request, including
+	// reading the response body.
+	Total time.Duration
+}
+
+// Merge returns a copy of t with any zero values replaced with those from
+// defaults.
+func (t Timeouts) Merge(defaults Timeouts) Timeouts {
+	if t.Dial == 0 {
+		t.Dial = defaults.Dial
+	}
+
+	if t.TLSHandshake == 0 {
+		t.TLSHandshake = defaults.TLSHandshake
+	}
+
+	if t.ResponseHeader == 0 {
+		t.ResponseHeader = defaults.ResponseHeader
+	}
+
+	if t.Idle == 0 {
+		t.Idle = defaults.Idle
+	}
+
+	if t.Total == 0 {
+		t.Total = defaults.Total
+	}
+
+	return t
+}
@@ -0,0 +1,28 @@
+package backend_test
+
+import (
+	"time"
+
+	"github.com/icecave/honeycomb/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Timeouts", func() {
+	Describe("Merge", func() {
+		It("replaces zero values with the defaults", func() {
+			t := backend.Timeouts{Dial: time.Second, Total: time.Minute}
+
+			Expect(t.Merge(backend.Timeouts{
+				Dial:           30 * time.Second,
+				TLSHandshake:   10 * time.Second,
+				ResponseHeader: time.Hour,
+			})).To(Equal(backend.Timeouts{
+				Dial:           time.Second,
+				TLSHandshake:   10 * time.Second,
+				ResponseHeader: time.Hour,
+				Total:          time.Minute,
+			}))
+		})
+	})
+})
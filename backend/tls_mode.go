@@ -0,0 +1,18 @@
+package backend
+
+// ParseTLSMode parses the textual representation of a TLS mode, as used in
+// service labels and annotations.
+func ParseTLSMode(value string) (TLSMode, bool) {
+	switch value {
+	case "true", "enabled":
+		return TLSEnabled, true
+	case "false", "disabled":
+		return TLSDisabled, true
+	case "insecure":
+		return TLSInsecure, true
+	case "h2c":
+		return TLSDisabledH2C, true
+	default:
+		return TLSDisabled, false
+	}
+}
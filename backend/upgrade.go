@@ -0,0 +1,35 @@
+package backend
+
+import "strings"
+
+// AllowsUpgrade returns true if clients may upgrade their connection to the
+// endpoint to the given protocol, which is a value from an Upgrade header,
+// such as "SPDY/3.1".
+func (ep *Endpoint) AllowsUpgrade(protocol string) bool {
+	name := protocol
+	if i := strings.IndexByte(protocol, '/'); i != -1 {
+		name = protocol[:i]
+	}
+
+	for _, p := range ep.UpgradeProtocols {
+		if strings.EqualFold(p, protocol) || strings.EqualFold(p, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseUpgradeProtocols parses a comma-separated list of upgrade protocols, as
+// used in service labels.
+func ParseUpgradeProtocols(value string) []string {
+	var protocols []string
+
+	for _, p := range strings.Split(value, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			protocols = append(protocols, p)
+		}
+	}
+
+	return protocols
+}
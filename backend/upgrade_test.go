@@ -0,0 +1,37 @@
+package backend_test
+
+import (
+	"github.com/icecave/honeycomb/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Endpoint", func() {
+	Describe("AllowsUpgrade", func() {
+		endpoint := &backend.Endpoint{
+			UpgradeProtocols: []string{"tcp", "SPDY/3.1", "h2c"},
+		}
+
+		DescribeTable(
+			"it matches protocols in the allow-list",
+			func(protocol string, expected bool) {
+				Expect(endpoint.AllowsUpgrade(protocol)).To(Equal(expected))
+			},
+			Entry("exact match", "tcp", true),
+			Entry("case-insensitive match", "TCP", true),
+			Entry("versioned match", "SPDY/3.1", true),
+			Entry("unversioned entry matches any version", "h2c/2", true),
+			Entry("different version", "SPDY/2", false),
+			Entry("unlisted protocol", "irc", false),
+		)
+	})
+
+	Describe("ParseUpgradeProtocols", func() {
+		It("splits and trims the list", func() {
+			Expect(backend.ParseUpgradeProtocols(" tcp, ,SPDY/3.1 ")).To(Equal(
+				[]string{"tcp", "SPDY/3.1"},
+			))
+		})
+	})
+})
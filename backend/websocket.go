@@ -0,0 +1,80 @@
+package backend
+
+import "time"
+
+// WebSocketLimits holds the limits that apply to websocket connections, and
+// other upgraded connections, once the upgrade has completed. A zero value
+// indicates that there is no limit.
+type WebSocketLimits struct {
+	// IdleTimeout is the maximum amount of time that a connection may remain
+	// open without any data being received. If PingInterval is non-zero, each
+	// side of the connection must send data, or respond to pings, within this
+	// time.
+	IdleTimeout time.Duration
+
+	// PingInterval is the interval at which the proxy sends websocket ping
+	// frames to both the client and the upstream server. Pings are not sent
+	// on connections that have been upgraded to other protocols.
+	PingInterval time.Duration
+
+	// MaxLifetime is the maximum amount of time that a connection may remain
+	// open, regardless of activity.
+	MaxLifetime time.Duration
+
+	// MaxMessageSize is the maximum size of a websocket message, in bytes.
+	// Messages that exceed this size cause the connection to be closed.
+	MaxMessageSize int64
+
+	// Inspect, if true, causes the websocket frames to be parsed so that
+	// messages can be counted and protocol violations detected. Frames are
+	// always parsed if PingInterval or MaxMessageSize is non-zero.
+	Inspect bool
+
+	// MaxConnections is the maximum number of concurrent connections to the
+	// endpoint.
+	MaxConnections int
+
+	// MaxConnectionsPerClient is the maximum number of concurrent connections
+	// to the endpoint from a single client IP address.
+	MaxConnectionsPerClient int
+}
+
+// Merge returns a copy of l with any zero values replaced with those from
+// defaults. Inspection is enabled if it is enabled in either l or defaults.
+func (l WebSocketLimits) Merge(defaults WebSocketLimits) WebSocketLimits {
+	if l.IdleTimeout == 0 {
+		l.IdleTimeout = defaults.IdleTimeout
+	}
+
+	if l.PingInterval == 0 {
+		l.PingInterval = defaults.PingInterval
+	}
+
+	if l.MaxLifetime == 0 {
+		l.MaxLifetime = defaults.MaxLifetime
+	}
+
+	if l.MaxMessageSize == 0 {
+		l.MaxMessageSize = defaults.MaxMessageSize
+	}
+
+	if !l.Inspect {
+		l.Inspect = defaults.Inspect
+	}
+
+	if l.MaxConnections == 0 {
+		l.MaxConnections = defaults.MaxConnections
+	}
+
+	if l.MaxConnectionsPerClient == 0 {
+		l.MaxConnectionsPerClient = defaults.MaxConnectionsPerClient
+	}
+
+	return l
+}
+
+// ParsesFrames returns true if websocket frames must be parsed in order to
+// enforce l.
+func (l WebSocketLimits) ParsesFrames() bool {
+	return l.Inspect || l.PingInterval > 0 || l.MaxMessageSize > 0
+}
@@ -0,0 +1,28 @@
+package backend_test
+
+import (
+	"time"
+
+	"github.com/icecave/honeycomb/backend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WebSocketLimits", func() {
+	Describe("Merge", func() {
+		It("replaces zero values with the defaults", func() {
+			l := backend.WebSocketLimits{IdleTimeout: time.Minute, MaxConnections: 10}
+
+			Expect(l.Merge(backend.WebSocketLimits{
+				IdleTimeout:             time.Hour,
+				PingInterval:            30 * time.Second,
+				MaxConnectionsPerClient: 5,
+			})).To(Equal(backend.WebSocketLimits{
+				IdleTimeout:             time.Minute,
+				PingInterval:            30 * time.Second,
+				MaxConnections:          10,
+				MaxConnectionsPerClient: 5,
+			}))
+		})
+	})
+})
@@ -6,6 +6,9 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/icecave/honeycomb/backend"
+	"github.com/icecave/honeycomb/proxy"
 )
 
 // Config holds configuration values for commands.
@@ -19,6 +22,69 @@ type Config struct {
 	MinTLSVersion      uint16
 	MaxTLSVersion      uint16
 	CipherSuite        []uint16
+	Kubernetes         kubernetesConfig
+	Consul             consulConfig
+	StartTimeout       time.Duration
+	StartingMessage    string
+	MaintenanceToken   string
+	AffinityKey        string
+	Outliers           outlierConfig
+	Retries            retryConfig
+	Timeouts           timeoutConfig
+	FlushInterval      time.Duration
+	WebSocket          webSocketConfig
+	HTTP3              http3Config
+	ShutdownTimeout    time.Duration
+	HTTPServeHosts     []string
+	SecurityHeaders    securityHeaderConfig
+}
+
+type securityHeaderConfig struct {
+	HSTS                  *backend.HSTSPolicy
+	ContentSecurityPolicy string
+	FrameOptions          string
+	ContentTypeOptions    string
+	ReferrerPolicy        string
+	PermissionsPolicy     string
+	Preserve              bool
+}
+
+type http3Config struct {
+	Enabled        bool
+	Port           string
+	AdvertisedPort string
+	AltSvcMaxAge   time.Duration
+}
+
+type webSocketConfig struct {
+	IdleTimeout             time.Duration
+	PingInterval            time.Duration
+	MaxLifetime             time.Duration
+	MaxMessageSize          int64
+	Inspect                 bool
+	MaxConnections          int64
+	MaxConnectionsPerClient int64
+}
+
+type timeoutConfig struct {
+	Dial           time.Duration
+	TLSHandshake   time.Duration
+	ResponseHeader time.Duration
+	Idle           time.Duration
+	Total          time.Duration
+}
+
+type retryConfig struct {
+	Attempts    int64
+	Budget      float64
+	Backoff     time.Duration
+	StatusCodes []int
+}
+
+type outlierConfig struct {
+	ConsecutiveFailures int64
+	BaseEjectionTime    time.Duration
+	MaxEjectionTime     time.Duration
 }
 
 type certificateConfig struct {
@@ -30,10 +96,27 @@ type certificateConfig struct {
 	CABundles         []string
 }
 
+type kubernetesConfig struct {
+	Enabled      bool
+	ConfigPath   string
+	Namespace    string
+	IngressClass string
+}
+
+type consulConfig struct {
+	Enabled    bool
+	Address    string
+	Token      string
+	Datacenter string
+}
+
 // GetConfigFromEnvironment creates Config object based on the shell environment.
 func GetConfigFromEnvironment() *Config {
+	port := env("PORT", "8443")
+	http3Port := env("HTTP3_PORT", port)
+
 	return &Config{
-		Port:               env("PORT", "8443"),
+		Port:               port,
 		InsecurePort:       env("REDIRECT_PORT", "8080"),
 		DockerPollInterval: time.Duration(envInt("DOCKER_POLL_INTERVAL", 0)) * time.Second,
 		Certificates: certificateConfig{
@@ -52,6 +135,67 @@ func GetConfigFromEnvironment() *Config {
 		MinTLSVersion: envTLSVersion("TLS_MIN_VERSION"),
 		MaxTLSVersion: envTLSVersion("TLS_MAX_VERSION"),
 		CipherSuite:   envTLSCiphers("TLS_CIPHER_SUITE"),
+		Kubernetes: kubernetesConfig{
+			Enabled:      envBool("KUBERNETES_ENABLED", false),
+			ConfigPath:   env("KUBECONFIG", ""),
+			Namespace:    env("KUBERNETES_NAMESPACE", ""),
+			IngressClass: env("KUBERNETES_INGRESS_CLASS", ""),
+		},
+		Consul: consulConfig{
+			Enabled:    envBool("CONSUL_ENABLED", false),
+			Address:    env("CONSUL_HTTP_ADDR", "127.0.0.1:8500"),
+			Token:      env("CONSUL_HTTP_TOKEN", ""),
+			Datacenter: env("CONSUL_DATACENTER", ""),
+		},
+		StartTimeout:     envDuration("START_TIMEOUT", 30*time.Second),
+		StartingMessage:  env("STARTING_MESSAGE", ""),
+		MaintenanceToken: env("MAINTENANCE_TOKEN", ""),
+		AffinityKey:      env("AFFINITY_KEY", ""),
+		Outliers: outlierConfig{
+			ConsecutiveFailures: envInt("OUTLIER_CONSECUTIVE_FAILURES", 0),
+			BaseEjectionTime:    envDuration("OUTLIER_EJECTION_TIME", 10*time.Second),
+			MaxEjectionTime:     envDuration("OUTLIER_MAX_EJECTION_TIME", 5*time.Minute),
+		},
+		Retries: retryConfig{
+			Attempts:    envInt("RETRY_ATTEMPTS", 1),
+			Budget:      envRetryBudget("RETRY_BUDGET", proxy.DefaultRetryBudget),
+			Backoff:     envDuration("RETRY_BACKOFF", 25*time.Millisecond),
+			StatusCodes: envStatusCodes("RETRY_STATUS_CODES"),
+		},
+		Timeouts: timeoutConfig{
+			Dial:           envDuration("UPSTREAM_DIAL_TIMEOUT", 30*time.Second),
+			TLSHandshake:   envDuration("UPSTREAM_TLS_HANDSHAKE_TIMEOUT", 10*time.Second),
+			ResponseHeader: envDuration("UPSTREAM_RESPONSE_HEADER_TIMEOUT", 60*time.Second),
+			Idle:           envDuration("UPSTREAM_IDLE_TIMEOUT", 0),
+			Total:          envDuration("UPSTREAM_TIMEOUT", 0),
+		},
+		FlushInterval: envFlushInterval("FLUSH_INTERVAL", backend.FlushImmediately),
+		WebSocket: webSocketConfig{
+			IdleTimeout:             envDuration("WEBSOCKET_IDLE_TIMEOUT", 0),
+			PingInterval:            envDuration("WEBSOCKET_PING_INTERVAL", 0),
+			MaxLifetime:             envDuration("WEBSOCKET_MAX_LIFETIME", 0),
+			MaxMessageSize:          envInt("WEBSOCKET_MAX_MESSAGE_SIZE", 0),
+			Inspect:                 envBool("WEBSOCKET_INSPECT", false),
+			MaxConnections:          envInt("WEBSOCKET_MAX_CONNECTIONS", 0),
+			MaxConnectionsPerClient: envInt("WEBSOCKET_MAX_CONNECTIONS_PER_CLIENT", 0),
+		},
+		HTTP3: http3Config{
+			Enabled:        envBool("HTTP3_ENABLED", false),
+			Port:           http3Port,
+			AdvertisedPort: env("HTTP3_ADVERTISED_PORT", http3Port),
+			AltSvcMaxAge:   envDuration("HTTP3_ALT_SVC_MAX_AGE", 24*time.Hour),
+		},
+		ShutdownTimeout: envDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
+		HTTPServeHosts:  envList("HTTP_SERVE_HOSTS"),
+		SecurityHeaders: securityHeaderConfig{
+			HSTS:                  envHSTS("HSTS", "max-age=15768000"),
+			ContentSecurityPolicy: env("CONTENT_SECURITY_POLICY", ""),
+			FrameOptions:          env("FRAME_OPTIONS", ""),
+			ContentTypeOptions:    env("CONTENT_TYPE_OPTIONS", ""),
+			ReferrerPolicy:        env("REFERRER_POLICY", ""),
+			PermissionsPolicy:     env("PERMISSIONS_POLICY", ""),
+			Preserve:              envBool("PRESERVE_SECURITY_HEADERS", false),
+		},
 	}
 }
 
@@ -72,6 +216,44 @@ func envInt(key string, def int64) int64 {
 	return def
 }
 
+func envRetryBudget(key string, def float64) float64 {
+	if value, ok := os.LookupEnv(key); ok {
+		if f, err := strconv.ParseFloat(value, 64); err == nil && proxy.IsValidRetryBudget(f) {
+			return f
+		}
+	}
+
+	return def
+}
+
+func envStatusCodes(key string) []int {
+	var codes []int
+
+	if value, ok := os.LookupEnv(key); ok {
+		for _, s := range strings.Split(value, ",") {
+			if code, err := strconv.Atoi(strings.TrimSpace(s)); err == nil {
+				codes = append(codes, code)
+			}
+		}
+	}
+
+	return codes
+}
+
+func envList(key string) []string {
+	var values []string
+
+	if value, ok := os.LookupEnv(key); ok {
+		for _, s := range strings.Split(value, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				values = append(values, s)
+			}
+		}
+	}
+
+	return values
+}
+
 func envTLSVersion(key string) uint16 {
 	if value, ok := os.LookupEnv(key); ok {
 		switch strings.ToLower(value) {
@@ -132,3 +314,24 @@ func envDuration(key string, def time.Duration) time.Duration {
 
 	return def
 }
+
+func envFlushInterval(key string, def time.Duration) time.Duration {
+	if value, ok := os.LookupEnv(key); ok {
+		if interval, ok := backend.ParseFlushInterval(value); ok {
+			return interval
+		}
+	}
+
+	return def
+}
+
+func envHSTS(key string, def string) *backend.HSTSPolicy {
+	if value, ok := os.LookupEnv(key); ok {
+		if p, ok := backend.ParseHSTS(value); ok {
+			return p
+		}
+	}
+
+	p, _ := backend.ParseHSTS(def)
+	return p
+}
@@ -1,31 +1,47 @@
 package main
 
 import (
+	"context"
 	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
 	"golang.org/x/net/http2"
 
 	"github.com/docker/docker/client"
 	"github.com/icecave/honeycomb/backend"
 	"github.com/icecave/honeycomb/cmd"
+	"github.com/icecave/honeycomb/consul"
 	"github.com/icecave/honeycomb/docker"
 	"github.com/icecave/honeycomb/docker/health"
 	"github.com/icecave/honeycomb/frontend"
 	"github.com/icecave/honeycomb/frontend/cert"
 	"github.com/icecave/honeycomb/frontend/cert/generator"
+	"github.com/icecave/honeycomb/kubernetes"
+	"github.com/icecave/honeycomb/maintenance"
+	"github.com/icecave/honeycomb/name"
 	"github.com/icecave/honeycomb/proxy"
 	"github.com/icecave/honeycomb/proxyprotocol"
+	"github.com/icecave/honeycomb/rule"
 	"github.com/icecave/honeycomb/static"
 	"go.uber.org/multierr"
+	k8s "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 var version = "notset"
@@ -39,6 +55,11 @@ func main() {
 		logger.Fatalln(err)
 	}
 
+	staticRules, err := static.RulesFromEnv(logger)
+	if err != nil {
+		logger.Fatalln(err)
+	}
+
 	dockerClient, err := client.NewClientWithOpts(dockerClientFromEnvironment)
 	if err != nil {
 		logger.Fatalln(err)
@@ -60,11 +81,57 @@ func main() {
 	go dockerLocator.Run()
 	defer dockerLocator.Stop()
 
-	cachingLocator.Next = backend.AggregateLocator{
+	dockerScaler := &docker.Scaler{
+		Client:  dockerClient,
+		Locator: dockerLocator,
+		Logger:  logger,
+	}
+	go dockerScaler.Run()
+	defer dockerScaler.Stop()
+
+	locators := backend.AggregateLocator{
 		staticLocator,
 		dockerLocator,
 	}
 
+	kubernetesCertProvider := &cert.MemoryProvider{}
+
+	if config.Kubernetes.Enabled {
+		kubernetesClient, err := kubernetesClientFromConfig(config)
+		if err != nil {
+			logger.Fatalln(err)
+		}
+
+		kubernetesLocator := &kubernetes.Locator{
+			Client:       kubernetesClient,
+			Namespace:    config.Kubernetes.Namespace,
+			IngressClass: config.Kubernetes.IngressClass,
+			Cache:        cachingLocator,
+			Certificates: kubernetesCertProvider,
+			Logger:       logger,
+		}
+		go kubernetesLocator.Run()
+		defer kubernetesLocator.Stop()
+
+		locators = append(locators, kubernetesLocator)
+	}
+
+	if config.Consul.Enabled {
+		consulLocator := &consul.Locator{
+			Address:    config.Consul.Address,
+			Token:      config.Consul.Token,
+			Datacenter: config.Consul.Datacenter,
+			Cache:      cachingLocator,
+			Logger:     logger,
+		}
+		go consulLocator.Run()
+		defer consulLocator.Stop()
+
+		locators = append(locators, consulLocator)
+	}
+
+	cachingLocator.Next = locators
+
 	defaultCertificate, err := loadDefaultCertificate(config)
 	if err != nil {
 		logger.Fatalln(err)
@@ -80,7 +147,10 @@ func main() {
 	}
 
 	providerAdaptor := &cert.ProviderAdaptor{
-		PrimaryProvider:   primaryCertificateProvider(config, logger),
+		PrimaryProvider: cert.AggregateProvider{
+			primaryCertificateProvider(config, logger),
+			kubernetesCertProvider,
+		},
 		SecondaryProvider: secondaryCertProvider,
 	}
 
@@ -92,29 +162,72 @@ func main() {
 		RootCAs:        rootCACertPool,
 	}
 
-	secureTransport := &http.Transport{
-		Proxy:                 http.DefaultTransport.(*http.Transport).Proxy,
-		DialContext:           http.DefaultTransport.(*http.Transport).DialContext,
-		MaxIdleConns:          http.DefaultTransport.(*http.Transport).MaxIdleConns,
-		IdleConnTimeout:       http.DefaultTransport.(*http.Transport).IdleConnTimeout,
-		TLSHandshakeTimeout:   http.DefaultTransport.(*http.Transport).TLSHandshakeTimeout,
-		ExpectContinueTimeout: http.DefaultTransport.(*http.Transport).ExpectContinueTimeout,
-		TLSClientConfig: &tls.Config{
+	// Dial and TLS handshake timeouts are enforced per-request by the
+	// proxies, so that they can be overridden for each route.
+	upstreamDialer := &net.Dialer{KeepAlive: 30 * time.Second}
+
+	upstreamTimeouts := backend.Timeouts{
+		Dial:           config.Timeouts.Dial,
+		TLSHandshake:   config.Timeouts.TLSHandshake,
+		ResponseHeader: config.Timeouts.ResponseHeader,
+		Idle:           config.Timeouts.Idle,
+		Total:          config.Timeouts.Total,
+	}
+
+	// The connection limiter is shared by both websocket proxies, so that the
+	// limits apply regardless of the endpoint's TLS mode.
+	webSocketLimits := backend.WebSocketLimits{
+		IdleTimeout:             config.WebSocket.IdleTimeout,
+		PingInterval:            config.WebSocket.PingInterval,
+		MaxLifetime:             config.WebSocket.MaxLifetime,
+		MaxMessageSize:          config.WebSocket.MaxMessageSize,
+		Inspect:                 config.WebSocket.Inspect,
+		MaxConnections:          int(config.WebSocket.MaxConnections),
+		MaxConnectionsPerClient: int(config.WebSocket.MaxConnectionsPerClient),
+	}
+	webSocketLimiter := &proxy.ConnectionLimiter{}
+
+	securityHeaders := backend.SecurityHeaders{
+		HSTS:                  config.SecurityHeaders.HSTS,
+		ContentSecurityPolicy: config.SecurityHeaders.ContentSecurityPolicy,
+		FrameOptions:          config.SecurityHeaders.FrameOptions,
+		ContentTypeOptions:    config.SecurityHeaders.ContentTypeOptions,
+		ReferrerPolicy:        config.SecurityHeaders.ReferrerPolicy,
+		PermissionsPolicy:     config.SecurityHeaders.PermissionsPolicy,
+		Preserve:              &config.SecurityHeaders.Preserve,
+	}
+
+	secureTransport, secureHTTP1Transport, secureHTTP2Transport := upstreamTransports(
+		&tls.Config{
 			RootCAs: rootCACertPool,
 		},
-	}
+		upstreamDialer,
+	)
 
-	insecureTransport := &http.Transport{
-		Proxy:                 http.DefaultTransport.(*http.Transport).Proxy,
-		DialContext:           http.DefaultTransport.(*http.Transport).DialContext,
-		MaxIdleConns:          http.DefaultTransport.(*http.Transport).MaxIdleConns,
-		IdleConnTimeout:       http.DefaultTransport.(*http.Transport).IdleConnTimeout,
-		TLSHandshakeTimeout:   http.DefaultTransport.(*http.Transport).TLSHandshakeTimeout,
-		ExpectContinueTimeout: http.DefaultTransport.(*http.Transport).ExpectContinueTimeout,
-		TLSClientConfig: &tls.Config{
+	insecureTransport, insecureHTTP1Transport, insecureHTTP2Transport := upstreamTransports(
+		&tls.Config{
 			RootCAs:            rootCACertPool,
 			InsecureSkipVerify: true,
 		},
+		upstreamDialer,
+	)
+
+	// WebSocket connections are proxied at the connection level, so they must
+	// always use HTTP/1.1.
+	webSocketTLSConfig := &tls.Config{
+		RootCAs:    rootCACertPool,
+		NextProtos: []string{"http/1.1"},
+	}
+
+	// Except for HTTP/2 clients proxied to endpoints that must use HTTP/2,
+	// which use RFC 8441 extended CONNECT requests.
+	webSocketHTTP2Transport := &http.Transport{
+		DialContext:       upstreamDialer.DialContext,
+		ForceAttemptHTTP2: true,
+		TLSClientConfig: &tls.Config{
+			RootCAs:    rootCACertPool,
+			NextProtos: []string{"h2"},
+		},
 	}
 
 	h2cTransport := &http2.Transport{
@@ -126,45 +239,126 @@ func main() {
 
 	prepareTLSConfig(config, tlsConfig)
 
-	server := http.Server{
-		Addr:      ":" + config.Port,
-		TLSConfig: tlsConfig,
-		Handler: &frontend.Handler{
-			Proxy: &proxy.Handler{
-				Locator: cachingLocator,
-				SecureHTTPProxy: &proxy.HTTPProxy{
-					Transport: secureTransport,
-				},
-				InsecureHTTPProxy: &proxy.HTTPProxy{
-					Transport: insecureTransport,
-				},
-				H2CProxy: &proxy.HTTPProxy{
-					Transport: h2cTransport,
-				},
-				SecureWebSocketProxy: &proxy.WebSocketProxy{
-					Dialer: &proxy.BasicWebSocketDialer{
-						TLSConfig: secureTransport.TLSClientConfig,
-					},
-				},
-				InsecureWebSocketProxy: &proxy.WebSocketProxy{
-					Dialer: &proxy.BasicWebSocketDialer{
-						TLSConfig: secureTransport.TLSClientConfig,
-					},
-				},
-				Logger: logger,
+	maintenanceRegistry := &maintenance.Registry{}
+
+	var retryPolicy *proxy.RetryPolicy
+	if config.Retries.Attempts > 1 {
+		retryPolicy = &proxy.RetryPolicy{
+			Attempts:    int(config.Retries.Attempts),
+			Budget:      config.Retries.Budget,
+			Backoff:     config.Retries.Backoff,
+			StatusCodes: config.Retries.StatusCodes,
+		}
+	}
+
+	var outlierDetector *proxy.OutlierDetector
+	if config.Outliers.ConsecutiveFailures > 0 {
+		outlierDetector = &proxy.OutlierDetector{
+			ConsecutiveFailures: int(config.Outliers.ConsecutiveFailures),
+			BaseEjectionTime:    config.Outliers.BaseEjectionTime,
+			MaxEjectionTime:     config.Outliers.MaxEjectionTime,
+			Logger:              logger,
+		}
+	}
+
+	proxyHandler := &proxy.Handler{
+		Locator: cachingLocator,
+		Rules: rule.AggregateLocator{
+			staticRules,
+			dockerLocator,
+		},
+		SecureHTTPProxy: &proxy.HTTPProxy{
+			Transport:       secureTransport,
+			HTTP1Transport:  secureHTTP1Transport,
+			HTTP2Transport:  secureHTTP2Transport,
+			Retry:           retryPolicy,
+			Timeouts:        upstreamTimeouts,
+			FlushInterval:   config.FlushInterval,
+			SecurityHeaders: securityHeaders,
+		},
+		InsecureHTTPProxy: &proxy.HTTPProxy{
+			Transport:       insecureTransport,
+			HTTP1Transport:  insecureHTTP1Transport,
+			HTTP2Transport:  insecureHTTP2Transport,
+			Retry:           retryPolicy,
+			Timeouts:        upstreamTimeouts,
+			FlushInterval:   config.FlushInterval,
+			SecurityHeaders: securityHeaders,
+		},
+		H2CProxy: &proxy.HTTPProxy{
+			Transport:       h2cTransport,
+			Retry:           retryPolicy,
+			Timeouts:        upstreamTimeouts,
+			FlushInterval:   config.FlushInterval,
+			SecurityHeaders: securityHeaders,
+		},
+		SecureWebSocketProxy: &proxy.WebSocketProxy{
+			Dialer: &proxy.BasicWebSocketDialer{
+				TLSConfig: webSocketTLSConfig,
 			},
-			HealthCheck: &health.HTTPHandler{
-				Checker: &health.SwarmChecker{
-					Client: dockerClient,
-				},
-				Logger: logger,
+			HTTP2Transport:    webSocketHTTP2Transport,
+			Timeouts:          upstreamTimeouts,
+			Limits:            webSocketLimits,
+			ConnectionLimiter: webSocketLimiter,
+			SecurityHeaders:   securityHeaders,
+		},
+		InsecureWebSocketProxy: &proxy.WebSocketProxy{
+			Dialer: &proxy.BasicWebSocketDialer{
+				TLSConfig: webSocketTLSConfig,
+			},
+			HTTP2Transport:    webSocketHTTP2Transport,
+			Timeouts:          upstreamTimeouts,
+			Limits:            webSocketLimits,
+			ConnectionLimiter: webSocketLimiter,
+			SecurityHeaders:   securityHeaders,
+		},
+		Activator:       dockerScaler,
+		StartTimeout:    config.StartTimeout,
+		StartingMessage: config.StartingMessage,
+		Maintenance:     maintenanceRegistry,
+		AffinityKey:     []byte(config.AffinityKey),
+		OutlierDetector: outlierDetector,
+		Logger:          logger,
+	}
+
+	handler := &frontend.Handler{
+		Proxy: proxyHandler,
+		Maintenance: &maintenance.HTTPHandler{
+			Registry: maintenanceRegistry,
+			Token:    config.MaintenanceToken,
+			Logger:   logger,
+		},
+		HealthCheck: &health.HTTPHandler{
+			Checker: &health.SwarmChecker{
+				Client:  dockerClient,
+				Locator: dockerLocator,
 			},
 			Logger: logger,
 		},
-		ErrorLog: logger,
+		Logger: logger,
+	}
+
+	if config.HTTP3.Enabled {
+		handler.AltSvc = fmt.Sprintf(
+			`h3=":%s"; ma=%d`,
+			config.HTTP3.AdvertisedPort,
+			int(config.HTTP3.AltSvcMaxAge.Seconds()),
+		)
+	}
+
+	server := &http.Server{
+		Addr:      ":" + config.Port,
+		TLSConfig: tlsConfig,
+		Handler:   handler,
+		ErrorLog:  logger,
 	}
 
-	go redirectServer(config, logger)
+	insecureHandler := &proxy.InsecureHandler{
+		Handler: proxyHandler,
+		Allowed: httpServeMatchers(config, logger),
+	}
+
+	redirect := redirectServer(config, insecureHandler, logger)
 
 	listener, err := net.Listen("tcp", ":"+config.Port)
 	if err != nil {
@@ -177,9 +371,43 @@ func main() {
 
 	logger.Printf("Listening on port %s", config.Port)
 
-	err = server.ServeTLS(listener, "", "")
-	if err != nil {
-		logger.Fatalln(err)
+	// The HTTP/2 server only advertises support for extended CONNECT requests
+	// (RFC 8441) when this GODEBUG setting is present at startup.
+	if !strings.Contains(os.Getenv("GODEBUG"), "http2xconnect=1") {
+		logger.Println("WebSockets over HTTP/2 are disabled, set GODEBUG=http2xconnect=1 to enable them")
+	}
+
+	go func() {
+		if err := server.ServeTLS(listener, "", ""); err != http.ErrServerClosed {
+			logger.Fatalln(err)
+		}
+	}()
+
+	var h3 *http3Listener
+	if config.HTTP3.Enabled {
+		h3 = http3Server(config, tlsConfig, handler, logger)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	logger.Printf("Received %s, shutting down", <-signals)
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+
+	h3Done := make(chan error, 1)
+	if h3 != nil {
+		go func() { h3Done <- h3.Shutdown(ctx) }()
+	} else {
+		h3Done <- nil
+	}
+
+	if err := multierr.Combine(
+		server.Shutdown(ctx),
+		redirect.Shutdown(ctx),
+		<-h3Done,
+	); err != nil {
+		logger.Println(err)
 	}
 }
 
@@ -194,6 +422,17 @@ func dockerClientFromEnvironment(c *client.Client) error {
 	)
 }
 
+func kubernetesClientFromConfig(config *cmd.Config) (k8s.Interface, error) {
+	restConfig, err := clientcmd.BuildConfigFromFlags("", config.Kubernetes.ConfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	restConfig.UserAgent = fmt.Sprintf("Honeycomb/%s", version)
+
+	return k8s.NewForConfig(restConfig)
+}
+
 func loadDefaultCertificate(config *cmd.Config) (*tls.Certificate, error) {
 	cert, err := tls.LoadX509KeyPair(
 		path.Join(config.Certificates.BasePath, config.Certificates.ServerCertificate),
@@ -253,6 +492,40 @@ func secondaryCertificateProvider(
 	}, nil
 }
 
+// upstreamTransports returns the transports used to send requests to upstream
+// servers that use TLS. The first negotiates HTTP/2 or HTTP/1.1 using ALPN, the
+// second only uses HTTP/1.1 and the third only uses HTTP/2.
+func upstreamTransports(
+	tlsConfig *tls.Config,
+	dialer *net.Dialer,
+) (http.RoundTripper, http.RoundTripper, http.RoundTripper) {
+	newTransport := func() *http.Transport {
+		return &http.Transport{
+			Proxy:                 http.DefaultTransport.(*http.Transport).Proxy,
+			DialContext:           dialer.DialContext,
+			MaxIdleConns:          http.DefaultTransport.(*http.Transport).MaxIdleConns,
+			IdleConnTimeout:       http.DefaultTransport.(*http.Transport).IdleConnTimeout,
+			ExpectContinueTimeout: http.DefaultTransport.(*http.Transport).ExpectContinueTimeout,
+			TLSClientConfig:       tlsConfig.Clone(),
+		}
+	}
+
+	// A custom TLS config disables HTTP/2 unless it is explicitly requested.
+	auto := newTransport()
+	auto.ForceAttemptHTTP2 = true
+
+	// A non-nil, empty TLSNextProto map disables HTTP/2.
+	http1 := newTransport()
+	http1.TLSClientConfig.NextProtos = []string{"http/1.1"}
+	http1.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+
+	http2Transport := &http2.Transport{
+		TLSClientConfig: tlsConfig.Clone(),
+	}
+
+	return auto, http1, http2Transport
+}
+
 func prepareTLSConfig(config *cmd.Config, tlsConfig *tls.Config) {
 	tlsConfig.NextProtos = []string{"h2"}
 	tlsConfig.MinVersion = config.MinTLSVersion
@@ -284,26 +557,143 @@ func rootCAPool(
 	return pool
 }
 
-func redirectServer(config *cmd.Config, logger *log.Logger) {
-	listener, err := net.Listen("tcp", ":"+config.InsecurePort)
+// http3Server starts an HTTP/3 server that listens for QUIC connections on
+// UDP, using the same TLS configuration and handler as the TCP listener.
+//
+// The PROXY protocol is not supported over UDP, the client address is always
+// the source address of the QUIC connection.
+func http3Server(
+	config *cmd.Config,
+	tlsConfig *tls.Config,
+	handler http.Handler,
+	logger *log.Logger,
+) *http3Listener {
+	conn, err := net.ListenPacket("udp", ":"+config.HTTP3.Port)
 	if err != nil {
 		logger.Fatal(err)
 	}
 
-	if config.ProxyProtocol {
-		listener = proxyprotocol.NewListener(listener)
+	// The transport is created explicitly, rather than by the server, so that
+	// closing the listener does not close the connections that are already
+	// established.
+	transport := &quic.Transport{Conn: conn}
+
+	// ALPN is configured for HTTP/3, and TLS 1.3 is always used regardless of
+	// the configured versions. 0-RTT is disabled, as early data may be
+	// replayed by an attacker and requests are forwarded without regard for
+	// their idempotency.
+	listener, err := transport.ListenEarly(
+		http3.ConfigureTLSConfig(tlsConfig.Clone()),
+		&quic.Config{},
+	)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	l := &http3Listener{
+		transport: transport,
+		handler:   handler,
+	}
+	l.server = &http3.Server{Handler: l}
+
+	logger.Printf("Listening for HTTP/3 on UDP port %s", config.HTTP3.Port)
+
+	go func() {
+		err := l.server.ServeListener(listener)
+		if err != http.ErrServerClosed && !errors.Is(err, quic.ErrServerClosed) {
+			logger.Fatalln(err)
+		}
+	}()
+
+	return l
+}
+
+// http3Listener serves HTTP/3 requests, keeping track of the requests in
+// progress so that it can be shut down gracefully.
+type http3Listener struct {
+	server    *http3.Server
+	transport *quic.Transport
+	handler   http.Handler
+	active    int64
+}
+
+func (l *http3Listener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt64(&l.active, 1)
+	defer atomic.AddInt64(&l.active, -1)
+
+	l.handler.ServeHTTP(w, r)
+}
+
+// Shutdown stops accepting new connections, then waits for the requests in
+// progress to complete, or for ctx to be canceled, before closing all of the
+// connections.
+func (l *http3Listener) Shutdown(ctx context.Context) error {
+	var timeout time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
 	}
 
-	http.Serve(
-		listener,
-		http.HandlerFunc(redirectHandler),
+	// CloseGracefully does not yet wait for requests in this version of
+	// quic-go, so they are also waited for below.
+	err := multierr.Combine(
+		l.server.CloseGracefully(timeout),
+		l.server.Close(),
 	)
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for atomic.LoadInt64(&l.active) > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return multierr.Combine(err, ctx.Err(), l.transport.Close())
+		}
+	}
+
+	return multierr.Append(err, l.transport.Close())
+}
+
+// httpServeMatchers returns matchers for the server names that are served over
+// plain HTTP, regardless of the endpoint's HTTP mode.
+func httpServeMatchers(config *cmd.Config, logger *log.Logger) []*name.Matcher {
+	var matchers []*name.Matcher
+
+	for _, pattern := range config.HTTPServeHosts {
+		m, err := name.NewMatcher(pattern)
+		if err != nil {
+			logger.Fatalln(err)
+		}
+
+		logger.Printf("Serving '%s' over plain HTTP", pattern)
+		matchers = append(matchers, m)
+	}
+
+	return matchers
 }
 
-func redirectHandler(w http.ResponseWriter, req *http.Request) {
-	target := "https://" + req.Host + req.URL.Path
-	if len(req.URL.RawQuery) > 0 {
-		target += "?" + req.URL.RawQuery
+// redirectServer starts the server for requests received over plain HTTP,
+// which are redirected to HTTPS unless the endpoint is served over plain HTTP.
+func redirectServer(
+	config *cmd.Config,
+	handler http.Handler,
+	logger *log.Logger,
+) *http.Server {
+	listener, err := net.Listen("tcp", ":"+config.InsecurePort)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	if config.ProxyProtocol {
+		listener = proxyprotocol.NewListener(listener)
 	}
-	http.Redirect(w, req, target, http.StatusTemporaryRedirect)
+
+	server := &http.Server{
+		Handler:  handler,
+		ErrorLog: logger,
+	}
+
+	go server.Serve(listener)
+
+	return server
 }
@@ -0,0 +1,151 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// client performs blocking queries against the Consul HTTP API.
+type client struct {
+	Address    string
+	Token      string
+	Datacenter string
+	WaitTime   time.Duration
+	HTTPClient *http.Client
+}
+
+// catalogServices is the response of the catalog services API.
+type catalogServices map[string][]string
+
+// healthEntry is an entry in the response of the health service API.
+type healthEntry struct {
+	Node struct {
+		Node    string
+		Address string
+	}
+	Service struct {
+		ID      string
+		Service string
+		Address string
+		Port    int
+		Tags    []string
+		Meta    map[string]string
+	}
+}
+
+// Services returns the names and tags of all services in the catalog.
+func (c *client) Services(
+	ctx context.Context,
+	index uint64,
+) (catalogServices, uint64, error) {
+	var result catalogServices
+	index, err := c.query(ctx, "/v1/catalog/services", nil, index, &result)
+	return result, index, err
+}
+
+// PassingInstances returns the instances of a service that are passing all
+// of their health checks.
+func (c *client) PassingInstances(
+	ctx context.Context,
+	service string,
+	index uint64,
+) ([]healthEntry, uint64, error) {
+	var result []healthEntry
+	index, err := c.query(
+		ctx,
+		"/v1/health/service/"+url.PathEscape(service),
+		url.Values{"passing": {"true"}},
+		index,
+		&result,
+	)
+	return result, index, err
+}
+
+// query performs a blocking query, unmarshaling the response into out. It
+// returns the index to use for the next query.
+func (c *client) query(
+	ctx context.Context,
+	path string,
+	params url.Values,
+	index uint64,
+	out interface{},
+) (uint64, error) {
+	if params == nil {
+		params = url.Values{}
+	}
+
+	if index != 0 {
+		params.Set("index", strconv.FormatUint(index, 10))
+		params.Set("wait", fmt.Sprintf("%dms", c.WaitTime/time.Millisecond))
+	}
+
+	if c.Datacenter != "" {
+		params.Set("dc", c.Datacenter)
+	}
+
+	address := c.Address
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+
+	request, err := http.NewRequest(
+		http.MethodGet,
+		strings.TrimSuffix(address, "/")+path+"?"+params.Encode(),
+		nil,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	if c.Token != "" {
+		request.Header.Set("X-Consul-Token", c.Token)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	response, err := httpClient.Do(request.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf(
+			"consul request to '%s' failed with status %d",
+			path,
+			response.StatusCode,
+		)
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(out); err != nil {
+		return 0, err
+	}
+
+	next, err := strconv.ParseUint(response.Header.Get("X-Consul-Index"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf(
+			"consul response from '%s' has an invalid index, %s",
+			path,
+			err,
+		)
+	}
+
+	// Per the Consul documentation, the index must be reset if it goes
+	// backwards, and must always be greater than zero.
+	if next < index {
+		next = 0
+	} else if next == 0 {
+		next = 1
+	}
+
+	return next, nil
+}
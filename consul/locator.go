@@ -0,0 +1,275 @@
+package consul
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/icecave/honeycomb/backend"
+	"github.com/icecave/honeycomb/name"
+)
+
+// DefaultWaitTime is the default maximum duration of a blocking query.
+const DefaultWaitTime = 5 * time.Minute
+
+// DefaultRetryInterval is the default interval between retries of failed
+// queries.
+const DefaultRetryInterval = 5 * time.Second
+
+// Locator finds a back-end HTTP server based on the server name in TLS
+// requests (SNI) by watching the Consul service catalog.
+//
+// Services are routed via "honeycomb.*" tags or meta-data. Only instances that
+// are passing all of their health checks are routed to.
+type Locator struct {
+	// Address is the address of the Consul HTTP API, such as
+	// "http://127.0.0.1:8500".
+	Address string
+
+	// Token is the ACL token used to authenticate with Consul, if any.
+	Token string
+
+	// Datacenter is the Consul datacenter to query. If it is empty, the
+	// datacenter of the agent is used.
+	Datacenter string
+
+	// WaitTime is the maximum duration of a blocking query. If it is zero,
+	// DefaultWaitTime is used.
+	WaitTime time.Duration
+
+	// RetryInterval is the interval between retries of failed queries. If it
+	// is zero, DefaultRetryInterval is used.
+	RetryInterval time.Duration
+
+	// HTTPClient is the client used to contact Consul. If it is nil,
+	// http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	// Cache is cleared whenever the routes change.
+	Cache *backend.Cache
+
+	// Logger is the destination for messages about route changes.
+	Logger *log.Logger
+
+	once   sync.Once
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	m         sync.Mutex
+	instances map[string][]healthEntry
+	routes    atomic.Value // []route
+}
+
+// Locate finds the back-end HTTP server for the given server name.
+//
+// It returns a score indicating the strength of the match. A value of 0 or less
+// indicates that no match was made, in which case ep is nil.
+//
+// A non-zero score can be returned with a nil endpoint, indicating that the
+// request should not be routed.
+func (locator *Locator) Locate(
+	ctx context.Context,
+	serverName name.ServerName,
+) (ep *backend.Endpoint, score int) {
+	if routes, ok := locator.routes.Load().([]route); ok {
+		for _, r := range routes {
+			if s := r.Matcher.Match(serverName); s > score {
+				ep = r.Endpoint
+				score = s
+			}
+		}
+	}
+
+	return ep, score
+}
+
+// Run watches Consul for changes to routes until Stop() is called.
+func (locator *Locator) Run() {
+	locator.init()
+
+	c := locator.client()
+	watchers := map[string]context.CancelFunc{}
+	var index uint64
+
+	defer func() {
+		for _, cancel := range watchers {
+			cancel()
+		}
+	}()
+
+	for {
+		services, next, err := c.Services(locator.ctx, index)
+		if locator.ctx.Err() != nil {
+			return
+		} else if err != nil {
+			locator.Logger.Printf("Can not query Consul service catalog, %s", err)
+
+			if !locator.sleep(locator.ctx) {
+				return
+			}
+
+			continue
+		}
+
+		index = next
+
+		for service := range services {
+			if _, ok := watchers[service]; ok || service == "consul" {
+				continue
+			}
+
+			ctx, cancel := context.WithCancel(locator.ctx)
+			watchers[service] = cancel
+			go locator.watch(ctx, c, service)
+		}
+
+		for service, cancel := range watchers {
+			if _, ok := services[service]; !ok {
+				cancel()
+				delete(watchers, service)
+				locator.update(locator.ctx, service, nil)
+			}
+		}
+	}
+}
+
+// Stop shuts down the locator and cleans up any resources used.
+func (locator *Locator) Stop() {
+	locator.init()
+	locator.cancel()
+}
+
+// init initializes the locator's internal state, it is safe to call
+// concurrently.
+func (locator *Locator) init() {
+	locator.once.Do(func() {
+		locator.ctx, locator.cancel = context.WithCancel(context.Background())
+	})
+}
+
+// client returns the client used to query Consul.
+func (locator *Locator) client() *client {
+	waitTime := locator.WaitTime
+	if waitTime == 0 {
+		waitTime = DefaultWaitTime
+	}
+
+	return &client{
+		Address:    locator.Address,
+		Token:      locator.Token,
+		Datacenter: locator.Datacenter,
+		WaitTime:   waitTime,
+		HTTPClient: locator.HTTPClient,
+	}
+}
+
+// watch watches the passing instances of a single service until ctx is
+// canceled.
+func (locator *Locator) watch(ctx context.Context, c *client, service string) {
+	var index uint64
+
+	for {
+		entries, next, err := c.PassingInstances(ctx, service, index)
+		if ctx.Err() != nil {
+			return
+		} else if err != nil {
+			locator.Logger.Printf("Can not query Consul service '%s', %s", service, err)
+
+			if !locator.sleep(ctx) {
+				return
+			}
+
+			continue
+		}
+
+		if next != index {
+			locator.update(ctx, service, entries)
+		}
+
+		index = next
+	}
+}
+
+// update replaces the passing instances of a service and rebuilds the routes.
+// A nil entries slice removes the service. Updates from canceled watchers are
+// ignored.
+func (locator *Locator) update(
+	ctx context.Context,
+	service string,
+	entries []healthEntry,
+) {
+	locator.m.Lock()
+	defer locator.m.Unlock()
+
+	if entries == nil {
+		delete(locator.instances, service)
+	} else if ctx.Err() != nil {
+		return
+	} else {
+		if locator.instances == nil {
+			locator.instances = map[string][]healthEntry{}
+		}
+
+		locator.instances[service] = entries
+	}
+
+	old, _ := locator.routes.Load().([]route)
+	new := buildRoutes(locator.instances, locator.Logger)
+
+	if locator.diff(old, new) {
+		locator.routes.Store(new)
+
+		if locator.Cache != nil {
+			locator.Cache.Clear()
+		}
+	}
+}
+
+// sleep waits for the retry interval to elapse. It returns false if ctx is
+// canceled before then.
+func (locator *Locator) sleep(ctx context.Context) bool {
+	retryInterval := locator.RetryInterval
+	if retryInterval == 0 {
+		retryInterval = DefaultRetryInterval
+	}
+
+	select {
+	case <-time.After(retryInterval):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (locator *Locator) diff(old []route, new []route) bool {
+	diff := false
+
+	for _, r := range old {
+		if !containsRoute(new, r) {
+			diff = true
+			locator.Logger.Printf(
+				"Removed route from '%s' to '%s' (%s)",
+				r.Matcher.Pattern,
+				r.Name,
+				r.Endpoint.Description,
+			)
+		}
+	}
+
+	for _, r := range new {
+		if !containsRoute(old, r) {
+			diff = true
+			locator.Logger.Printf(
+				"Added route from '%s' to '%s' (%s)",
+				r.Matcher.Pattern,
+				r.Name,
+				r.Endpoint.Description,
+			)
+		}
+	}
+
+	return diff
+}
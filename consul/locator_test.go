@@ -0,0 +1,356 @@
+package consul_test
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/icecave/honeycomb/backend"
+	"github.com/icecave/honeycomb/consul"
+	"github.com/icecave/honeycomb/name"
+	"github.com/icecave/honeycomb/static"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Locator", func() {
+	var (
+		fake    *fakeConsul
+		server  *httptest.Server
+		subject *consul.Locator
+	)
+
+	locate := func(serverName string) func() *backend.Endpoint {
+		return func() *backend.Endpoint {
+			ep, _ := subject.Locate(context.Background(), name.Parse(serverName))
+			return ep
+		}
+	}
+
+	BeforeEach(func() {
+		fake = &fakeConsul{}
+		server = httptest.NewServer(fake)
+
+		subject = &consul.Locator{
+			Address:       server.URL,
+			WaitTime:      100 * time.Millisecond,
+			RetryInterval: 10 * time.Millisecond,
+			Cache:         &backend.Cache{},
+			Logger:        log.New(GinkgoWriter, "", 0),
+		}
+	})
+
+	AfterEach(func() {
+		subject.Stop()
+		server.Close()
+	})
+
+	Describe("Locate", func() {
+		It("routes to services with honeycomb tags", func() {
+			fake.Register("web", fakeInstance{
+				ID:      "web-1",
+				Address: "10.0.0.1",
+				Port:    8080,
+				Tags:    []string{"honeycomb.match=www.*", "other"},
+				Passing: true,
+			})
+
+			go subject.Run()
+
+			Eventually(locate("www.example.com")).Should(Equal(&backend.Endpoint{
+				Description: "web",
+				Address:     "10.0.0.1:8080",
+				TLSMode:     backend.TLSDisabled,
+			}))
+		})
+
+		It("routes to services with more than one match tag", func() {
+			fake.Register("web", fakeInstance{
+				ID:      "web-1",
+				Address: "10.0.0.1",
+				Port:    8080,
+				Tags:    []string{"honeycomb.match=www.*", "honeycomb.match=web.*"},
+				Passing: true,
+			})
+
+			go subject.Run()
+
+			expected := &backend.Endpoint{
+				Description: "web",
+				Address:     "10.0.0.1:8080",
+				TLSMode:     backend.TLSDisabled,
+			}
+
+			Eventually(locate("www.example.com")).Should(Equal(expected))
+			Eventually(locate("web.example.com")).Should(Equal(expected))
+		})
+
+		It("routes to services with honeycomb meta-data", func() {
+			fake.Register("api", fakeInstance{
+				ID:      "api-1",
+				Address: "10.0.0.1",
+				Port:    8443,
+				Meta: map[string]string{
+					"honeycomb-match":       "api.*",
+					"honeycomb-description": "The API",
+				},
+				Passing: true,
+			})
+
+			go subject.Run()
+
+			Eventually(locate("api.example.com")).Should(Equal(&backend.Endpoint{
+				Description: "The API",
+				Address:     "10.0.0.1:8443",
+				TLSMode:     backend.TLSEnabled,
+			}))
+		})
+
+		It("routes only to passing instances", func() {
+			fake.Register("web",
+				fakeInstance{
+					ID:      "web-1",
+					Address: "10.0.0.1",
+					Port:    80,
+					Tags:    []string{"honeycomb.match=www.*"},
+					Passing: true,
+				},
+				fakeInstance{
+					ID:      "web-2",
+					Address: "10.0.0.2",
+					Port:    80,
+					Tags:    []string{"honeycomb.match=www.*"},
+					Passing: false,
+				},
+			)
+
+			go subject.Run()
+
+			Eventually(locate("www.example.com")).ShouldNot(BeNil())
+			Expect(locate("www.example.com")().Address).To(Equal("10.0.0.1:80"))
+		})
+
+		It("distributes requests across multiple passing instances", func() {
+			fake.Register("web",
+				fakeInstance{
+					ID:      "web-1",
+					Address: "10.0.0.1",
+					Port:    80,
+					Tags:    []string{"honeycomb.match=www.*"},
+					Passing: true,
+				},
+				fakeInstance{
+					ID:      "web-2",
+					Address: "10.0.0.2",
+					Port:    80,
+					Tags:    []string{"honeycomb.match=www.*"},
+					Passing: true,
+				},
+			)
+
+			go subject.Run()
+
+			Eventually(locate("www.example.com")).ShouldNot(BeNil())
+
+			ep := locate("www.example.com")()
+			Expect(ep.Alternatives).To(HaveLen(2))
+			Expect(ep.Alternatives[0].Address).To(Equal("10.0.0.1:80"))
+			Expect(ep.Alternatives[1].Address).To(Equal("10.0.0.2:80"))
+		})
+
+		It("applies the session affinity settings to the instances", func() {
+			tags := []string{
+				"honeycomb.match=www.*",
+				"honeycomb.affinity=cookie",
+				"honeycomb.affinity-ttl=1h",
+			}
+			meta := map[string]string{
+				"honeycomb-affinity-cookie": "session",
+			}
+
+			fake.Register("web",
+				fakeInstance{ID: "web-1", Address: "10.0.0.1", Port: 80, Tags: tags, Meta: meta, Passing: true},
+				fakeInstance{ID: "web-2", Address: "10.0.0.2", Port: 80, Tags: tags, Meta: meta, Passing: true},
+			)
+
+			go subject.Run()
+
+			Eventually(locate("www.example.com")).ShouldNot(BeNil())
+
+			ep := locate("www.example.com")()
+			Expect(ep.Affinity).To(Equal(&backend.Affinity{
+				Mode:       backend.AffinityCookie,
+				CookieName: "session",
+				CookieTTL:  time.Hour,
+			}))
+		})
+
+		It("removes routes when the instances stop passing", func() {
+			instance := fakeInstance{
+				ID:      "web-1",
+				Address: "10.0.0.1",
+				Port:    80,
+				Tags:    []string{"honeycomb.match=www.*"},
+				Passing: true,
+			}
+			fake.Register("web", instance)
+
+			go subject.Run()
+
+			Eventually(locate("www.example.com")).ShouldNot(BeNil())
+
+			instance.Passing = false
+			fake.Register("web", instance)
+
+			Eventually(locate("www.example.com")).Should(BeNil())
+		})
+
+		It("removes routes when the service is deregistered", func() {
+			fake.Register("web", fakeInstance{
+				ID:      "web-1",
+				Address: "10.0.0.1",
+				Port:    80,
+				Tags:    []string{"honeycomb.match=www.*"},
+				Passing: true,
+			})
+
+			go subject.Run()
+
+			Eventually(locate("www.example.com")).ShouldNot(BeNil())
+
+			fake.Register("web")
+
+			Eventually(locate("www.example.com")).Should(BeNil())
+		})
+
+		It("combines with other locators", func() {
+			fake.Register("web", fakeInstance{
+				ID:      "web-1",
+				Address: "10.0.0.1",
+				Port:    80,
+				Tags:    []string{"honeycomb.match=www.example.com"},
+				Passing: true,
+			})
+
+			go subject.Run()
+
+			Eventually(locate("www.example.com")).ShouldNot(BeNil())
+
+			aggregate := backend.AggregateLocator{
+				static.Locator{}.With("*.example.com", &backend.Endpoint{Address: "static:80"}),
+				subject,
+			}
+
+			ep, _ := aggregate.Locate(context.Background(), name.Parse("www.example.com"))
+			Expect(ep.Address).To(Equal("10.0.0.1:80"))
+
+			ep, _ = aggregate.Locate(context.Background(), name.Parse("other.example.com"))
+			Expect(ep.Address).To(Equal("static:80"))
+		})
+	})
+})
+
+type fakeInstance struct {
+	ID      string
+	Address string
+	Port    int
+	Tags    []string
+	Meta    map[string]string
+	Passing bool
+}
+
+// fakeConsul is an HTTP handler that implements the parts of the Consul HTTP
+// API used by the locator, including blocking queries.
+type fakeConsul struct {
+	m        sync.Mutex
+	index    uint64
+	changed  chan struct{}
+	services map[string][]fakeInstance
+}
+
+// Register replaces the instances of a service. If no instances are given, the
+// service is deregistered.
+func (f *fakeConsul) Register(service string, instances ...fakeInstance) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if f.services == nil {
+		f.services = map[string][]fakeInstance{}
+	}
+
+	if len(instances) == 0 {
+		delete(f.services, service)
+	} else {
+		f.services[service] = instances
+	}
+
+	f.index++
+
+	if f.changed != nil {
+		close(f.changed)
+		f.changed = nil
+	}
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	index, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+
+	f.m.Lock()
+	if index != 0 && index >= f.index {
+		if f.changed == nil {
+			f.changed = make(chan struct{})
+		}
+		changed := f.changed
+		f.m.Unlock()
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		f.m.Lock()
+	}
+	defer f.m.Unlock()
+
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index+1, 10))
+
+	if r.URL.Path == "/v1/catalog/services" {
+		result := map[string][]string{"consul": {}}
+		for service, instances := range f.services {
+			result[service] = instances[0].Tags
+		}
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+
+	service := strings.TrimPrefix(r.URL.Path, "/v1/health/service/")
+	result := []map[string]interface{}{}
+
+	for _, instance := range f.services[service] {
+		if instance.Passing || r.URL.Query().Get("passing") != "true" {
+			result = append(result, map[string]interface{}{
+				"Node": map[string]interface{}{
+					"Node":    "node",
+					"Address": "10.0.0.254",
+				},
+				"Service": map[string]interface{}{
+					"ID":      instance.ID,
+					"Service": service,
+					"Address": instance.Address,
+					"Port":    instance.Port,
+					"Tags":    instance.Tags,
+					"Meta":    instance.Meta,
+				},
+			})
+		}
+	}
+
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,97 @@
+package consul
+
+import "strings"
+
+// Routing metadata may be given as service tags of the form "<name>=<value>",
+// or as service meta-data. Consul does not allow dots in meta-data keys, so
+// hyphens are used in their place, such as "honeycomb-affinity-cookie".
+const (
+	matchKey       = "honeycomb.match"
+	portKey        = "honeycomb.port"
+	tlsKey         = "honeycomb.tls"
+	descriptionKey = "honeycomb.description"
+
+	affinityKey       = "honeycomb.affinity"
+	affinityCookieKey = "honeycomb.affinity-cookie"
+	affinityTTLKey    = "honeycomb.affinity-ttl"
+)
+
+// hyphenatedKeys are the keys that contain hyphens, which can not be
+// distinguished from dots in meta-data keys.
+var hyphenatedKeys = []string{
+	affinityCookieKey,
+	affinityTTLKey,
+}
+
+// metadata returns the honeycomb routing meta-data from a service's tags and
+// meta-data. Meta-data takes precedence over tags.
+func metadata(tags []string, meta map[string]string) map[string]string {
+	result := map[string]string{}
+
+	for _, tag := range tags {
+		parts := strings.SplitN(tag, "=", 2)
+		if len(parts) == 2 && isHoneycombKey(parts[0]) {
+			result[parts[0]] = parts[1]
+		}
+	}
+
+	for key, value := range meta {
+		key = metaKey(key)
+		if isHoneycombKey(key) {
+			result[key] = value
+		}
+	}
+
+	return result
+}
+
+// matchPatterns returns the match patterns from a service's tags and its
+// routing meta-data md. Unlike other keys, the same match key may be given in
+// more than one tag.
+func matchPatterns(tags []string, md map[string]string) []string {
+	var patterns []string
+	seen := map[string]bool{}
+
+	add := func(pattern string) {
+		if !seen[pattern] {
+			seen[pattern] = true
+			patterns = append(patterns, pattern)
+		}
+	}
+
+	for _, tag := range tags {
+		parts := strings.SplitN(tag, "=", 2)
+		if len(parts) == 2 && isMatchKey(parts[0]) {
+			add(parts[1])
+		}
+	}
+
+	for key, pattern := range md {
+		if isMatchKey(key) {
+			add(pattern)
+		}
+	}
+
+	return patterns
+}
+
+// metaKey returns the honeycomb key for a service meta-data key.
+func metaKey(key string) string {
+	for _, k := range hyphenatedKeys {
+		if key == strings.Replace(k, ".", "-", -1) {
+			return k
+		}
+	}
+
+	return strings.Replace(key, "-", ".", -1)
+}
+
+// isHoneycombKey returns true if key is used for honeycomb meta-data.
+func isHoneycombKey(key string) bool {
+	return strings.HasPrefix(key, "honeycomb.")
+}
+
+// isMatchKey returns true if key is used for a match pattern.
+func isMatchKey(key string) bool {
+	return key == matchKey || strings.HasPrefix(key, matchKey+".")
+}
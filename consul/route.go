@@ -0,0 +1,228 @@
+package consul
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/icecave/honeycomb/backend"
+	"github.com/icecave/honeycomb/name"
+)
+
+// route is a mapping of a server name pattern to the instances of a Consul
+// service.
+type route struct {
+	Name     string
+	Matcher  *name.Matcher
+	Endpoint *backend.Endpoint
+}
+
+// Equal checks if two routes are identical.
+func (r route) Equal(other route) bool {
+	return r.Name == other.Name &&
+		*r.Matcher == *other.Matcher &&
+		r.Endpoint.Equal(other.Endpoint)
+}
+
+// containsRoute returns true if routes contains a route equal to r.
+func containsRoute(routes []route, r route) bool {
+	for _, other := range routes {
+		if r.Equal(other) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// buildRoutes returns the routes for the given passing service instances,
+// keyed by service name.
+func buildRoutes(
+	instances map[string][]healthEntry,
+	logger *log.Logger,
+) []route {
+	var services []string
+	for service := range instances {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	var routes []route
+
+	for _, service := range services {
+		var patterns []string
+		endpoints := map[string][]*backend.Endpoint{}
+
+		for _, entry := range instances[service] {
+			md := metadata(entry.Service.Tags, entry.Service.Meta)
+			matches := matchPatterns(entry.Service.Tags, md)
+
+			if len(matches) == 0 {
+				continue
+			}
+
+			ep, err := instanceEndpoint(entry, md)
+			if err != nil {
+				logger.Printf(
+					"Can not route to '%s' (%s), %s",
+					service,
+					entry.Service.ID,
+					err,
+				)
+				continue
+			}
+
+			for _, pattern := range matches {
+				if _, ok := endpoints[pattern]; !ok {
+					patterns = append(patterns, pattern)
+				}
+
+				endpoints[pattern] = append(endpoints[pattern], ep)
+			}
+		}
+
+		sort.Strings(patterns)
+
+		for _, pattern := range patterns {
+			matcher, err := name.NewMatcher(pattern)
+			if err != nil {
+				logger.Printf(
+					"Can not route to '%s' via '%s', %s",
+					service,
+					pattern,
+					err,
+				)
+				continue
+			}
+
+			eps := endpoints[pattern]
+			sort.Slice(eps, func(i, j int) bool {
+				return eps[i].Address < eps[j].Address
+			})
+
+			ep := eps[0]
+			if len(eps) > 1 {
+				ep = &backend.Endpoint{
+					Description:  eps[0].Description,
+					Alternatives: eps,
+				}
+
+				for _, alt := range eps {
+					alt.Weight = 1
+
+					if ep.Affinity == nil {
+						ep.Affinity = alt.Affinity
+					}
+				}
+			}
+
+			routes = append(routes, route{
+				Name:     service,
+				Matcher:  matcher,
+				Endpoint: ep,
+			})
+		}
+	}
+
+	return routes
+}
+
+// instanceEndpoint returns the endpoint for a single service instance.
+func instanceEndpoint(
+	entry healthEntry,
+	md map[string]string,
+) (*backend.Endpoint, error) {
+	host := entry.Service.Address
+	if host == "" {
+		host = entry.Node.Address
+	}
+
+	port := strconv.Itoa(entry.Service.Port)
+	if value, ok := md[portKey]; ok {
+		if _, err := net.LookupPort("tcp", value); err != nil {
+			return nil, fmt.Errorf(
+				"invalid '%s' value (%s), expected port name or number",
+				portKey,
+				value,
+			)
+		}
+
+		port = value
+	}
+
+	tlsMode := backend.TLSDisabled
+	if value, ok := md[tlsKey]; ok {
+		mode, ok := backend.ParseTLSMode(value)
+		if !ok {
+			return nil, fmt.Errorf(
+				"invalid '%s' value (%s), expected 'enabled', 'disabled', 'insecure' or 'h2c'",
+				tlsKey,
+				value,
+			)
+		}
+
+		tlsMode = mode
+	} else if numeric, _ := net.LookupPort("tcp", port); numeric == 443 || numeric == 8443 {
+		tlsMode = backend.TLSEnabled
+	}
+
+	description := md[descriptionKey]
+	if description == "" {
+		description = entry.Service.Service
+	}
+
+	affinity, err := instanceAffinity(md)
+	if err != nil {
+		return nil, err
+	}
+
+	return &backend.Endpoint{
+		Description: description,
+		Address:     net.JoinHostPort(host, port),
+		TLSMode:     tlsMode,
+		Affinity:    affinity,
+	}, nil
+}
+
+// instanceAffinity returns the session affinity settings for a single service
+// instance, or nil if requests are routed independently.
+func instanceAffinity(md map[string]string) (*backend.Affinity, error) {
+	value, ok := md[affinityKey]
+	if !ok {
+		return nil, nil
+	}
+
+	mode, ok := backend.ParseAffinityMode(value)
+	if !ok {
+		return nil, fmt.Errorf(
+			"invalid '%s' value (%s), expected 'cookie', 'client-ip' or 'none'",
+			affinityKey,
+			value,
+		)
+	} else if mode == backend.AffinityNone {
+		return nil, nil
+	}
+
+	a := &backend.Affinity{
+		Mode:       mode,
+		CookieName: md[affinityCookieKey],
+	}
+
+	if value, ok := md[affinityTTLKey]; ok {
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl < 0 {
+			return nil, fmt.Errorf(
+				"invalid '%s' value (%s), expected a duration, such as '1h'",
+				affinityTTLKey,
+				value,
+			)
+		}
+
+		a.CookieTTL = ttl
+	}
+
+	return a, nil
+}
@@ -0,0 +1,183 @@
+package docker_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+)
+
+// fakeClient is a Docker API client that serves a fixed set of services and
+// images. Calling any method that is not overridden panics.
+type fakeClient struct {
+	client.APIClient
+
+	Services []swarm.Service
+	Images   map[string]types.ImageInspect
+
+	// ImageInspections is the number of calls to ImageInspectWithRaw().
+	ImageInspections int
+
+	// StartTasks, if true, causes services that are scaled up to report a
+	// running task.
+	StartTasks bool
+
+	// UpdateDelay is the amount of time that ServiceUpdate() waits before
+	// updating a service.
+	UpdateDelay time.Duration
+
+	m sync.Mutex
+}
+
+func (c *fakeClient) ServiceList(
+	context.Context,
+	types.ServiceListOptions,
+) ([]swarm.Service, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	var services []swarm.Service
+	for _, s := range c.Services {
+		services = append(services, copyService(s))
+	}
+
+	return services, nil
+}
+
+func (c *fakeClient) ServiceInspectWithRaw(
+	_ context.Context,
+	id string,
+	_ types.ServiceInspectOptions,
+) (swarm.Service, []byte, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	for _, s := range c.Services {
+		if s.ID == id {
+			return copyService(s), nil, nil
+		}
+	}
+
+	return swarm.Service{}, nil, errdefs.NotFound(
+		fmt.Errorf("no such service: %s", id),
+	)
+}
+
+func (c *fakeClient) ServiceUpdate(
+	_ context.Context,
+	id string,
+	_ swarm.Version,
+	spec swarm.ServiceSpec,
+	_ types.ServiceUpdateOptions,
+) (types.ServiceUpdateResponse, error) {
+	time.Sleep(c.UpdateDelay)
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	for i, s := range c.Services {
+		if s.ID == id {
+			c.Services[i].Spec = spec
+			return types.ServiceUpdateResponse{}, nil
+		}
+	}
+
+	return types.ServiceUpdateResponse{}, errdefs.NotFound(
+		fmt.Errorf("no such service: %s", id),
+	)
+}
+
+func (c *fakeClient) TaskList(
+	_ context.Context,
+	options types.TaskListOptions,
+) ([]swarm.Task, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	var tasks []swarm.Task
+
+	for _, s := range c.Services {
+		if !options.Filters.ExactMatch("service", s.ID) {
+			continue
+		}
+
+		if replicas := c.replicas(s); c.StartTasks && replicas > 0 {
+			var t swarm.Task
+			t.ServiceID = s.ID
+			t.Status.State = swarm.TaskStateRunning
+			tasks = append(tasks, t)
+		}
+	}
+
+	return tasks, nil
+}
+
+// Replicas returns the number of replicas of the service with the given ID.
+func (c *fakeClient) Replicas(id string) uint64 {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	for _, s := range c.Services {
+		if s.ID == id {
+			return c.replicas(s)
+		}
+	}
+
+	return 0
+}
+
+func (c *fakeClient) replicas(s swarm.Service) uint64 {
+	if s.Spec.Mode.Replicated == nil || s.Spec.Mode.Replicated.Replicas == nil {
+		return 0
+	}
+
+	return *s.Spec.Mode.Replicated.Replicas
+}
+
+// copyService returns a copy of s that does not share its replicated mode
+// settings with the original.
+func copyService(s swarm.Service) swarm.Service {
+	if r := s.Spec.Mode.Replicated; r != nil {
+		r := *r
+		if r.Replicas != nil {
+			n := *r.Replicas
+			r.Replicas = &n
+		}
+		s.Spec.Mode.Replicated = &r
+	}
+
+	return s
+}
+
+func (c *fakeClient) ImageInspectWithRaw(
+	_ context.Context,
+	image string,
+) (types.ImageInspect, []byte, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.ImageInspections++
+
+	if i, ok := c.Images[image]; ok {
+		return i, nil, nil
+	}
+
+	return types.ImageInspect{}, nil, errdefs.NotFound(
+		fmt.Errorf("no such image: %s", image),
+	)
+}
+
+// newService returns a swarm service with the given name, image and labels.
+func newService(name, image string, labels map[string]string) swarm.Service {
+	var s swarm.Service
+	s.ID = name
+	s.Spec.Name = name
+	s.Spec.Labels = labels
+	s.Spec.TaskTemplate.ContainerSpec = &swarm.ContainerSpec{Image: image}
+	return s
+}
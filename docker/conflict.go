@@ -0,0 +1,80 @@
+package docker
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Conflict describes multiple services that have the same match pattern.
+type Conflict struct {
+	// Pattern is the match pattern shared by the services.
+	Pattern string
+
+	// Services is the names of the conflicting services, in order of
+	// precedence. Requests are routed to the first service.
+	Services []string
+}
+
+// String returns a human-readable description of the conflict.
+func (c Conflict) String() string {
+	return fmt.Sprintf(
+		"'%s' is matched by multiple services ('%s'), routing to '%s'",
+		c.Pattern,
+		strings.Join(c.Services, "', '"),
+		c.Services[0],
+	)
+}
+
+// equal checks if two conflicts are identical.
+func (c Conflict) equal(other Conflict) bool {
+	return c.Pattern == other.Pattern &&
+		strings.Join(c.Services, ",") == strings.Join(other.Services, ",")
+}
+
+// findConflicts returns the conflicts between services, which must already be
+// sorted in order of precedence. Weighted services that share requests for a
+// match pattern do not conflict.
+func findConflicts(services []ServiceInfo) []Conflict {
+	var conflicts []Conflict
+
+	for _, group := range groupServices(services) {
+		if weightedMembers(group) != nil {
+			continue
+		}
+
+		c := Conflict{Pattern: group[0].Matcher.Pattern}
+		for _, info := range group {
+			if !containsString(c.Services, info.Name) {
+				c.Services = append(c.Services, info.Name)
+			}
+		}
+
+		if len(c.Services) > 1 {
+			conflicts = append(conflicts, c)
+		}
+	}
+
+	return conflicts
+}
+
+// containsConflict returns true if conflicts contains a conflict equal to c.
+func containsConflict(conflicts []Conflict, c Conflict) bool {
+	for _, other := range conflicts {
+		if c.equal(other) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// containsString returns true if values contains v.
+func containsString(values []string, v string) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+
+	return false
+}
@@ -18,7 +18,7 @@ type HTTPChecker struct {
 func (checker *HTTPChecker) Check() Status {
 	host, port, err := net.SplitHostPort(checker.Address)
 	if err != nil {
-		return Status{false, err.Error()}
+		return Status{IsHealthy: false, Message: err.Error()}
 	} else if host == "" {
 		host = requestHost
 	}
@@ -41,18 +41,18 @@ func (checker *HTTPChecker) Check() Status {
 
 	response, err := client.Get(url.String())
 	if err != nil {
-		return Status{false, err.Error()}
+		return Status{IsHealthy: false, Message: err.Error()}
 	}
 
 	defer response.Body.Close()
 
 	content, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		return Status{false, err.Error()}
+		return Status{IsHealthy: false, Message: err.Error()}
 	}
 
 	return Status{
-		200 <= response.StatusCode && response.StatusCode <= 299,
-		string(content),
+		IsHealthy: 200 <= response.StatusCode && response.StatusCode <= 299,
+		Message:   string(content),
 	}
 }
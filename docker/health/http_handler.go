@@ -28,8 +28,8 @@ func (handler *HTTPHandler) ServeHTTP(writer http.ResponseWriter, request *http.
 	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
 
 	status := Status{
-		true,
-		"The server is accepting requests, but no health-checker is configured.",
+		IsHealthy: true,
+		Message:   "The server is accepting requests, but no health-checker is configured.",
 	}
 
 	if handler.Checker != nil {
@@ -47,4 +47,8 @@ func (handler *HTTPHandler) ServeHTTP(writer http.ResponseWriter, request *http.
 	}
 
 	io.WriteString(writer, status.Message)
+
+	for _, warning := range status.Warnings {
+		io.WriteString(writer, "\nWarning: "+warning)
+	}
 }
@@ -65,6 +65,22 @@ var _ = Describe("HTTPHandler", func() {
 			Entry("writes an unhealthy response", false, http.StatusServiceUnavailable),
 		)
 
+		It("includes any warnings in the response", func() {
+			subject.Checker = &fakeChecker{
+				health.Status{
+					IsHealthy: true,
+					Message:   "<message>",
+					Warnings:  []string{"<warning 1>", "<warning 2>"},
+				},
+			}
+			writer := &httptest.ResponseRecorder{Body: &bytes.Buffer{}}
+			request := httptest.NewRequest(http.MethodGet, "/anything", nil)
+			subject.ServeHTTP(writer, request)
+
+			Expect(writer.Code).To(Equal(http.StatusOK))
+			Expect(writer.Body.String()).To(Equal("<message>\nWarning: <warning 1>\nWarning: <warning 2>"))
+		})
+
 		DescribeTable(
 			"when there is a logger configured",
 			func(isHealthy bool, logOutput string) {
@@ -6,6 +6,10 @@ import "fmt"
 type Status struct {
 	IsHealthy bool
 	Message   string
+
+	// Warnings is a list of problems that do not affect the health of the
+	// server, but that should be brought to the attention of an operator.
+	Warnings []string
 }
 
 func (status Status) String() string {
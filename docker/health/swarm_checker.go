@@ -4,22 +4,35 @@ import (
 	"context"
 
 	"github.com/docker/docker/client"
+	"github.com/icecave/honeycomb/docker"
 )
 
 // SwarmChecker is a checker that checks if the Docker connection is handled by
 // a swarm manager.
 type SwarmChecker struct {
 	Client client.APIClient
+
+	// Locator, if non-nil, is checked for conflicting routes, which are
+	// reported as warnings.
+	Locator *docker.Locator
 }
 
 // Check returns information about the health of the HTTPS server.
 func (checker *SwarmChecker) Check() Status {
 	if _, err := checker.Client.SwarmInspect(context.Background()); err != nil {
-		return Status{false, err.Error()}
+		return Status{IsHealthy: false, Message: err.Error()}
+	}
+
+	status := Status{
+		IsHealthy: true,
+		Message:   "The server is connected to a Docker swarm manager.",
 	}
 
-	return Status{
-		true,
-		"The server is connected to a Docker swarm manager.",
+	if checker.Locator != nil {
+		for _, c := range checker.Locator.Conflicts() {
+			status.Warnings = append(status.Warnings, "conflicting routes, "+c.String())
+		}
 	}
+
+	return status
 }
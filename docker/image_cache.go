@@ -0,0 +1,106 @@
+package docker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/reference"
+)
+
+// imageCacheTTL is the amount of time that an image digest can go unused
+// before it is removed from the cache.
+const imageCacheTTL = time.Hour
+
+// unpinnedImageTTL is the amount of time that the inspection results of an
+// image that is not pinned to a digest are cached, as its tag may be moved to
+// a different image.
+const unpinnedImageTTL = 5 * time.Minute
+
+// imageCache is a cache of image inspection results, keyed by image digest or,
+// for images that are not pinned to a digest, by image reference.
+type imageCache struct {
+	m       sync.Mutex
+	entries map[string]imageCacheEntry
+}
+
+// imageCacheEntry is the result of inspecting an image.
+type imageCacheEntry struct {
+	// Present is true if the image was present on the local node.
+	Present bool
+
+	// Ports is the list of TCP ports exposed by the image.
+	Ports []string
+
+	expiresAt time.Time
+	usedAt    time.Time
+}
+
+// Get returns the cached entry for an image. It returns false if key is
+// empty, or there is no unexpired entry.
+func (c *imageCache) Get(key string) (imageCacheEntry, bool) {
+	if key == "" {
+		return imageCacheEntry{}, false
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	now := time.Now()
+	entry, ok := c.entries[key]
+
+	if !ok || (!entry.expiresAt.IsZero() && now.After(entry.expiresAt)) {
+		return imageCacheEntry{}, false
+	}
+
+	entry.usedAt = now
+	c.entries[key] = entry
+
+	return entry, true
+}
+
+// Put adds an entry to the cache. If ttl is non-zero, the entry expires after
+// that amount of time, otherwise it is kept for as long as it is used. Entries
+// that have not been used recently are removed.
+func (c *imageCache) Put(key string, entry imageCacheEntry, ttl time.Duration) {
+	if key == "" {
+		return
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	now := time.Now()
+
+	for k, e := range c.entries {
+		if now.Sub(e.usedAt) > imageCacheTTL {
+			delete(c.entries, k)
+		}
+	}
+
+	if c.entries == nil {
+		c.entries = map[string]imageCacheEntry{}
+	}
+
+	entry.usedAt = now
+	if ttl != 0 {
+		entry.expiresAt = now.Add(ttl)
+	}
+
+	c.entries[key] = entry
+}
+
+// imageCacheKey returns the key used to cache the inspection results of an
+// image, and true if the image is pinned to a digest.
+//
+// Swarm resolves the image of a service to a digest when the service is
+// created or updated, such as "app:latest@sha256:...", in which case the
+// digest is used as the key. Otherwise, the full reference is used.
+func imageCacheKey(image string) (string, bool) {
+	if ref, err := reference.Parse(image); err == nil {
+		if r, ok := ref.(reference.Digested); ok {
+			return r.Digest().String(), true
+		}
+	}
+
+	return image, false
+}
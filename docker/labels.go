@@ -1,8 +1,77 @@
 package docker
 
+import "github.com/docker/docker/api/types/swarm"
+
 const (
 	matchLabel       = "honeycomb.match"
 	portLabel        = "honeycomb.port"
 	tlsLabel         = "honeycomb.tls"
+	protocolLabel    = "honeycomb.protocol"
 	descriptionLabel = "honeycomb.description"
+	priorityLabel    = "honeycomb.priority"
+	idleTimeoutLabel = "honeycomb.idle-timeout"
+	weightLabel      = "honeycomb.weight"
+	ruleLabel        = "honeycomb.rule"
+	flushLabel       = "honeycomb.flush-interval"
+	grpcWebLabel     = "honeycomb.grpc-web"
+	upgradeLabel     = "honeycomb.upgrade"
+	httpLabel        = "honeycomb.http"
+
+	affinityLabel       = "honeycomb.affinity"
+	affinityCookieLabel = "honeycomb.affinity-cookie"
+	affinityTTLLabel    = "honeycomb.affinity-ttl"
+
+	timeoutDialLabel           = "honeycomb.timeout-dial"
+	timeoutTLSHandshakeLabel   = "honeycomb.timeout-tls-handshake"
+	timeoutResponseHeaderLabel = "honeycomb.timeout-response-header"
+	timeoutIdleLabel           = "honeycomb.timeout-idle"
+	timeoutTotalLabel          = "honeycomb.timeout-total"
+
+	webSocketIdleTimeoutLabel             = "honeycomb.websocket-idle-timeout"
+	webSocketPingIntervalLabel            = "honeycomb.websocket-ping-interval"
+	webSocketMaxLifetimeLabel             = "honeycomb.websocket-max-lifetime"
+	webSocketMaxMessageSizeLabel          = "honeycomb.websocket-max-message-size"
+	webSocketInspectLabel                 = "honeycomb.websocket-inspect"
+	webSocketMaxConnectionsLabel          = "honeycomb.websocket-max-connections"
+	webSocketMaxConnectionsPerClientLabel = "honeycomb.websocket-max-connections-per-client"
+	webSocketOriginsLabel                 = "honeycomb.websocket-origins"
+	webSocketSameHostLabel                = "honeycomb.websocket-same-host"
+
+	maintenanceLabel           = "honeycomb.maintenance"
+	maintenanceMessageLabel    = "honeycomb.maintenance-message"
+	maintenanceRetryAfterLabel = "honeycomb.maintenance-retry-after"
+	maintenanceAllowLabel      = "honeycomb.maintenance-allow"
+
+	hstsLabel                    = "honeycomb.hsts"
+	contentSecurityPolicyLabel   = "honeycomb.content-security-policy"
+	frameOptionsLabel            = "honeycomb.frame-options"
+	contentTypeOptionsLabel      = "honeycomb.content-type-options"
+	referrerPolicyLabel          = "honeycomb.referrer-policy"
+	permissionsPolicyLabel       = "honeycomb.permissions-policy"
+	preserveSecurityHeadersLabel = "honeycomb.preserve-security-headers"
 )
+
+// label returns the value of the label with the given base name and key
+// suffix, falling back to the label without a suffix. It returns the name of
+// the label that was found.
+func label(service *swarm.Service, base, key string) (string, string, bool) {
+	if key != "" {
+		name := labelName(base, key)
+		if value, ok := service.Spec.Labels[name]; ok {
+			return name, value, true
+		}
+	}
+
+	value, ok := service.Spec.Labels[base]
+	return base, value, ok
+}
+
+// labelName returns the name of the label with the given base name and key
+// suffix.
+func labelName(base, key string) string {
+	if key == "" {
+		return base
+	}
+
+	return base + "." + key
+}
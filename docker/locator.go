@@ -3,11 +3,15 @@ package docker
 import (
 	"context"
 	"log"
+	"net/http"
+	"sort"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/icecave/honeycomb/backend"
 	"github.com/icecave/honeycomb/name"
+	"github.com/icecave/honeycomb/rule"
 )
 
 // DefaultPollInterval is the default interval between rebuilds of the service
@@ -22,8 +26,13 @@ type Locator struct {
 	Cache        *backend.Cache
 	Logger       *log.Logger
 
-	done     chan struct{}
-	services atomic.Value // []ServiceInfo
+	once      sync.Once
+	done      chan struct{}
+	services  atomic.Value // []ServiceInfo
+	idle      atomic.Value // map[string]ServiceInfo
+	routes    atomic.Value // []route
+	rules     atomic.Value // rule.Set
+	conflicts atomic.Value // []Conflict
 }
 
 // Locate finds the back-end HTTP server for the given server name.
@@ -37,10 +46,10 @@ func (locator *Locator) Locate(
 	ctx context.Context,
 	serverName name.ServerName,
 ) (ep *backend.Endpoint, score int) {
-	if services, ok := locator.services.Load().([]ServiceInfo); ok {
-		for _, info := range services {
-			if s := info.Matcher.Match(serverName); s > score {
-				ep = info.Endpoint
+	if routes, ok := locator.routes.Load().([]route); ok {
+		for _, r := range routes {
+			if s := r.Matcher.Match(serverName); s > score {
+				ep = r.Endpoint
 				score = s
 			}
 		}
@@ -49,11 +58,45 @@ func (locator *Locator) Locate(
 	return ep, score
 }
 
+// LocateRequest finds the back-end HTTP server for request using the routing
+// rules defined by service labels.
+//
+// It returns a score indicating the strength of the match. A value of 0 or less
+// indicates that no rule matched, in which case ep is nil.
+func (locator *Locator) LocateRequest(
+	request *http.Request,
+	serverName name.ServerName,
+) (ep *backend.Endpoint, score int) {
+	if rules, ok := locator.rules.Load().(rule.Set); ok {
+		return rules.LocateRequest(request, serverName)
+	}
+
+	return nil, 0
+}
+
+// Services returns the services that are currently routed to.
+func (locator *Locator) Services() []ServiceInfo {
+	services, _ := locator.services.Load().([]ServiceInfo)
+	return services
+}
+
+// idleService returns the service with an idle timeout that provides the
+// endpoint at the given address.
+func (locator *Locator) idleService(address string) (ServiceInfo, bool) {
+	services, _ := locator.idle.Load().(map[string]ServiceInfo)
+	info, ok := services[address]
+	return info, ok
+}
+
+// Conflicts returns the services that currently have the same match pattern.
+func (locator *Locator) Conflicts() []Conflict {
+	conflicts, _ := locator.conflicts.Load().([]Conflict)
+	return conflicts
+}
+
 // Run polls Docker for service information until Stop() is called.
 func (locator *Locator) Run() {
-	if locator.done == nil {
-		locator.done = make(chan struct{})
-	}
+	locator.init()
 
 	services := locator.load()
 	if locator.diff(nil, services) {
@@ -81,14 +124,32 @@ func (locator *Locator) Run() {
 
 // Stop shuts down the locator and cleans up any resources used.
 func (locator *Locator) Stop() {
+	locator.init()
 	close(locator.done)
 }
 
+// init initializes the locator's internal state, it is safe to call
+// concurrently.
+func (locator *Locator) init() {
+	locator.once.Do(func() {
+		locator.done = make(chan struct{})
+	})
+}
+
 func (locator *Locator) load() []ServiceInfo {
 	new, err := locator.Loader.Load(context.Background())
 
 	if err == nil {
+		// Sort the services in order of precedence so that the first of any
+		// services with the same match pattern is used by Locate().
+		sort.SliceStable(new, func(i, j int) bool {
+			return new[i].precedes(new[j])
+		})
+
 		locator.services.Store(new)
+		locator.idle.Store(buildIdleIndex(new))
+		locator.routes.Store(buildRoutes(new))
+		locator.rules.Store(buildRules(new))
 	} else {
 		locator.Logger.Println(err)
 	}
@@ -96,6 +157,24 @@ func (locator *Locator) load() []ServiceInfo {
 	return new
 }
 
+// buildIdleIndex returns the services that have an idle timeout, keyed by
+// endpoint address. If several services share an address, the first is used.
+func buildIdleIndex(services []ServiceInfo) map[string]ServiceInfo {
+	index := map[string]ServiceInfo{}
+
+	for _, info := range services {
+		if info.IdleTimeout == 0 {
+			continue
+		}
+
+		if _, ok := index[info.Endpoint.Address]; !ok {
+			index[info.Endpoint.Address] = info
+		}
+	}
+
+	return index
+}
+
 func (locator *Locator) diff(old []ServiceInfo, new []ServiceInfo) bool {
 	diff := false
 
@@ -111,8 +190,8 @@ func (locator *Locator) diff(old []ServiceInfo, new []ServiceInfo) bool {
 		if log {
 			diff = true
 			locator.Logger.Printf(
-				"Removed route from '%s' to '%s' (%s)",
-				info.Matcher.Pattern,
+				"Removed route from %s to '%s' (%s)",
+				info.from(),
 				info.Name,
 				info.Endpoint.Description,
 			)
@@ -131,13 +210,24 @@ func (locator *Locator) diff(old []ServiceInfo, new []ServiceInfo) bool {
 		if log {
 			diff = true
 			locator.Logger.Printf(
-				"Added route from '%s' to '%s' (%s)",
-				info.Matcher.Pattern,
+				"Added route from %s to '%s' (%s)",
+				info.from(),
 				info.Name,
 				info.Endpoint.Description,
 			)
 		}
 	}
 
+	oldConflicts := findConflicts(old)
+	newConflicts := findConflicts(new)
+
+	for _, c := range newConflicts {
+		if !containsConflict(oldConflicts, c) {
+			locator.Logger.Printf("Detected conflicting routes, %s", c)
+		}
+	}
+
+	locator.conflicts.Store(newConflicts)
+
 	return diff
 }
@@ -0,0 +1,200 @@
+package docker_test
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/icecave/honeycomb/backend"
+	"github.com/icecave/honeycomb/docker"
+	"github.com/icecave/honeycomb/name"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Locator", func() {
+	var (
+		fake    *fakeClient
+		subject *docker.Locator
+	)
+
+	addService := func(serviceName string, createdAt time.Time, labels map[string]string) {
+		labels["honeycomb.port"] = "80"
+		s := newService(serviceName, "app:latest", labels)
+		s.CreatedAt = createdAt
+		fake.Services = append(fake.Services, s)
+	}
+
+	locate := func(serverName string) *backend.Endpoint {
+		var ep *backend.Endpoint
+		Eventually(func() *backend.Endpoint {
+			ep, _ = subject.Locate(context.Background(), name.Parse(serverName))
+			return ep
+		}).ShouldNot(BeNil())
+		return ep
+	}
+
+	BeforeEach(func() {
+		fake = &fakeClient{Images: map[string]types.ImageInspect{}}
+		logger := log.New(GinkgoWriter, "", 0)
+
+		subject = &docker.Locator{
+			Loader: &docker.ServiceLoader{
+				Client:    fake,
+				Inspector: &docker.ServiceInspector{Client: fake},
+				Logger:    logger,
+			},
+			Cache:  &backend.Cache{},
+			Logger: logger,
+		}
+	})
+
+	AfterEach(func() {
+		subject.Stop()
+	})
+
+	Context("when multiple services have the same match pattern", func() {
+		now := time.Now()
+
+		It("prefers the service with the highest priority", func() {
+			addService("old", now.Add(-time.Hour), map[string]string{
+				"honeycomb.match": "app.*",
+			})
+			addService("new", now, map[string]string{
+				"honeycomb.match":    "app.*",
+				"honeycomb.priority": "10",
+			})
+
+			go subject.Run()
+
+			Expect(locate("app.example.com").Address).To(Equal("new:80"))
+		})
+
+		It("prefers the oldest service when the priorities are equal", func() {
+			addService("new", now, map[string]string{
+				"honeycomb.match": "app.*",
+			})
+			addService("old", now.Add(-time.Hour), map[string]string{
+				"honeycomb.match": "app.*",
+			})
+
+			go subject.Run()
+
+			Expect(locate("app.example.com").Address).To(Equal("old:80"))
+		})
+
+		It("reports the conflict", func() {
+			addService("new", now, map[string]string{
+				"honeycomb.match": "app.*",
+			})
+			addService("old", now.Add(-time.Hour), map[string]string{
+				"honeycomb.match": "app.*",
+			})
+			addService("other", now, map[string]string{
+				"honeycomb.match": "other.*",
+			})
+
+			go subject.Run()
+
+			Eventually(subject.Conflicts).Should(Equal([]docker.Conflict{
+				{
+					Pattern:  "app.*",
+					Services: []string{"old", "new"},
+				},
+			}))
+		})
+	})
+
+	Context("when services with the same match pattern are weighted", func() {
+		now := time.Now()
+
+		BeforeEach(func() {
+			addService("app-v1", now.Add(-time.Hour), map[string]string{
+				"honeycomb.match": "app.*",
+			})
+			addService("app-v2", now, map[string]string{
+				"honeycomb.match":    "app.*",
+				"honeycomb.weight":   "10",
+				"honeycomb.affinity": "cookie",
+			})
+		})
+
+		It("splits requests between the services", func() {
+			go subject.Run()
+
+			ep := locate("app.example.com")
+			Expect(ep.Affinity).To(Equal(&backend.Affinity{Mode: backend.AffinityCookie}))
+			Expect(ep.Alternatives).To(HaveLen(2))
+			Expect(ep.Alternatives[0].Address).To(Equal("app-v1:80"))
+			Expect(ep.Alternatives[0].Weight).To(Equal(1))
+			Expect(ep.Alternatives[1].Address).To(Equal("app-v2:80"))
+			Expect(ep.Alternatives[1].Weight).To(Equal(10))
+		})
+
+		It("does not report a conflict", func() {
+			go subject.Run()
+
+			locate("app.example.com")
+			Expect(subject.Conflicts()).To(BeEmpty())
+		})
+
+		It("excludes services with a lower priority", func() {
+			addService("app-v0", now, map[string]string{
+				"honeycomb.match":    "app.*",
+				"honeycomb.priority": "-1",
+				"honeycomb.weight":   "100",
+			})
+
+			go subject.Run()
+
+			ep := locate("app.example.com")
+			Expect(ep.Alternatives).To(HaveLen(2))
+		})
+	})
+
+	Context("when a service has a rule", func() {
+		now := time.Now()
+
+		BeforeEach(func() {
+			addService("app", now, map[string]string{
+				"honeycomb.match": "app.*",
+			})
+			addService("app-canary", now, map[string]string{
+				"honeycomb.match": "app.*",
+				"honeycomb.rule":  "header:X-Canary=1",
+			})
+		})
+
+		It("routes requests that match the rule to the service", func() {
+			go subject.Run()
+			locate("app.example.com")
+
+			request := httptest.NewRequest(http.MethodGet, "https://app.example.com/", nil)
+			request.Header.Set("X-Canary", "1")
+
+			ep, score := subject.LocateRequest(request, name.Parse("app.example.com"))
+			Expect(score).To(BeNumerically(">", 0))
+			Expect(ep.Address).To(Equal("app-canary:80"))
+		})
+
+		It("does not route other requests to the service", func() {
+			go subject.Run()
+
+			Expect(locate("app.example.com").Address).To(Equal("app:80"))
+
+			request := httptest.NewRequest(http.MethodGet, "https://app.example.com/", nil)
+			ep, _ := subject.LocateRequest(request, name.Parse("app.example.com"))
+			Expect(ep).To(BeNil())
+		})
+
+		It("does not report a conflict", func() {
+			go subject.Run()
+
+			locate("app.example.com")
+			Expect(subject.Conflicts()).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,130 @@
+package docker
+
+import (
+	"strings"
+
+	"github.com/icecave/honeycomb/backend"
+	"github.com/icecave/honeycomb/name"
+	"github.com/icecave/honeycomb/rule"
+)
+
+// defaultWeight is the weight of a service without a weight label that shares
+// its match pattern with weighted services.
+const defaultWeight = 1
+
+// route is a match pattern and the endpoint that requests are routed to.
+type route struct {
+	Matcher  *name.Matcher
+	Endpoint *backend.Endpoint
+}
+
+// buildRoutes returns the routes for the given services, which must already be
+// sorted in order of precedence.
+//
+// Requests are routed to the first service with each match pattern, unless
+// several services with the highest priority for that pattern are weighted, in
+// which case requests are split between them in proportion to their weights.
+func buildRoutes(services []ServiceInfo) []route {
+	var routes []route
+
+	for _, group := range groupServices(services) {
+		top := group[0]
+		members := weightedMembers(group)
+
+		if members == nil {
+			routes = append(routes, route{top.Matcher, top.Endpoint})
+			continue
+		}
+
+		endpoint := &backend.Endpoint{
+			Description: top.Endpoint.Description,
+		}
+
+		for _, info := range members {
+			alt := *info.Endpoint
+			alt.Weight = defaultWeight
+			if info.Weight != nil {
+				alt.Weight = *info.Weight
+			}
+
+			endpoint.Alternatives = append(endpoint.Alternatives, &alt)
+
+			if endpoint.Affinity == nil {
+				endpoint.Affinity = info.Endpoint.Affinity
+			}
+		}
+
+		routes = append(routes, route{top.Matcher, endpoint})
+	}
+
+	return routes
+}
+
+// buildRules returns the routing rules for the given services.
+func buildRules(services []ServiceInfo) rule.Set {
+	var rules rule.Set
+
+	for _, info := range services {
+		if info.Rule != nil {
+			rules = append(rules, rule.Rule{
+				Matcher:    info.Matcher,
+				Expression: *info.Rule,
+				Endpoint:   info.Endpoint,
+			})
+		}
+	}
+
+	return rules
+}
+
+// groupServices groups services without a rule by their match pattern,
+// preserving their order.
+func groupServices(services []ServiceInfo) [][]ServiceInfo {
+	var groups [][]ServiceInfo
+	index := map[string]int{}
+
+	for _, info := range services {
+		if info.Rule != nil {
+			continue
+		}
+
+		pattern := strings.ToLower(info.Matcher.Pattern)
+
+		if i, ok := index[pattern]; ok {
+			groups[i] = append(groups[i], info)
+		} else {
+			index[pattern] = len(groups)
+			groups = append(groups, []ServiceInfo{info})
+		}
+	}
+
+	return groups
+}
+
+// weightedMembers returns the services in a group that share requests in
+// proportion to their weights, or nil if requests are routed to a single
+// service.
+func weightedMembers(group []ServiceInfo) []ServiceInfo {
+	var (
+		members  []ServiceInfo
+		weighted bool
+	)
+
+	for _, info := range group {
+		if info.Priority != group[0].Priority {
+			break
+		}
+
+		members = append(members, info)
+
+		if info.Weight != nil {
+			weighted = true
+		}
+	}
+
+	if !weighted || len(members) < 2 {
+		return nil
+	}
+
+	return members
+}
@@ -0,0 +1,426 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+	"github.com/icecave/honeycomb/backend"
+)
+
+// DefaultScaleCheckInterval is the default interval between checks for idle
+// services.
+const DefaultScaleCheckInterval = 10 * time.Second
+
+// DefaultStartTimeout is the default maximum amount of time to wait for an idle
+// service to start.
+const DefaultStartTimeout = 2 * time.Minute
+
+// taskPollInterval is the interval between checks for running tasks while a
+// service is starting.
+const taskPollInterval = 500 * time.Millisecond
+
+// Scaler scales services with a "honeycomb.idle-timeout" label to zero replicas
+// when they have not received any requests for that amount of time, and scales
+// them back up when a request arrives.
+type Scaler struct {
+	Client  client.APIClient
+	Locator *Locator
+	Logger  *log.Logger
+
+	// CheckInterval is the interval between checks for idle services. If it
+	// is zero, DefaultScaleCheckInterval is used.
+	CheckInterval time.Duration
+
+	// StartTimeout is the maximum amount of time to wait for a service to
+	// start. If it is zero, DefaultStartTimeout is used.
+	StartTimeout time.Duration
+
+	once  sync.Once
+	done  chan struct{}
+	m     sync.Mutex
+	state map[string]*scalerState
+}
+
+// scalerState holds the activity information for a single service.
+type scalerState struct {
+	running    bool
+	active     int
+	lastActive time.Time
+	replicas   uint64
+	start      *startAttempt
+
+	// stopping is non-nil while the service is being scaled to zero replicas,
+	// it is closed once the update is complete.
+	stopping chan struct{}
+}
+
+// startAttempt is an in-progress attempt to start a service.
+type startAttempt struct {
+	done chan struct{}
+	err  error
+}
+
+// Begin records the start of a request to endpoint. It returns false if the
+// endpoint's service has been, or is being, scaled to zero replicas.
+func (scaler *Scaler) Begin(endpoint *backend.Endpoint) bool {
+	info, ok := scaler.find(endpoint)
+	if !ok {
+		return true
+	}
+
+	scaler.m.Lock()
+	defer scaler.m.Unlock()
+
+	st := scaler.get(info)
+	st.active++
+	st.lastActive = time.Now()
+
+	return st.running && st.stopping == nil
+}
+
+// End records the end of a request to endpoint.
+func (scaler *Scaler) End(endpoint *backend.Endpoint) {
+	info, ok := scaler.find(endpoint)
+	if !ok {
+		return
+	}
+
+	scaler.m.Lock()
+	defer scaler.m.Unlock()
+
+	st := scaler.get(info)
+	if st.active > 0 {
+		st.active--
+	}
+	st.lastActive = time.Now()
+}
+
+// Start scales the endpoint's service up if it has zero replicas, then blocks
+// until one of its tasks is running, or ctx is canceled.
+func (scaler *Scaler) Start(ctx context.Context, endpoint *backend.Endpoint) error {
+	info, ok := scaler.find(endpoint)
+	if !ok {
+		return nil
+	}
+
+	scaler.m.Lock()
+	st := scaler.get(info)
+	attempt := st.start
+	if attempt == nil {
+		attempt = &startAttempt{done: make(chan struct{})}
+		st.start = attempt
+		go scaler.start(info, st, attempt)
+	}
+	scaler.m.Unlock()
+
+	select {
+	case <-attempt.done:
+		return attempt.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Run checks for idle services until Stop() is called.
+func (scaler *Scaler) Run() {
+	scaler.init()
+
+	checkInterval := scaler.CheckInterval
+	if checkInterval == 0 {
+		checkInterval = DefaultScaleCheckInterval
+	}
+
+	for {
+		select {
+		case <-time.After(checkInterval):
+			scaler.check()
+		case <-scaler.done:
+			return
+		}
+	}
+}
+
+// Stop shuts down the scaler.
+func (scaler *Scaler) Stop() {
+	scaler.init()
+	close(scaler.done)
+}
+
+// init initializes the scaler's internal state, it is safe to call
+// concurrently.
+func (scaler *Scaler) init() {
+	scaler.once.Do(func() {
+		scaler.done = make(chan struct{})
+	})
+}
+
+// find returns the service that provides endpoint, if it has an idle timeout.
+func (scaler *Scaler) find(endpoint *backend.Endpoint) (ServiceInfo, bool) {
+	return scaler.Locator.idleService(endpoint.Address)
+}
+
+// get returns the state of the given service. scaler.m must be locked.
+func (scaler *Scaler) get(info ServiceInfo) *scalerState {
+	if st, ok := scaler.state[info.Name]; ok {
+		return st
+	}
+
+	if scaler.state == nil {
+		scaler.state = map[string]*scalerState{}
+	}
+
+	st := &scalerState{
+		running:    info.Replicas == nil || *info.Replicas > 0,
+		lastActive: time.Now(),
+		replicas:   1,
+	}
+	scaler.state[info.Name] = st
+
+	return st
+}
+
+// check scales any services that have been idle for longer than their idle
+// timeout to zero replicas.
+func (scaler *Scaler) check() {
+	now := time.Now()
+	seen := map[string]bool{}
+
+	for _, info := range scaler.Locator.Services() {
+		if info.IdleTimeout == 0 || seen[info.Name] {
+			continue
+		}
+		seen[info.Name] = true
+
+		scaler.m.Lock()
+		st := scaler.get(info)
+		idle := st.running &&
+			st.active == 0 &&
+			st.start == nil &&
+			st.stopping == nil &&
+			now.Sub(st.lastActive) >= info.IdleTimeout
+
+		// Mark the service as stopping before it is scaled, so that requests
+		// that arrive in the meantime wait to start it again, rather than
+		// being sent to a service that is about to have no replicas.
+		var stopping chan struct{}
+		if idle {
+			stopping = make(chan struct{})
+			st.stopping = stopping
+		}
+		scaler.m.Unlock()
+
+		if !idle {
+			continue
+		}
+
+		previous, err := scaler.scale(info, func(uint64) uint64 { return 0 })
+
+		scaler.m.Lock()
+		st.stopping = nil
+		close(stopping)
+
+		if err == nil {
+			// The service has no replicas now, even if requests arrived while
+			// it was being scaled. Those requests are waiting for a start
+			// attempt, which restores the previous number of replicas.
+			st.running = false
+			if previous > 0 {
+				st.replicas = previous
+			}
+		}
+
+		waiting := st.start != nil
+		scaler.m.Unlock()
+
+		if err != nil {
+			scaler.Logger.Printf(
+				"Can not scale '%s' to zero replicas, %s",
+				info.Name,
+				err,
+			)
+			continue
+		}
+
+		if waiting {
+			scaler.Logger.Printf(
+				"Scaled '%s' to zero replicas, it is being started again to serve a request",
+				info.Name,
+			)
+			continue
+		}
+
+		scaler.Logger.Printf(
+			"Scaled '%s' to zero replicas after %s without requests",
+			info.Name,
+			info.IdleTimeout,
+		)
+	}
+
+	// Forget about services that are no longer routed to.
+	scaler.m.Lock()
+	for n := range scaler.state {
+		if !seen[n] {
+			delete(scaler.state, n)
+		}
+	}
+	scaler.m.Unlock()
+}
+
+// start scales a service up and waits for one of its tasks to run.
+func (scaler *Scaler) start(info ServiceInfo, st *scalerState, attempt *startAttempt) {
+	timeout := scaler.StartTimeout
+	if timeout == 0 {
+		timeout = DefaultStartTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	scaler.m.Lock()
+	stopping := st.stopping
+	scaler.m.Unlock()
+
+	// Wait for the service to finish scaling to zero replicas, otherwise it
+	// could be scaled down after it is started.
+	var err error
+	if stopping != nil {
+		select {
+		case <-stopping:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	scaler.m.Lock()
+	replicas := st.replicas
+	scaler.m.Unlock()
+
+	if err == nil {
+		err = scaler.startReplicas(ctx, info, replicas)
+	}
+
+	if err != nil {
+		err = fmt.Errorf("can not start '%s', %s", info.Name, err)
+		scaler.Logger.Println(err)
+	}
+
+	scaler.m.Lock()
+	defer scaler.m.Unlock()
+
+	if err == nil {
+		st.running = true
+		st.lastActive = time.Now()
+	}
+
+	st.start = nil
+	attempt.err = err
+	close(attempt.done)
+}
+
+// startReplicas scales a service with zero replicas up to the given number of
+// replicas, and waits for one of its tasks to run.
+func (scaler *Scaler) startReplicas(
+	ctx context.Context,
+	info ServiceInfo,
+	replicas uint64,
+) error {
+
+	previous, err := scaler.scale(info, func(current uint64) uint64 {
+		if current > 0 {
+			return current
+		}
+		return replicas
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if previous == 0 {
+		scaler.Logger.Printf(
+			"Scaled '%s' to %d replica(s) to serve a request",
+			info.Name,
+			replicas,
+		)
+	}
+
+	return scaler.waitForTask(ctx, info)
+}
+
+// scale updates the number of replicas of a service to the value returned by
+// fn, which is passed the current number of replicas. It returns the number of
+// replicas before the update.
+func (scaler *Scaler) scale(
+	info ServiceInfo,
+	fn func(uint64) uint64,
+) (uint64, error) {
+	ctx := context.Background()
+
+	service, _, err := scaler.Client.ServiceInspectWithRaw(
+		ctx,
+		info.ServiceID,
+		types.ServiceInspectOptions{},
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	mode := service.Spec.Mode.Replicated
+	if mode == nil || mode.Replicas == nil {
+		return 0, errors.New("the service is not in replicated mode")
+	}
+
+	current := *mode.Replicas
+	desired := fn(current)
+
+	if desired == current {
+		return current, nil
+	}
+
+	mode.Replicas = &desired
+
+	_, err = scaler.Client.ServiceUpdate(
+		ctx,
+		service.ID,
+		service.Version,
+		service.Spec,
+		types.ServiceUpdateOptions{},
+	)
+
+	return current, err
+}
+
+// waitForTask blocks until the service has at least one running task.
+func (scaler *Scaler) waitForTask(ctx context.Context, info ServiceInfo) error {
+	args := filters.NewArgs(
+		filters.Arg("service", info.ServiceID),
+		filters.Arg("desired-state", "running"),
+	)
+
+	for {
+		tasks, err := scaler.Client.TaskList(ctx, types.TaskListOptions{Filters: args})
+		if err != nil {
+			return err
+		}
+
+		for _, task := range tasks {
+			if task.Status.State == swarm.TaskStateRunning {
+				return nil
+			}
+		}
+
+		select {
+		case <-time.After(taskPollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
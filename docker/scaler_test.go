@@ -0,0 +1,153 @@
+package docker_test
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/icecave/honeycomb/backend"
+	"github.com/icecave/honeycomb/docker"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Scaler", func() {
+	var (
+		fake     *fakeClient
+		locator  *docker.Locator
+		subject  *docker.Scaler
+		endpoint *backend.Endpoint
+	)
+
+	addService := func(serviceName string, replicas uint64, labels map[string]string) {
+		labels["honeycomb.match"] = serviceName + ".*"
+		labels["honeycomb.port"] = "80"
+		s := newService(serviceName, "app:latest", labels)
+		s.Spec.Mode.Replicated = &swarm.ReplicatedService{Replicas: &replicas}
+		fake.Services = append(fake.Services, s)
+	}
+
+	run := func() {
+		go locator.Run()
+		Eventually(locator.Services).ShouldNot(BeEmpty())
+		go subject.Run()
+	}
+
+	BeforeEach(func() {
+		fake = &fakeClient{
+			Images:     map[string]types.ImageInspect{},
+			StartTasks: true,
+		}
+		logger := log.New(GinkgoWriter, "", 0)
+
+		locator = &docker.Locator{
+			Loader: &docker.ServiceLoader{
+				Client:    fake,
+				Inspector: &docker.ServiceInspector{Client: fake},
+				Logger:    logger,
+			},
+			Cache:  &backend.Cache{},
+			Logger: logger,
+		}
+
+		subject = &docker.Scaler{
+			Client:        fake,
+			Locator:       locator,
+			CheckInterval: 10 * time.Millisecond,
+			StartTimeout:  time.Second,
+			Logger:        logger,
+		}
+
+		endpoint = &backend.Endpoint{Address: "app:80"}
+	})
+
+	AfterEach(func() {
+		subject.Stop()
+		locator.Stop()
+	})
+
+	Context("when the service has an idle timeout", func() {
+		BeforeEach(func() {
+			addService("app", 2, map[string]string{
+				"honeycomb.idle-timeout": "50ms",
+			})
+		})
+
+		It("scales the service to zero replicas once it is idle", func() {
+			run()
+
+			Eventually(func() uint64 {
+				return fake.Replicas("app")
+			}).Should(BeZero())
+
+			Expect(subject.Begin(endpoint)).To(BeFalse())
+			subject.End(endpoint)
+		})
+
+		It("does not scale the service while requests are in progress", func() {
+			run()
+
+			Expect(subject.Begin(endpoint)).To(BeTrue())
+			Consistently(func() uint64 {
+				return fake.Replicas("app")
+			}, 200*time.Millisecond).Should(BeEquivalentTo(2))
+			subject.End(endpoint)
+		})
+
+		It("restores the previous number of replicas when started", func() {
+			run()
+
+			Eventually(func() uint64 {
+				return fake.Replicas("app")
+			}).Should(BeZero())
+
+			err := subject.Start(context.Background(), endpoint)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(fake.Replicas("app")).To(BeEquivalentTo(2))
+			Expect(subject.Begin(endpoint)).To(BeTrue())
+			subject.End(endpoint)
+		})
+
+		It("starts the service again if a request arrives while it is being scaled to zero", func() {
+			fake.UpdateDelay = 200 * time.Millisecond
+			run()
+
+			// Wait until the service is idle, but has not been updated yet.
+			time.Sleep(150 * time.Millisecond)
+			Expect(fake.Replicas("app")).To(BeEquivalentTo(2))
+			Expect(subject.Begin(endpoint)).To(BeFalse())
+			subject.End(endpoint)
+
+			err := subject.Start(context.Background(), endpoint)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(fake.Replicas("app")).To(BeEquivalentTo(2))
+		})
+
+		It("returns an error if the service does not start in time", func() {
+			fake.StartTasks = false
+			run()
+
+			Eventually(func() uint64 {
+				return fake.Replicas("app")
+			}).Should(BeZero())
+
+			err := subject.Start(context.Background(), endpoint)
+			Expect(err).To(MatchError(ContainSubstring("can not start 'app'")))
+		})
+	})
+
+	Context("when the service does not have an idle timeout", func() {
+		It("never scales the service", func() {
+			addService("app", 1, map[string]string{})
+			run()
+
+			Consistently(func() uint64 {
+				return fake.Replicas("app")
+			}, 100*time.Millisecond).Should(BeEquivalentTo(1))
+			Expect(subject.Begin(endpoint)).To(BeTrue())
+			subject.End(endpoint)
+		})
+	})
+})
@@ -1,8 +1,13 @@
 package docker
 
 import (
+	"fmt"
+	"reflect"
+	"time"
+
 	"github.com/icecave/honeycomb/backend"
 	"github.com/icecave/honeycomb/name"
+	"github.com/icecave/honeycomb/rule"
 )
 
 // ServiceInfo meta-data and a reference to the docker service used as a back-end.
@@ -10,11 +15,79 @@ type ServiceInfo struct {
 	Name     string
 	Matcher  *name.Matcher
 	Endpoint *backend.Endpoint
+
+	// Priority is used to choose between services with the same match
+	// pattern. Services with a higher priority are preferred.
+	Priority int
+
+	// CreatedAt is the time at which the service was created. It is used to
+	// choose between services with the same match pattern and priority, in
+	// which case the oldest service is preferred.
+	CreatedAt time.Time
+
+	// Weight is the relative proportion of requests routed to this service
+	// when it shares its match pattern with other services of the same
+	// priority. It is nil if the service does not have a weight label.
+	Weight *int
+
+	// Rule, if non-nil, restricts the route to requests that match the rule
+	// expression. Rules take precedence over routes without a rule.
+	Rule *rule.Expression
+
+	// ServiceID is the ID of the Docker service.
+	ServiceID string
+
+	// IdleTimeout is the amount of time without any requests after which the
+	// service is scaled to zero replicas. If it is zero, the service is never
+	// scaled.
+	IdleTimeout time.Duration
+
+	// Replicas is the number of replicas of the service at the time it was
+	// loaded. It is nil if the service is not in replicated mode.
+	Replicas *uint64
 }
 
 // Equal checks if two ServiceInfo structs represent the same service.
 func (info ServiceInfo) Equal(other ServiceInfo) bool {
 	return info.Name == other.Name &&
 		*info.Matcher == *other.Matcher &&
-		*info.Endpoint == *other.Endpoint
+		info.Endpoint.Equal(other.Endpoint) &&
+		info.Priority == other.Priority &&
+		reflect.DeepEqual(info.Weight, other.Weight) &&
+		info.ruleSource() == other.ruleSource() &&
+		info.IdleTimeout == other.IdleTimeout
+}
+
+// from returns a human-readable description of the requests that are routed
+// to the service, for use in log messages.
+func (info ServiceInfo) from() string {
+	if info.Rule == nil {
+		return fmt.Sprintf("'%s'", info.Matcher.Pattern)
+	}
+
+	return fmt.Sprintf("'%s' when '%s'", info.Matcher.Pattern, info.Rule.Source)
+}
+
+// ruleSource returns the source of the service's rule expression, or an empty
+// string if it does not have a rule.
+func (info ServiceInfo) ruleSource() string {
+	if info.Rule == nil {
+		return ""
+	}
+
+	return info.Rule.Source
+}
+
+// precedes returns true if info should be preferred over other when both have
+// the same match pattern.
+func (info ServiceInfo) precedes(other ServiceInfo) bool {
+	if info.Priority != other.Priority {
+		return info.Priority > other.Priority
+	}
+
+	if !info.CreatedAt.Equal(other.CreatedAt) {
+		return info.CreatedAt.Before(other.CreatedAt)
+	}
+
+	return info.Name < other.Name
 }
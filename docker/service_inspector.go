@@ -4,7 +4,10 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/docker/distribution/reference"
 	"github.com/docker/docker/api/types/swarm"
@@ -12,36 +15,481 @@ import (
 	"github.com/icecave/honeycomb/backend"
 )
 
+// DefaultMissingImageTTL is the default amount of time to remember that an
+// image is not present on the local node before inspecting it again.
+const DefaultMissingImageTTL = 5 * time.Minute
+
 // ServiceInspector inspects a Docker swarm service to produce information about
 // an endpoint.
 type ServiceInspector struct {
 	Client client.APIClient
+
+	// MissingImageTTL is the amount of time to remember that an image is not
+	// present on the local node before inspecting it again. If it is zero,
+	// DefaultMissingImageTTL is used.
+	MissingImageTTL time.Duration
+
+	cache imageCache
 }
 
 // Inspect attempts to produce an endpoint from the given Docker service.
+//
+// key is the suffix of the match label that the endpoint is for, such as
+// "admin" for the "honeycomb.match.admin" label, or an empty string for the
+// "honeycomb.match" label. Labels with the same suffix, such as
+// "honeycomb.port.admin", take precedence over the equivalent label without a
+// suffix.
 func (inspector *ServiceInspector) Inspect(
 	ctx context.Context,
 	service *swarm.Service,
+	key string,
 ) (*backend.Endpoint, error) {
-	port, err := inspector.port(ctx, service)
+	port, err := inspector.port(ctx, service, key)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsMode, err := inspector.tlsMode(service, key, port)
+	if err != nil {
+		return nil, err
+	}
+
+	protocol, err := inspector.protocol(service, key)
+	if err != nil {
+		return nil, err
+	}
+
+	httpMode, err := inspector.httpMode(service, key)
+	if err != nil {
+		return nil, err
+	}
+
+	maintenance, err := inspector.maintenance(service, key)
+	if err != nil {
+		return nil, err
+	}
+
+	affinity, err := inspector.affinity(service, key)
+	if err != nil {
+		return nil, err
+	}
+
+	timeouts, err := inspector.timeouts(service, key)
+	if err != nil {
+		return nil, err
+	}
+
+	webSocket, err := inspector.webSocketLimits(service, key)
+	if err != nil {
+		return nil, err
+	}
+
+	origins, err := inspector.origins(service, key)
 	if err != nil {
 		return nil, err
 	}
 
-	tlsMode, err := inspector.tlsMode(service, port)
+	securityHeaders, err := inspector.securityHeaders(service, key)
+	if err != nil {
+		return nil, err
+	}
+
+	flushInterval, err := inspector.flushInterval(service, key)
+	if err != nil {
+		return nil, err
+	}
+
+	grpcWeb, err := inspector.grpcWeb(service, key)
 	if err != nil {
 		return nil, err
 	}
 
 	return &backend.Endpoint{
-		Description: inspector.description(service),
-		Address:     net.JoinHostPort(service.Spec.Name, port),
-		TLSMode:     tlsMode,
+		Description:      inspector.description(service, key),
+		Address:          net.JoinHostPort(service.Spec.Name, port),
+		TLSMode:          tlsMode,
+		Protocol:         protocol,
+		HTTPMode:         httpMode,
+		Maintenance:      maintenance,
+		Affinity:         affinity,
+		Timeouts:         timeouts,
+		WebSocket:        webSocket,
+		Origins:          origins,
+		SecurityHeaders:  securityHeaders,
+		FlushInterval:    flushInterval,
+		GRPCWeb:          grpcWeb,
+		UpgradeProtocols: inspector.upgradeProtocols(service, key),
 	}, nil
 }
 
-func (inspector *ServiceInspector) description(service *swarm.Service) string {
-	if value, ok := service.Spec.Labels[descriptionLabel]; ok {
+// upgradeProtocols returns the protocols, other than websockets, that clients
+// may upgrade their connections to the endpoint to.
+func (inspector *ServiceInspector) upgradeProtocols(
+	service *swarm.Service,
+	key string,
+) []string {
+	if _, value, ok := label(service, upgradeLabel, key); ok {
+		return backend.ParseUpgradeProtocols(value)
+	}
+
+	return nil
+}
+
+// grpcWeb returns true if gRPC-Web requests to the endpoint are translated
+// into native gRPC requests.
+func (inspector *ServiceInspector) grpcWeb(
+	service *swarm.Service,
+	key string,
+) (bool, error) {
+	name, value, ok := label(service, grpcWebLabel, key)
+	if !ok {
+		return false, nil
+	}
+
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf(
+			"invalid '%s' label (%s), expected 'true' or 'false'",
+			name,
+			value,
+		)
+	}
+
+	return enabled, nil
+}
+
+// flushInterval returns the interval at which streaming responses from the
+// endpoint are flushed to the client, or zero to use the default.
+func (inspector *ServiceInspector) flushInterval(
+	service *swarm.Service,
+	key string,
+) (time.Duration, error) {
+	name, value, ok := label(service, flushLabel, key)
+	if !ok {
+		return 0, nil
+	}
+
+	interval, ok := backend.ParseFlushInterval(value)
+	if !ok {
+		return 0, fmt.Errorf(
+			"invalid '%s' label (%s), expected 'immediate' or a positive duration, such as '100ms'",
+			name,
+			value,
+		)
+	}
+
+	return interval, nil
+}
+
+// timeouts returns the upstream timeouts for the endpoint. Timeouts that are
+// not specified are left as zero, so that the defaults are used.
+func (inspector *ServiceInspector) timeouts(
+	service *swarm.Service,
+	key string,
+) (backend.Timeouts, error) {
+	var t backend.Timeouts
+
+	for _, l := range []struct {
+		base string
+		d    *time.Duration
+	}{
+		{timeoutDialLabel, &t.Dial},
+		{timeoutTLSHandshakeLabel, &t.TLSHandshake},
+		{timeoutResponseHeaderLabel, &t.ResponseHeader},
+		{timeoutIdleLabel, &t.Idle},
+		{timeoutTotalLabel, &t.Total},
+	} {
+		name, value, ok := label(service, l.base, key)
+		if !ok {
+			continue
+		}
+
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return backend.Timeouts{}, fmt.Errorf(
+				"invalid '%s' label (%s), expected a positive duration, such as '30s'",
+				name,
+				value,
+			)
+		}
+
+		*l.d = timeout
+	}
+
+	return t, nil
+}
+
+// webSocketLimits returns the limits for websocket connections to the
+// endpoint. Limits that are not specified are left as zero, so that the
+// defaults are used.
+func (inspector *ServiceInspector) webSocketLimits(
+	service *swarm.Service,
+	key string,
+) (backend.WebSocketLimits, error) {
+	var l backend.WebSocketLimits
+
+	for _, d := range []struct {
+		base string
+		d    *time.Duration
+	}{
+		{webSocketIdleTimeoutLabel, &l.IdleTimeout},
+		{webSocketPingIntervalLabel, &l.PingInterval},
+		{webSocketMaxLifetimeLabel, &l.MaxLifetime},
+	} {
+		name, value, ok := label(service, d.base, key)
+		if !ok {
+			continue
+		}
+
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration <= 0 {
+			return backend.WebSocketLimits{}, fmt.Errorf(
+				"invalid '%s' label (%s), expected a positive duration, such as '30s'",
+				name,
+				value,
+			)
+		}
+
+		*d.d = duration
+	}
+
+	for _, n := range []struct {
+		base string
+		n    *int
+	}{
+		{webSocketMaxConnectionsLabel, &l.MaxConnections},
+		{webSocketMaxConnectionsPerClientLabel, &l.MaxConnectionsPerClient},
+	} {
+		name, value, ok := label(service, n.base, key)
+		if !ok {
+			continue
+		}
+
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return backend.WebSocketLimits{}, fmt.Errorf(
+				"invalid '%s' label (%s), expected a positive integer",
+				name,
+				value,
+			)
+		}
+
+		*n.n = limit
+	}
+
+	if name, value, ok := label(service, webSocketMaxMessageSizeLabel, key); ok {
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || size <= 0 {
+			return backend.WebSocketLimits{}, fmt.Errorf(
+				"invalid '%s' label (%s), expected a positive number of bytes",
+				name,
+				value,
+			)
+		}
+
+		l.MaxMessageSize = size
+	}
+
+	if name, value, ok := label(service, webSocketInspectLabel, key); ok {
+		inspect, err := strconv.ParseBool(value)
+		if err != nil {
+			return backend.WebSocketLimits{}, fmt.Errorf(
+				"invalid '%s' label (%s), expected 'true' or 'false'",
+				name,
+				value,
+			)
+		}
+
+		l.Inspect = inspect
+	}
+
+	return l, nil
+}
+
+// origins returns the origins from which websocket connections to the endpoint
+// are allowed, or nil if connections are allowed from any origin.
+func (inspector *ServiceInspector) origins(
+	service *swarm.Service,
+	key string,
+) (*backend.OriginPolicy, error) {
+	var policy backend.OriginPolicy
+
+	if _, value, ok := label(service, webSocketOriginsLabel, key); ok {
+		policy.Allowed = backend.ParseOrigins(value)
+	}
+
+	if name, value, ok := label(service, webSocketSameHostLabel, key); ok {
+		sameHost, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"invalid '%s' label (%s), expected 'true' or 'false'",
+				name,
+				value,
+			)
+		}
+
+		policy.SameHost = sameHost
+	}
+
+	if len(policy.Allowed) == 0 && !policy.SameHost {
+		return nil, nil
+	}
+
+	return &policy, nil
+}
+
+// securityHeaders returns the security headers added to responses from the
+// endpoint. Headers that are not specified are left empty, so that the
+// defaults are used.
+func (inspector *ServiceInspector) securityHeaders(
+	service *swarm.Service,
+	key string,
+) (backend.SecurityHeaders, error) {
+	var h backend.SecurityHeaders
+
+	if name, value, ok := label(service, hstsLabel, key); ok {
+		hsts, ok := backend.ParseHSTS(value)
+		if !ok {
+			return backend.SecurityHeaders{}, fmt.Errorf(
+				"invalid '%s' label (%s), expected 'off' or a policy, such as 'max-age=31536000; includeSubDomains'",
+				name,
+				value,
+			)
+		}
+
+		h.HSTS = hsts
+	}
+
+	for _, l := range []struct {
+		base  string
+		value *string
+	}{
+		{contentSecurityPolicyLabel, &h.ContentSecurityPolicy},
+		{frameOptionsLabel, &h.FrameOptions},
+		{contentTypeOptionsLabel, &h.ContentTypeOptions},
+		{referrerPolicyLabel, &h.ReferrerPolicy},
+		{permissionsPolicyLabel, &h.PermissionsPolicy},
+	} {
+		if _, value, ok := label(service, l.base, key); ok {
+			*l.value = value
+		}
+	}
+
+	if name, value, ok := label(service, preserveSecurityHeadersLabel, key); ok {
+		preserve, err := strconv.ParseBool(value)
+		if err != nil {
+			return backend.SecurityHeaders{}, fmt.Errorf(
+				"invalid '%s' label (%s), expected 'true' or 'false'",
+				name,
+				value,
+			)
+		}
+
+		h.Preserve = &preserve
+	}
+
+	return h, nil
+}
+
+// affinity returns the session affinity settings for the endpoint, or nil if
+// requests are routed independently.
+func (inspector *ServiceInspector) affinity(
+	service *swarm.Service,
+	key string,
+) (*backend.Affinity, error) {
+	name, value, ok := label(service, affinityLabel, key)
+	if !ok {
+		return nil, nil
+	}
+
+	mode, ok := backend.ParseAffinityMode(value)
+	if !ok {
+		return nil, fmt.Errorf(
+			"invalid '%s' label (%s), expected 'cookie', 'client-ip' or 'none'",
+			name,
+			value,
+		)
+	} else if mode == backend.AffinityNone {
+		return nil, nil
+	}
+
+	a := &backend.Affinity{Mode: mode}
+
+	if _, value, ok := label(service, affinityCookieLabel, key); ok {
+		a.CookieName = value
+	}
+
+	if name, value, ok := label(service, affinityTTLLabel, key); ok {
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl < 0 {
+			return nil, fmt.Errorf(
+				"invalid '%s' label (%s), expected a duration, such as '1h'",
+				name,
+				value,
+			)
+		}
+
+		a.CookieTTL = ttl
+	}
+
+	return a, nil
+}
+
+// maintenance returns the maintenance information for the endpoint, or nil if
+// it is not offline for maintenance.
+func (inspector *ServiceInspector) maintenance(
+	service *swarm.Service,
+	key string,
+) (*backend.Maintenance, error) {
+	name, value, ok := label(service, maintenanceLabel, key)
+	if !ok {
+		return nil, nil
+	}
+
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"invalid '%s' label (%s), expected 'true' or 'false'",
+			name,
+			value,
+		)
+	} else if !enabled {
+		return nil, nil
+	}
+
+	m := &backend.Maintenance{}
+
+	if _, value, ok := label(service, maintenanceMessageLabel, key); ok {
+		m.Message = value
+	}
+
+	if name, value, ok := label(service, maintenanceRetryAfterLabel, key); ok {
+		m.RetryAfter, err = time.ParseDuration(value)
+		if err != nil || m.RetryAfter < 0 {
+			return nil, fmt.Errorf(
+				"invalid '%s' label (%s), expected a duration, such as '15m'",
+				name,
+				value,
+			)
+		}
+	}
+
+	if name, value, ok := label(service, maintenanceAllowLabel, key); ok {
+		m.AllowedNetworks, err = backend.ParseNetworks(value)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"invalid '%s' label (%s), expected a comma-separated list of IP addresses or networks",
+				name,
+				value,
+			)
+		}
+	}
+
+	return m, nil
+}
+
+func (inspector *ServiceInspector) description(service *swarm.Service, key string) string {
+	if _, value, ok := label(service, descriptionLabel, key); ok {
 		return value
 	}
 
@@ -60,25 +508,19 @@ func (inspector *ServiceInspector) description(service *swarm.Service) string {
 
 func (inspector *ServiceInspector) tlsMode(
 	service *swarm.Service,
+	key string,
 	port string,
 ) (backend.TLSMode, error) {
-	if value, ok := service.Spec.Labels[tlsLabel]; ok {
-		switch value {
-		case "true", "enabled":
-			return backend.TLSEnabled, nil
-		case "false", "disabled":
-			return backend.TLSDisabled, nil
-		case "insecure":
-			return backend.TLSInsecure, nil
-		case "h2c":
-			return backend.TLSDisabledH2C, nil
-		default:
-			return backend.TLSDisabled, fmt.Errorf(
-				"invalid '%s' label (%s), expected 'enabled', 'disabled' or 'insecure'",
-				tlsLabel,
-				value,
-			)
+	if name, value, ok := label(service, tlsLabel, key); ok {
+		if mode, ok := backend.ParseTLSMode(value); ok {
+			return mode, nil
 		}
+
+		return backend.TLSDisabled, fmt.Errorf(
+			"invalid '%s' label (%s), expected 'enabled', 'disabled', 'insecure' or 'h2c'",
+			name,
+			value,
+		)
 	}
 
 	numeric, _ := net.LookupPort("tcp", port)
@@ -90,18 +532,65 @@ func (inspector *ServiceInspector) tlsMode(
 	}
 }
 
+// protocol returns the HTTP protocol version used to communicate with the
+// endpoint when it uses TLS.
+func (inspector *ServiceInspector) protocol(
+	service *swarm.Service,
+	key string,
+) (backend.Protocol, error) {
+	name, value, ok := label(service, protocolLabel, key)
+	if !ok {
+		return backend.ProtocolAuto, nil
+	}
+
+	protocol, ok := backend.ParseProtocol(value)
+	if !ok {
+		return backend.ProtocolAuto, fmt.Errorf(
+			"invalid '%s' label (%s), expected 'auto', 'h1' or 'h2'",
+			name,
+			value,
+		)
+	}
+
+	return protocol, nil
+}
+
+// httpMode returns how requests for the endpoint that are received over plain
+// HTTP are handled.
+func (inspector *ServiceInspector) httpMode(
+	service *swarm.Service,
+	key string,
+) (backend.HTTPMode, error) {
+	name, value, ok := label(service, httpLabel, key)
+	if !ok {
+		return backend.HTTPRedirect, nil
+	}
+
+	mode, ok := backend.ParseHTTPMode(value)
+	if !ok {
+		return backend.HTTPRedirect, fmt.Errorf(
+			"invalid '%s' label (%s), expected 'redirect' or 'serve'",
+			name,
+			value,
+		)
+	}
+
+	return mode, nil
+}
+
 func (inspector *ServiceInspector) port(
 	ctx context.Context,
 	service *swarm.Service,
+	key string,
 ) (string, error) {
 	// Trust whatever is in the port label if it's present ...
-	if value, ok := service.Spec.Labels[portLabel]; ok {
+	if name, value, ok := label(service, portLabel, key); ok {
 		_, err := net.LookupPort("tcp", value)
 
 		if err != nil {
 			return "", fmt.Errorf(
 				"invalid '%s' label (%s), expected port name or number",
-				portLabel,
+				name,
 				value,
 			)
 		}
@@ -109,7 +598,7 @@ func (inspector *ServiceInspector) port(
 		return value, nil
 	}
 
-	ports, err := inspector.exposedPorts(ctx, service)
+	ports, err := inspector.exposedPorts(ctx, service, key)
 	if err != nil {
 		return "", err
 	} else if len(ports) == 0 {
@@ -122,35 +611,111 @@ func (inspector *ServiceInspector) port(
 			"'%s' image exposes multiple TCP ports (%s), add a '%s' label to the service to select one",
 			service.Spec.TaskTemplate.ContainerSpec.Image,
 			strings.Join(ports, ", "),
-			portLabel,
+			labelName(portLabel, key),
 		)
 	}
 
 	return ports[0], nil
 }
 
+// exposedPorts returns the TCP ports exposed by the service's image. key is
+// the label key of the endpoint, as per labelName().
+//
+// Results are cached by image digest, so images are only inspected once for as
+// long as the service's image does not change. Images that are not pinned to a
+// digest are cached by reference, and inspected again periodically.
+//
+// If the image is not present on the local node, the target ports published by
+// the service are used instead. The registry can not be used as a fallback, as
+// the Engine API's distribution inspection only describes the image manifest,
+// not the image configuration that declares the exposed ports.
 func (inspector *ServiceInspector) exposedPorts(
 	ctx context.Context,
 	service *swarm.Service,
+	key string,
 ) ([]string, error) {
-	image, _, err := inspector.Client.ImageInspectWithRaw(
-		ctx,
-		service.Spec.TaskTemplate.ContainerSpec.Image,
-	)
+	image := service.Spec.TaskTemplate.ContainerSpec.Image
+	cacheKey, pinned := imageCacheKey(image)
+
+	if entry, ok := inspector.cache.Get(cacheKey); ok {
+		if entry.Present {
+			return entry.Ports, nil
+		}
+
+		return inspector.publishedPorts(service, key)
+	}
+
+	info, _, err := inspector.Client.ImageInspectWithRaw(ctx, image)
 	if err != nil {
-		return nil, err
+		if !client.IsErrNotFound(err) {
+			return nil, fmt.Errorf("can not inspect '%s' image, %s", image, err)
+		}
+
+		ttl := inspector.MissingImageTTL
+		if ttl == 0 {
+			ttl = DefaultMissingImageTTL
+		}
+
+		inspector.cache.Put(cacheKey, imageCacheEntry{}, ttl)
+
+		return inspector.publishedPorts(service, key)
 	}
 
 	var ports []string
 
-	for p := range image.Config.ExposedPorts {
-		if p.Proto() == "tcp" {
-			ports = append(
-				ports,
-				p.Port(),
-			)
+	if info.Config != nil {
+		for p := range info.Config.ExposedPorts {
+			if p.Proto() == "tcp" {
+				ports = append(
+					ports,
+					p.Port(),
+				)
+			}
 		}
 	}
 
+	sort.Strings(ports)
+
+	var ttl time.Duration
+	if !pinned {
+		ttl = unpinnedImageTTL
+	}
+
+	inspector.cache.Put(cacheKey, imageCacheEntry{Present: true, Ports: ports}, ttl)
+
+	return ports, nil
+}
+
+// publishedPorts returns the target ports of the TCP ports published by the
+// service, for use when its image can not be inspected.
+func (inspector *ServiceInspector) publishedPorts(
+	service *swarm.Service,
+	key string,
+) ([]string, error) {
+	var configs []swarm.PortConfig
+	if service.Spec.EndpointSpec != nil {
+		configs = service.Spec.EndpointSpec.Ports
+	}
+
+	var ports []string
+	seen := map[uint32]bool{}
+
+	for _, c := range configs {
+		if c.Protocol != swarm.PortConfigProtocolTCP || seen[c.TargetPort] {
+			continue
+		}
+
+		seen[c.TargetPort] = true
+		ports = append(ports, strconv.FormatUint(uint64(c.TargetPort), 10))
+	}
+
+	if len(ports) == 0 {
+		return nil, fmt.Errorf(
+			"'%s' image is not present on this node and the service does not publish any TCP ports, add a '%s' label to the service to select one",
+			service.Spec.TaskTemplate.ContainerSpec.Image,
+			labelName(portLabel, key),
+		)
+	}
+
 	return ports, nil
 }
@@ -0,0 +1,426 @@
+package docker_test
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/go-connections/nat"
+	"github.com/icecave/honeycomb/backend"
+	"github.com/icecave/honeycomb/docker"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ServiceInspector", func() {
+	const pinnedImage = "app:latest@sha256:0123456789012345678901234567890123456789012345678901234567890123"
+
+	var (
+		fake    *fakeClient
+		subject *docker.ServiceInspector
+	)
+
+	BeforeEach(func() {
+		fake = &fakeClient{
+			Images: map[string]types.ImageInspect{
+				pinnedImage: {
+					Config: &container.Config{
+						ExposedPorts: nat.PortSet{"8080/tcp": {}},
+					},
+				},
+			},
+		}
+
+		subject = &docker.ServiceInspector{Client: fake}
+	})
+
+	Describe("Inspect", func() {
+		It("uses the port exposed by the image", func() {
+			service := newService("app", pinnedImage, nil)
+
+			endpoint, err := subject.Inspect(context.Background(), &service, "")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(endpoint.Address).To(Equal("app:8080"))
+		})
+
+		It("caches the exposed ports by image digest", func() {
+			service := newService("app", pinnedImage, nil)
+
+			subject.Inspect(context.Background(), &service, "")
+			subject.Inspect(context.Background(), &service, "")
+
+			Expect(fake.ImageInspections).To(Equal(1))
+		})
+
+		It("caches the exposed ports of images without a digest by reference", func() {
+			fake.Images["app:latest"] = fake.Images[pinnedImage]
+			service := newService("app", "app:latest", nil)
+
+			subject.Inspect(context.Background(), &service, "")
+			subject.Inspect(context.Background(), &service, "")
+
+			Expect(fake.ImageInspections).To(Equal(1))
+		})
+
+		Context("when the image is not present on the node", func() {
+			It("uses the target port published by the service", func() {
+				service := newService("app", "missing:latest", nil)
+				service.Spec.EndpointSpec = &swarm.EndpointSpec{
+					Ports: []swarm.PortConfig{
+						{
+							Protocol:      swarm.PortConfigProtocolTCP,
+							TargetPort:    3000,
+							PublishedPort: 80,
+						},
+					},
+				}
+
+				endpoint, err := subject.Inspect(context.Background(), &service, "")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(endpoint.Address).To(Equal("app:3000"))
+			})
+
+			It("remembers that the image is missing", func() {
+				service := newService("app", "missing:latest@sha256:0123456789012345678901234567890123456789012345678901234567890123", nil)
+
+				subject.Inspect(context.Background(), &service, "")
+				subject.Inspect(context.Background(), &service, "")
+
+				Expect(fake.ImageInspections).To(Equal(1))
+			})
+
+			It("returns a descriptive error if the service does not publish any ports", func() {
+				service := newService("app", "missing:latest", nil)
+
+				_, err := subject.Inspect(context.Background(), &service, "")
+				Expect(err).To(MatchError(
+					"'missing:latest' image is not present on this node and the service does not publish any TCP ports, add a 'honeycomb.port' label to the service to select one",
+				))
+			})
+
+			It("names the port label of the endpoint in the error", func() {
+				service := newService("app", "missing:latest", nil)
+
+				_, err := subject.Inspect(context.Background(), &service, "admin")
+				Expect(err).To(MatchError(
+					"'missing:latest' image is not present on this node and the service does not publish any TCP ports, add a 'honeycomb.port.admin' label to the service to select one",
+				))
+			})
+		})
+
+		Context("when the service has a maintenance label", func() {
+			It("does not set maintenance information when disabled", func() {
+				service := newService("app", pinnedImage, map[string]string{
+					"honeycomb.maintenance": "false",
+				})
+
+				endpoint, err := subject.Inspect(context.Background(), &service, "")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(endpoint.Maintenance).To(BeNil())
+			})
+
+			It("sets the maintenance information when enabled", func() {
+				service := newService("app", pinnedImage, map[string]string{
+					"honeycomb.maintenance":             "true",
+					"honeycomb.maintenance-message":     "Back soon.",
+					"honeycomb.maintenance-retry-after": "15m",
+					"honeycomb.maintenance-allow":       "10.0.0.0/8",
+				})
+
+				endpoint, err := subject.Inspect(context.Background(), &service, "")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(endpoint.Maintenance.Message).To(Equal("Back soon."))
+				Expect(endpoint.Maintenance.RetryAfter).To(Equal(15 * time.Minute))
+				Expect(endpoint.Maintenance.Allows(net.ParseIP("10.1.2.3"))).To(BeTrue())
+			})
+
+			It("prefers the label with the same key", func() {
+				service := newService("app", pinnedImage, map[string]string{
+					"honeycomb.maintenance":       "true",
+					"honeycomb.maintenance.admin": "false",
+				})
+
+				endpoint, err := subject.Inspect(context.Background(), &service, "admin")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(endpoint.Maintenance).To(BeNil())
+			})
+
+			It("returns an error if the label is invalid", func() {
+				service := newService("app", pinnedImage, map[string]string{
+					"honeycomb.maintenance": "maybe",
+				})
+
+				_, err := subject.Inspect(context.Background(), &service, "")
+				Expect(err).To(MatchError(
+					"invalid 'honeycomb.maintenance' label (maybe), expected 'true' or 'false'",
+				))
+			})
+		})
+
+		Context("when the service has an affinity label", func() {
+			It("sets the affinity settings", func() {
+				service := newService("app", pinnedImage, map[string]string{
+					"honeycomb.affinity":        "cookie",
+					"honeycomb.affinity-cookie": "session",
+					"honeycomb.affinity-ttl":    "1h",
+				})
+
+				endpoint, err := subject.Inspect(context.Background(), &service, "")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(endpoint.Affinity).To(Equal(&backend.Affinity{
+					Mode:       backend.AffinityCookie,
+					CookieName: "session",
+					CookieTTL:  time.Hour,
+				}))
+			})
+
+			It("returns an error if the label is invalid", func() {
+				service := newService("app", pinnedImage, map[string]string{
+					"honeycomb.affinity": "sometimes",
+				})
+
+				_, err := subject.Inspect(context.Background(), &service, "")
+				Expect(err).To(MatchError(
+					"invalid 'honeycomb.affinity' label (sometimes), expected 'cookie', 'client-ip' or 'none'",
+				))
+			})
+		})
+
+		Context("when the service has timeout labels", func() {
+			It("sets the timeouts", func() {
+				service := newService("app", pinnedImage, map[string]string{
+					"honeycomb.timeout-dial":                  "5s",
+					"honeycomb.timeout-response-header":       "30s",
+					"honeycomb.timeout-response-header.admin": "5m",
+				})
+
+				endpoint, err := subject.Inspect(context.Background(), &service, "admin")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(endpoint.Timeouts).To(Equal(backend.Timeouts{
+					Dial:           5 * time.Second,
+					ResponseHeader: 5 * time.Minute,
+				}))
+			})
+
+			It("returns an error if a label is invalid", func() {
+				service := newService("app", pinnedImage, map[string]string{
+					"honeycomb.timeout-total": "0s",
+				})
+
+				_, err := subject.Inspect(context.Background(), &service, "")
+				Expect(err).To(MatchError(
+					"invalid 'honeycomb.timeout-total' label (0s), expected a positive duration, such as '30s'",
+				))
+			})
+		})
+
+		Context("when the service has websocket labels", func() {
+			It("sets the websocket limits", func() {
+				service := newService("app", pinnedImage, map[string]string{
+					"honeycomb.websocket-idle-timeout":                     "5m",
+					"honeycomb.websocket-ping-interval":                    "30s",
+					"honeycomb.websocket-max-connections":                  "100",
+					"honeycomb.websocket-max-connections-per-client.admin": "2",
+					"honeycomb.websocket-max-message-size":                 "65536",
+					"honeycomb.websocket-inspect":                          "true",
+				})
+
+				endpoint, err := subject.Inspect(context.Background(), &service, "admin")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(endpoint.WebSocket).To(Equal(backend.WebSocketLimits{
+					IdleTimeout:             5 * time.Minute,
+					PingInterval:            30 * time.Second,
+					MaxConnections:          100,
+					MaxConnectionsPerClient: 2,
+					MaxMessageSize:          65536,
+					Inspect:                 true,
+				}))
+			})
+
+			It("returns an error if a label is invalid", func() {
+				service := newService("app", pinnedImage, map[string]string{
+					"honeycomb.websocket-max-connections": "none",
+				})
+
+				_, err := subject.Inspect(context.Background(), &service, "")
+				Expect(err).To(MatchError(
+					"invalid 'honeycomb.websocket-max-connections' label (none), expected a positive integer",
+				))
+			})
+		})
+
+		Context("when the service has websocket origin labels", func() {
+			It("sets the origin policy", func() {
+				service := newService("app", pinnedImage, map[string]string{
+					"honeycomb.websocket-origins":   "https://app.example.com, https://*.example.org",
+					"honeycomb.websocket-same-host": "true",
+				})
+
+				endpoint, err := subject.Inspect(context.Background(), &service, "")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(endpoint.Origins).To(Equal(&backend.OriginPolicy{
+					Allowed:  []string{"https://app.example.com", "https://*.example.org"},
+					SameHost: true,
+				}))
+			})
+
+			It("does not set an origin policy by default", func() {
+				service := newService("app", pinnedImage, nil)
+
+				endpoint, err := subject.Inspect(context.Background(), &service, "")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(endpoint.Origins).To(BeNil())
+			})
+		})
+
+		Context("when the service has security header labels", func() {
+			It("sets the security headers", func() {
+				service := newService("app", pinnedImage, map[string]string{
+					"honeycomb.hsts":                      "max-age=31536000; includeSubDomains; preload",
+					"honeycomb.content-security-policy":   "default-src 'self'",
+					"honeycomb.frame-options":             "DENY",
+					"honeycomb.content-type-options":      "nosniff",
+					"honeycomb.referrer-policy":           "no-referrer",
+					"honeycomb.permissions-policy":        "camera=()",
+					"honeycomb.preserve-security-headers": "true",
+				})
+
+				endpoint, err := subject.Inspect(context.Background(), &service, "")
+				Expect(err).ShouldNot(HaveOccurred())
+
+				preserve := true
+				Expect(endpoint.SecurityHeaders).To(Equal(backend.SecurityHeaders{
+					HSTS: &backend.HSTSPolicy{
+						MaxAge:            365 * 24 * time.Hour,
+						IncludeSubDomains: true,
+						Preload:           true,
+					},
+					ContentSecurityPolicy: "default-src 'self'",
+					FrameOptions:          "DENY",
+					ContentTypeOptions:    "nosniff",
+					ReferrerPolicy:        "no-referrer",
+					PermissionsPolicy:     "camera=()",
+					Preserve:              &preserve,
+				}))
+			})
+
+			It("returns an error if the HSTS label is invalid", func() {
+				service := newService("app", pinnedImage, map[string]string{
+					"honeycomb.hsts": "forever",
+				})
+
+				_, err := subject.Inspect(context.Background(), &service, "")
+				Expect(err).To(MatchError(
+					"invalid 'honeycomb.hsts' label (forever), expected 'off' or a policy, such as 'max-age=31536000; includeSubDomains'",
+				))
+			})
+		})
+
+		Context("when the service has a flush interval label", func() {
+			It("sets the flush interval", func() {
+				service := newService("app", pinnedImage, map[string]string{
+					"honeycomb.flush-interval": "immediate",
+				})
+
+				endpoint, err := subject.Inspect(context.Background(), &service, "")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(endpoint.FlushInterval).To(Equal(backend.FlushImmediately))
+			})
+
+			It("returns an error if the label is invalid", func() {
+				service := newService("app", pinnedImage, map[string]string{
+					"honeycomb.flush-interval": "often",
+				})
+
+				_, err := subject.Inspect(context.Background(), &service, "")
+				Expect(err).To(MatchError(
+					"invalid 'honeycomb.flush-interval' label (often), expected 'immediate' or a positive duration, such as '100ms'",
+				))
+			})
+		})
+
+		Context("when the service has a gRPC-Web label", func() {
+			It("enables gRPC-Web translation", func() {
+				service := newService("app", pinnedImage, map[string]string{
+					"honeycomb.grpc-web": "true",
+				})
+
+				endpoint, err := subject.Inspect(context.Background(), &service, "")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(endpoint.GRPCWeb).To(BeTrue())
+			})
+
+			It("returns an error if the label is invalid", func() {
+				service := newService("app", pinnedImage, map[string]string{
+					"honeycomb.grpc-web": "yes please",
+				})
+
+				_, err := subject.Inspect(context.Background(), &service, "")
+				Expect(err).To(MatchError(
+					"invalid 'honeycomb.grpc-web' label (yes please), expected 'true' or 'false'",
+				))
+			})
+		})
+
+		Context("when the service has a protocol label", func() {
+			It("sets the protocol", func() {
+				service := newService("app", pinnedImage, map[string]string{
+					"honeycomb.protocol": "h2",
+				})
+
+				endpoint, err := subject.Inspect(context.Background(), &service, "")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(endpoint.Protocol).To(Equal(backend.ProtocolHTTP2))
+			})
+
+			It("returns an error if the label is invalid", func() {
+				service := newService("app", pinnedImage, map[string]string{
+					"honeycomb.protocol": "spdy",
+				})
+
+				_, err := subject.Inspect(context.Background(), &service, "")
+				Expect(err).To(MatchError(
+					"invalid 'honeycomb.protocol' label (spdy), expected 'auto', 'h1' or 'h2'",
+				))
+			})
+		})
+
+		Context("when the service has an http label", func() {
+			It("sets the HTTP mode", func() {
+				service := newService("app", pinnedImage, map[string]string{
+					"honeycomb.http": "serve",
+				})
+
+				endpoint, err := subject.Inspect(context.Background(), &service, "")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(endpoint.HTTPMode).To(Equal(backend.HTTPServe))
+			})
+
+			It("returns an error if the label is invalid", func() {
+				service := newService("app", pinnedImage, map[string]string{
+					"honeycomb.http": "allow",
+				})
+
+				_, err := subject.Inspect(context.Background(), &service, "")
+				Expect(err).To(MatchError(
+					"invalid 'honeycomb.http' label (allow), expected 'redirect' or 'serve'",
+				))
+			})
+		})
+
+		Context("when the service has an upgrade label", func() {
+			It("sets the upgrade protocols", func() {
+				service := newService("app", pinnedImage, map[string]string{
+					"honeycomb.upgrade": "tcp, SPDY/3.1",
+				})
+
+				endpoint, err := subject.Inspect(context.Background(), &service, "")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(endpoint.UpgradeProtocols).To(Equal([]string{"tcp", "SPDY/3.1"}))
+			})
+		})
+	})
+})
@@ -2,13 +2,18 @@ package docker
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/swarm"
 	"github.com/docker/docker/client"
 	"github.com/icecave/honeycomb/name"
+	"github.com/icecave/honeycomb/rule"
 )
 
 // ServiceLoader loads information about Docker services that are marked as
@@ -38,7 +43,7 @@ func (loader *ServiceLoader) Load(
 			continue
 		}
 
-		endpoint, err := loader.Inspector.Inspect(ctx, &service)
+		idleTimeout, err := loader.idleTimeout(service)
 		if err != nil {
 			loader.Logger.Printf(
 				"Can not route to '%s' (%s), %s",
@@ -49,11 +54,79 @@ func (loader *ServiceLoader) Load(
 			continue
 		}
 
-		for _, matcher := range matchers {
+		var replicas *uint64
+		if service.Spec.Mode.Replicated != nil {
+			replicas = service.Spec.Mode.Replicated.Replicas
+		}
+
+		var keys []string
+		for key := range matchers {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			matcher := matchers[key]
+
+			endpoint, err := loader.Inspector.Inspect(ctx, &service, key)
+			if err != nil {
+				loader.Logger.Printf(
+					"Can not route to '%s' (%s) via '%s', %s",
+					service.Spec.Name,
+					service.Spec.TaskTemplate.ContainerSpec.Image,
+					matcher.Pattern,
+					err,
+				)
+				continue
+			}
+
+			priority, err := loader.priority(service, key)
+			if err != nil {
+				loader.Logger.Printf(
+					"Can not route to '%s' (%s) via '%s', %s",
+					service.Spec.Name,
+					service.Spec.TaskTemplate.ContainerSpec.Image,
+					matcher.Pattern,
+					err,
+				)
+				continue
+			}
+
+			weight, err := loader.weight(service, key)
+			if err != nil {
+				loader.Logger.Printf(
+					"Can not route to '%s' (%s) via '%s', %s",
+					service.Spec.Name,
+					service.Spec.TaskTemplate.ContainerSpec.Image,
+					matcher.Pattern,
+					err,
+				)
+				continue
+			}
+
+			expr, err := loader.rule(service, key)
+			if err != nil {
+				loader.Logger.Printf(
+					"Can not route to '%s' (%s) via '%s', %s",
+					service.Spec.Name,
+					service.Spec.TaskTemplate.ContainerSpec.Image,
+					matcher.Pattern,
+					err,
+				)
+				continue
+			}
+
 			result = append(result, ServiceInfo{
-				Name:     service.Spec.Name,
-				Matcher:  matcher,
-				Endpoint: endpoint,
+				Name:        service.Spec.Name,
+				Matcher:     matcher,
+				Endpoint:    endpoint,
+				Priority:    priority,
+				CreatedAt:   service.CreatedAt,
+				Weight:      weight,
+				Rule:        expr,
+				ServiceID:   service.ID,
+				IdleTimeout: idleTimeout,
+				Replicas:    replicas,
 			})
 		}
 	}
@@ -61,8 +134,92 @@ func (loader *ServiceLoader) Load(
 	return result, nil
 }
 
-func (loader *ServiceLoader) matchers(service swarm.Service) []*name.Matcher {
-	var result []*name.Matcher
+// priority returns the route priority for the match label with the given key.
+func (loader *ServiceLoader) priority(service swarm.Service, key string) (int, error) {
+	name, value, ok := label(&service, priorityLabel, key)
+	if !ok {
+		return 0, nil
+	}
+
+	priority, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf(
+			"invalid '%s' label (%s), expected an integer",
+			name,
+			value,
+		)
+	}
+
+	return priority, nil
+}
+
+// weight returns the route weight for the match label with the given key, or
+// nil if the service does not have a weight label.
+func (loader *ServiceLoader) weight(service swarm.Service, key string) (*int, error) {
+	name, value, ok := label(&service, weightLabel, key)
+	if !ok {
+		return nil, nil
+	}
+
+	weight, err := strconv.Atoi(value)
+	if err != nil || weight < 0 {
+		return nil, fmt.Errorf(
+			"invalid '%s' label (%s), expected a non-negative integer",
+			name,
+			value,
+		)
+	}
+
+	return &weight, nil
+}
+
+// rule returns the rule expression for the match label with the given key, or
+// nil if the service does not have a rule label.
+func (loader *ServiceLoader) rule(service swarm.Service, key string) (*rule.Expression, error) {
+	name, value, ok := label(&service, ruleLabel, key)
+	if !ok {
+		return nil, nil
+	}
+
+	expr, err := rule.ParseExpression(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid '%s' label (%s), %s", name, value, err)
+	}
+
+	return &expr, nil
+}
+
+// idleTimeout returns the amount of time without requests after which the
+// service is scaled to zero replicas.
+func (loader *ServiceLoader) idleTimeout(service swarm.Service) (time.Duration, error) {
+	value, ok := service.Spec.Labels[idleTimeoutLabel]
+	if !ok {
+		return 0, nil
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf(
+			"invalid '%s' label (%s), expected a positive duration, such as '30m'",
+			idleTimeoutLabel,
+			value,
+		)
+	}
+
+	if service.Spec.Mode.Replicated == nil {
+		return 0, fmt.Errorf(
+			"the '%s' label can only be used with replicated services",
+			idleTimeoutLabel,
+		)
+	}
+
+	return timeout, nil
+}
+
+// matchers returns the matchers for each of the service's match labels, keyed
+// by the label's suffix.
+func (loader *ServiceLoader) matchers(service swarm.Service) map[string]*name.Matcher {
+	result := map[string]*name.Matcher{}
 
 	for key, value := range service.Spec.Annotations.Labels {
 		if key == matchLabel || strings.HasPrefix(key, matchLabel+".") {
@@ -79,7 +236,7 @@ func (loader *ServiceLoader) matchers(service swarm.Service) []*name.Matcher {
 				continue
 			}
 
-			result = append(result, matcher)
+			result[strings.TrimPrefix(strings.TrimPrefix(key, matchLabel), ".")] = matcher
 		}
 	}
 
@@ -0,0 +1,120 @@
+package docker_test
+
+import (
+	"context"
+	"log"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+	"github.com/icecave/honeycomb/backend"
+	"github.com/icecave/honeycomb/docker"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ServiceLoader", func() {
+	var (
+		fake    *fakeClient
+		subject *docker.ServiceLoader
+	)
+
+	BeforeEach(func() {
+		fake = &fakeClient{
+			Images: map[string]types.ImageInspect{
+				"app:latest": {
+					Config: &container.Config{
+						ExposedPorts: nat.PortSet{
+							"8080/tcp": {},
+							"9090/tcp": {},
+						},
+					},
+				},
+			},
+		}
+
+		subject = &docker.ServiceLoader{
+			Client:    fake,
+			Inspector: &docker.ServiceInspector{Client: fake},
+			Logger:    log.New(GinkgoWriter, "", 0),
+		}
+	})
+
+	Describe("Load", func() {
+		It("produces an endpoint for each keyed match label", func() {
+			fake.Services = append(fake.Services, newService(
+				"app",
+				"app:latest",
+				map[string]string{
+					"honeycomb.match":             "app.*",
+					"honeycomb.port":              "8080",
+					"honeycomb.match.admin":       "admin.app.*",
+					"honeycomb.port.admin":        "9090",
+					"honeycomb.tls.admin":         "insecure",
+					"honeycomb.description.admin": "App Admin UI",
+				},
+			))
+
+			services, err := subject.Load(context.Background())
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(services).To(HaveLen(2))
+
+			Expect(services[0].Matcher.Pattern).To(Equal("app.*"))
+			Expect(services[0].Endpoint).To(Equal(&backend.Endpoint{
+				Description: "app:latest",
+				Address:     "app:8080",
+				TLSMode:     backend.TLSDisabled,
+			}))
+
+			Expect(services[1].Matcher.Pattern).To(Equal("admin.app.*"))
+			Expect(services[1].Endpoint).To(Equal(&backend.Endpoint{
+				Description: "App Admin UI",
+				Address:     "app:9090",
+				TLSMode:     backend.TLSInsecure,
+			}))
+		})
+
+		It("falls back to the labels without a key", func() {
+			fake.Services = append(fake.Services, newService(
+				"app",
+				"app:latest",
+				map[string]string{
+					"honeycomb.match.a":     "a.*",
+					"honeycomb.match.b":     "b.*",
+					"honeycomb.port":        "8080",
+					"honeycomb.description": "App",
+				},
+			))
+
+			services, err := subject.Load(context.Background())
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(services).To(HaveLen(2))
+
+			for _, info := range services {
+				Expect(info.Endpoint).To(Equal(&backend.Endpoint{
+					Description: "App",
+					Address:     "app:8080",
+					TLSMode:     backend.TLSDisabled,
+				}))
+			}
+		})
+
+		It("skips keyed matches that can not be routed", func() {
+			fake.Services = append(fake.Services, newService(
+				"app",
+				"app:latest",
+				map[string]string{
+					"honeycomb.match":       "app.*",
+					"honeycomb.port":        "8080",
+					"honeycomb.match.admin": "admin.app.*",
+					"honeycomb.port.admin":  "not a port",
+				},
+			))
+
+			services, err := subject.Load(context.Background())
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(services).To(HaveLen(1))
+			Expect(services[0].Matcher.Pattern).To(Equal("app.*"))
+		})
+	})
+})
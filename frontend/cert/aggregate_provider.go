@@ -0,0 +1,49 @@
+package cert
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+
+	"github.com/icecave/honeycomb/name"
+)
+
+// AggregateProvider combines multiple providers to find certificates.
+type AggregateProvider []Provider
+
+// GetCertificate attempts to fetch an existing certificate for the given
+// server name from any of the inner providers. If no such certificate exists,
+// it asks each provider in turn to generate one.
+func (p AggregateProvider) GetCertificate(ctx context.Context, n name.ServerName) (*tls.Certificate, error) {
+	cert, err := p.GetExistingCertificate(ctx, n)
+	if cert != nil || err != nil {
+		return cert, err
+	}
+
+	err = errors.New("aggregate provider has no inner providers")
+
+	for _, provider := range p {
+		cert, err = provider.GetCertificate(ctx, n)
+		if err == nil {
+			return cert, nil
+		}
+	}
+
+	return nil, err
+}
+
+// GetExistingCertificate attempts to fetch an existing certificate for the
+// given server name from the inner providers, in order. It never generates new
+// certificates. A non-nil error indicates an error with the provider itself;
+// otherwise, a nil certificate indicates a failure to find an existing
+// certificate.
+func (p AggregateProvider) GetExistingCertificate(ctx context.Context, n name.ServerName) (*tls.Certificate, error) {
+	for _, provider := range p {
+		cert, err := provider.GetExistingCertificate(ctx, n)
+		if cert != nil || err != nil {
+			return cert, err
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,57 @@
+package cert
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/icecave/honeycomb/name"
+)
+
+// MemoryProvider is a certificate provider that serves certificates held in
+// memory. The set of certificates can be replaced at any time.
+type MemoryProvider struct {
+	certificates atomic.Value // []*tls.Certificate
+}
+
+// Replace replaces the certificates served by the provider. Each certificate
+// must have its Leaf field populated.
+func (p *MemoryProvider) Replace(certificates []*tls.Certificate) {
+	p.certificates.Store(certificates)
+}
+
+// GetCertificate attempts to fetch an existing certificate for the given
+// server name. If no such certificate exists, it returns an error.
+func (p *MemoryProvider) GetCertificate(ctx context.Context, n name.ServerName) (*tls.Certificate, error) {
+	cert, err := p.GetExistingCertificate(ctx, n)
+	if err != nil {
+		return nil, err
+	} else if cert != nil {
+		return cert, err
+	}
+
+	return nil, errors.New("memory provider can not generate certificates")
+}
+
+// GetExistingCertificate attempts to fetch an existing certificate for the
+// given server name. It never generates new certificates. A non-nil error
+// indicates an error with the provider itself; otherwise, a nil certificate
+// indicates a failure to find an existing certificate.
+func (p *MemoryProvider) GetExistingCertificate(_ context.Context, n name.ServerName) (*tls.Certificate, error) {
+	certificates, _ := p.certificates.Load().([]*tls.Certificate)
+	now := time.Now()
+
+	for _, cert := range certificates {
+		if now.After(cert.Leaf.NotAfter) {
+			continue
+		}
+
+		if cert.Leaf.VerifyHostname(n.Punycode) == nil {
+			return cert, nil
+		}
+	}
+
+	return nil, nil
+}
@@ -11,13 +11,25 @@ import (
 type Handler struct {
 	Proxy            http.Handler
 	HealthCheck      ConditionalHandler
+	Maintenance      ConditionalHandler
 	StatusPageWriter statuspage.Writer
 	Logger           *log.Logger
+
+	// AltSvc, if non-empty, is sent as the Alt-Svc header of responses to
+	// requests that were not made over HTTP/3, advertising the HTTP/3
+	// listener to clients.
+	AltSvc string
 }
 
 func (handler *Handler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if handler.AltSvc != "" && request.ProtoMajor < 3 {
+		writer.Header().Set("Alt-Svc", handler.AltSvc)
+	}
+
 	if handler.HealthCheck != nil && handler.HealthCheck.CanHandle(request) {
 		handler.HealthCheck.ServeHTTP(writer, request)
+	} else if handler.Maintenance != nil && handler.Maintenance.CanHandle(request) {
+		handler.Maintenance.ServeHTTP(writer, request)
 	} else {
 		handler.Proxy.ServeHTTP(writer, request)
 	}
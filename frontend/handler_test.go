@@ -0,0 +1,43 @@
+package frontend_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/icecave/honeycomb/frontend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Handler", func() {
+	var subject *frontend.Handler
+
+	BeforeEach(func() {
+		subject = &frontend.Handler{
+			Proxy: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			}),
+			AltSvc: `h3=":443"; ma=86400`,
+		}
+	})
+
+	It("advertises HTTP/3 to clients using earlier protocols", func() {
+		request := httptest.NewRequest("GET", "https://app.example.com/", nil)
+		writer := httptest.NewRecorder()
+
+		subject.ServeHTTP(writer, request)
+
+		Expect(writer.Code).To(Equal(http.StatusNoContent))
+		Expect(writer.Header().Get("Alt-Svc")).To(Equal(`h3=":443"; ma=86400`))
+	})
+
+	It("does not advertise HTTP/3 to clients that are already using it", func() {
+		request := httptest.NewRequest("GET", "https://app.example.com/", nil)
+		request.Proto, request.ProtoMajor, request.ProtoMinor = "HTTP/3.0", 3, 0
+		writer := httptest.NewRecorder()
+
+		subject.ServeHTTP(writer, request)
+
+		Expect(writer.Header()).NotTo(HaveKey("Alt-Svc"))
+	})
+})
@@ -0,0 +1,7 @@
+package kubernetes
+
+const (
+	ingressClassAnnotation = "kubernetes.io/ingress.class"
+	tlsAnnotation          = "honeycomb.tls"
+	descriptionAnnotation  = "honeycomb.description"
+)
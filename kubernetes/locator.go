@@ -0,0 +1,202 @@
+package kubernetes
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/icecave/honeycomb/backend"
+	"github.com/icecave/honeycomb/frontend/cert"
+	"github.com/icecave/honeycomb/name"
+	"k8s.io/client-go/informers"
+	k8s "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// DefaultResyncInterval is the default interval at which the informers
+// re-list all watched resources.
+const DefaultResyncInterval = 10 * time.Minute
+
+// Locator finds a back-end HTTP server based on the server name in TLS
+// requests (SNI) by watching Kubernetes Ingress resources.
+//
+// Requests are routed directly to the ready endpoints of the Service referenced
+// by the Ingress rule, as listed in its EndpointSlices. A route whose Service
+// has no ready endpoints is still matched, but the request is not routed.
+type Locator struct {
+	// Client is the Kubernetes API client used to watch resources.
+	Client k8s.Interface
+
+	// Namespace restricts the resources that are watched to a single
+	// namespace. If it is empty, all namespaces are watched.
+	Namespace string
+
+	// IngressClass restricts the Ingress resources that are used to those with
+	// a matching ingress class. If it is empty, all Ingress resources are used.
+	IngressClass string
+
+	// ResyncInterval is the interval at which the informers re-list all
+	// watched resources. If it is zero, DefaultResyncInterval is used.
+	ResyncInterval time.Duration
+
+	// Cache is cleared whenever the routes change.
+	Cache *backend.Cache
+
+	// Certificates, if non-nil, is populated with the certificates from the
+	// TLS secrets referenced by Ingress resources.
+	Certificates *cert.MemoryProvider
+
+	// Logger is the destination for messages about route changes.
+	Logger *log.Logger
+
+	once   sync.Once
+	done   chan struct{}
+	routes atomic.Value // []route
+}
+
+// Locate finds the back-end HTTP server for the given server name.
+//
+// It returns a score indicating the strength of the match. A value of 0 or less
+// indicates that no match was made, in which case ep is nil.
+//
+// A non-zero score can be returned with a nil endpoint, indicating that the
+// request should not be routed.
+func (locator *Locator) Locate(
+	ctx context.Context,
+	serverName name.ServerName,
+) (ep *backend.Endpoint, score int) {
+	if routes, ok := locator.routes.Load().([]route); ok {
+		for _, r := range routes {
+			if s := r.Matcher.Match(serverName); s > score {
+				ep = r.Endpoint
+				score = s
+			}
+		}
+	}
+
+	return ep, score
+}
+
+// Run watches Kubernetes for changes to routes until Stop() is called.
+func (locator *Locator) Run() {
+	locator.init()
+
+	resyncInterval := locator.ResyncInterval
+	if resyncInterval == 0 {
+		resyncInterval = DefaultResyncInterval
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(
+		locator.Client,
+		resyncInterval,
+		informers.WithNamespace(locator.Namespace),
+	)
+
+	changed := make(chan struct{}, 1)
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { notify(changed) },
+		UpdateFunc: func(interface{}, interface{}) { notify(changed) },
+		DeleteFunc: func(interface{}) { notify(changed) },
+	}
+
+	ingresses := factory.Networking().V1().Ingresses()
+	services := factory.Core().V1().Services()
+	slices := factory.Discovery().V1().EndpointSlices()
+	secrets := factory.Core().V1().Secrets()
+
+	ingresses.Informer().AddEventHandler(handler)
+	services.Informer().AddEventHandler(handler)
+	slices.Informer().AddEventHandler(handler)
+	secrets.Informer().AddEventHandler(handler)
+
+	factory.Start(locator.done)
+	factory.WaitForCacheSync(locator.done)
+
+	builder := &routeBuilder{
+		IngressClass: locator.IngressClass,
+		Ingresses:    ingresses.Lister(),
+		Services:     services.Lister(),
+		Slices:       slices.Lister(),
+		Secrets:      secrets.Lister(),
+		Logger:       locator.Logger,
+	}
+
+	var routes []route
+
+	for {
+		select {
+		case <-changed:
+			r, c := builder.Build()
+
+			if locator.diff(routes, r) {
+				locator.routes.Store(r)
+
+				if locator.Cache != nil {
+					locator.Cache.Clear()
+				}
+			}
+
+			if locator.Certificates != nil {
+				locator.Certificates.Replace(c)
+			}
+
+			routes = r
+		case <-locator.done:
+			return
+		}
+	}
+}
+
+// Stop shuts down the locator and cleans up any resources used.
+func (locator *Locator) Stop() {
+	locator.init()
+	close(locator.done)
+}
+
+// init initializes the locator's internal state, it is safe to call
+// concurrently.
+func (locator *Locator) init() {
+	locator.once.Do(func() {
+		locator.done = make(chan struct{})
+	})
+}
+
+func (locator *Locator) diff(old []route, new []route) bool {
+	diff := false
+
+	for _, r := range old {
+		if !containsRoute(new, r) {
+			diff = true
+			locator.Logger.Printf(
+				"Removed route from '%s' to '%s' (%s)",
+				r.Matcher.Pattern,
+				r.Name,
+				r.Endpoint.Description,
+			)
+		}
+	}
+
+	for _, r := range new {
+		if !containsRoute(old, r) {
+			diff = true
+			locator.Logger.Printf(
+				"Added route from '%s' to '%s' (%s)",
+				r.Matcher.Pattern,
+				r.Name,
+				r.Endpoint.Description,
+			)
+		}
+	}
+
+	return diff
+}
+
+// notify sends a non-blocking signal on ch.
+func notify(ch chan<- struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
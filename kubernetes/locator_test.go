@@ -0,0 +1,357 @@
+package kubernetes_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"log"
+
+	"github.com/icecave/honeycomb/backend"
+	"github.com/icecave/honeycomb/frontend/cert"
+	"github.com/icecave/honeycomb/frontend/cert/generator"
+	"github.com/icecave/honeycomb/kubernetes"
+	"github.com/icecave/honeycomb/name"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("Locator", func() {
+	var (
+		client       *fake.Clientset
+		certificates *cert.MemoryProvider
+		ingressClass string
+		subject      *kubernetes.Locator
+	)
+
+	start := func(objects ...runtime.Object) {
+		client = fake.NewSimpleClientset(objects...)
+		subject = &kubernetes.Locator{
+			Client:       client,
+			IngressClass: ingressClass,
+			Cache:        &backend.Cache{},
+			Certificates: certificates,
+			Logger:       log.New(GinkgoWriter, "", 0),
+		}
+		go subject.Run()
+	}
+
+	locate := func(serverName, path string) func() *backend.Endpoint {
+		return func() *backend.Endpoint {
+			ep, _ := subject.Locate(context.Background(), name.Parse(serverName))
+			return ep.ForPath(path)
+		}
+	}
+
+	BeforeEach(func() {
+		certificates = &cert.MemoryProvider{}
+		ingressClass = ""
+	})
+
+	AfterEach(func() {
+		subject.Stop()
+	})
+
+	Describe("Locate", func() {
+		It("routes ingress host and path rules to services", func() {
+			start(
+				newService("web", 80),
+				newService("api", 8443),
+				newEndpointSlice("web", 8080, true, "10.0.0.1"),
+				newEndpointSlice("api", 8443, true, "10.0.0.2"),
+				newIngress(
+					"ingress",
+					networkingv1.IngressRule{
+						Host: "www.example.com",
+						IngressRuleValue: networkingv1.IngressRuleValue{
+							HTTP: &networkingv1.HTTPIngressRuleValue{
+								Paths: []networkingv1.HTTPIngressPath{
+									newPath("/", "web", 80),
+									newPath("/api", "api", 8443),
+								},
+							},
+						},
+					},
+				),
+			)
+
+			Eventually(locate("www.example.com", "/")).Should(Equal(&backend.Endpoint{
+				Description: "default/web",
+				Address:     "10.0.0.1:8080",
+				TLSMode:     backend.TLSDisabled,
+			}))
+
+			Expect(locate("www.example.com", "/api/users")()).To(Equal(&backend.Endpoint{
+				Description: "default/api",
+				Address:     "10.0.0.2:8443",
+				TLSMode:     backend.TLSEnabled,
+			}))
+
+			Expect(locate("other.example.com", "/")()).To(BeNil())
+		})
+
+		It("does not route to services without ready endpoints", func() {
+			start(
+				newService("web", 80),
+				newEndpointSlice("web", 8080, false, "10.0.0.1"),
+				newIngress(
+					"ingress",
+					networkingv1.IngressRule{
+						Host: "www.example.com",
+						IngressRuleValue: networkingv1.IngressRuleValue{
+							HTTP: &networkingv1.HTTPIngressRuleValue{
+								Paths: []networkingv1.HTTPIngressPath{
+									newPath("/", "web", 80),
+								},
+							},
+						},
+					},
+				),
+			)
+
+			Eventually(func() int {
+				_, score := subject.Locate(context.Background(), name.Parse("www.example.com"))
+				return score
+			}).Should(BeNumerically(">", 0))
+
+			Expect(locate("www.example.com", "/")()).To(BeNil())
+		})
+
+		It("updates routes when the endpoints become ready", func() {
+			start(
+				newService("web", 80),
+				newIngress(
+					"ingress",
+					networkingv1.IngressRule{
+						Host: "www.example.com",
+						IngressRuleValue: networkingv1.IngressRuleValue{
+							HTTP: &networkingv1.HTTPIngressRuleValue{
+								Paths: []networkingv1.HTTPIngressPath{
+									newPath("/", "web", 80),
+								},
+							},
+						},
+					},
+				),
+			)
+
+			_, err := client.DiscoveryV1().
+				EndpointSlices("default").
+				Create(context.Background(), newEndpointSlice("web", 8080, true, "10.0.0.1"), metav1.CreateOptions{})
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Eventually(locate("www.example.com", "/")).ShouldNot(BeNil())
+		})
+
+		It("distributes requests between the ready endpoints of a service", func() {
+			start(
+				newService("web", 80),
+				newEndpointSlice("web", 8080, true, "10.0.0.2", "10.0.0.1"),
+				newIngress(
+					"ingress",
+					networkingv1.IngressRule{
+						Host: "www.example.com",
+						IngressRuleValue: networkingv1.IngressRuleValue{
+							HTTP: &networkingv1.HTTPIngressRuleValue{
+								Paths: []networkingv1.HTTPIngressPath{
+									newPath("/", "web", 80),
+								},
+							},
+						},
+					},
+				),
+			)
+
+			Eventually(locate("www.example.com", "/")).Should(Equal(&backend.Endpoint{
+				Description: "default/web",
+				Alternatives: []*backend.Endpoint{
+					{Description: "default/web", Address: "10.0.0.1:8080", Weight: 1},
+					{Description: "default/web", Address: "10.0.0.2:8080", Weight: 1},
+				},
+			}))
+		})
+
+		It("uses the first default backend for hosts without a rule", func() {
+			web := newPath("/", "web", 80)
+			a := newIngress("a")
+			a.Spec.DefaultBackend = &web.Backend
+
+			api := newPath("/", "api", 80)
+			b := newIngress("b")
+			b.Spec.DefaultBackend = &api.Backend
+
+			start(
+				newService("web", 80),
+				newService("api", 80),
+				newEndpointSlice("web", 80, true, "10.0.0.1"),
+				newEndpointSlice("api", 80, true, "10.0.0.2"),
+				b,
+				a,
+			)
+
+			Eventually(locate("www.example.com", "/")).ShouldNot(BeNil())
+			Consistently(locate("www.example.com", "/")).Should(Equal(&backend.Endpoint{
+				Description: "default/web",
+				Address:     "10.0.0.1:80",
+			}))
+		})
+
+		It("uses the rules without a host of the first ingress for hosts without a rule", func() {
+			a := newIngress("a", networkingv1.IngressRule{
+				IngressRuleValue: networkingv1.IngressRuleValue{
+					HTTP: &networkingv1.HTTPIngressRuleValue{
+						Paths: []networkingv1.HTTPIngressPath{newPath("/", "web", 80)},
+					},
+				},
+			})
+
+			b := newIngress("b", networkingv1.IngressRule{
+				IngressRuleValue: networkingv1.IngressRuleValue{
+					HTTP: &networkingv1.HTTPIngressRuleValue{
+						Paths: []networkingv1.HTTPIngressPath{newPath("/api", "api", 80)},
+					},
+				},
+			})
+
+			start(
+				newService("web", 80),
+				newService("api", 80),
+				newEndpointSlice("web", 80, true, "10.0.0.1"),
+				newEndpointSlice("api", 80, true, "10.0.0.2"),
+				b,
+				a,
+			)
+
+			Eventually(locate("www.example.com", "/")).ShouldNot(BeNil())
+			Consistently(locate("www.example.com", "/api")).Should(Equal(&backend.Endpoint{
+				Description: "default/web",
+				Address:     "10.0.0.1:80",
+			}))
+		})
+
+		It("ignores ingresses with a different ingress class", func() {
+			ing := newIngress(
+				"ingress",
+				networkingv1.IngressRule{Host: "www.example.com"},
+			)
+			ing.Annotations = map[string]string{"kubernetes.io/ingress.class": "other"}
+
+			ingressClass = "honeycomb"
+			start(ing)
+
+			Consistently(func() int {
+				_, score := subject.Locate(context.Background(), name.Parse("www.example.com"))
+				return score
+			}).Should(Equal(0))
+		})
+	})
+
+	It("loads certificates from TLS secrets", func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		gen := &generator.SelfSignedGenerator{ServerKey: key}
+		c, err := gen.Generate(context.Background(), "www.example.com", "www.example.com")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		var certPEM, keyPEM bytes.Buffer
+		pem.Encode(&certPEM, &pem.Block{Type: "CERTIFICATE", Bytes: c.Certificate[0]})
+		pem.Encode(&keyPEM, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+		ing := newIngress("ingress", networkingv1.IngressRule{Host: "www.example.com"})
+		ing.Spec.TLS = []networkingv1.IngressTLS{
+			{Hosts: []string{"www.example.com"}, SecretName: "tls"},
+		}
+
+		start(
+			ing,
+			&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "tls"},
+				Type:       corev1.SecretTypeTLS,
+				Data: map[string][]byte{
+					corev1.TLSCertKey:       certPEM.Bytes(),
+					corev1.TLSPrivateKeyKey: keyPEM.Bytes(),
+				},
+			},
+		)
+
+		Eventually(func() (interface{}, error) {
+			c, err := certificates.GetExistingCertificate(
+				context.Background(),
+				name.Parse("www.example.com"),
+			)
+			if c == nil {
+				return nil, err
+			}
+			return c.Leaf.Subject.CommonName, err
+		}).Should(Equal("www.example.com"))
+	})
+})
+
+func newService(n string, port int32) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: n},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{{Port: port}},
+		},
+	}
+}
+
+func newEndpointSlice(
+	service string,
+	port int32,
+	ready bool,
+	addresses ...string,
+) *discoveryv1.EndpointSlice {
+	name := ""
+	slice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      service + "-abc",
+			Labels: map[string]string{
+				discoveryv1.LabelServiceName: service,
+			},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Ports:       []discoveryv1.EndpointPort{{Name: &name, Port: &port}},
+	}
+
+	for _, addr := range addresses {
+		slice.Endpoints = append(slice.Endpoints, discoveryv1.Endpoint{
+			Addresses:  []string{addr},
+			Conditions: discoveryv1.EndpointConditions{Ready: &ready},
+		})
+	}
+
+	return slice
+}
+
+func newIngress(n string, rules ...networkingv1.IngressRule) *networkingv1.Ingress {
+	return &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: n},
+		Spec:       networkingv1.IngressSpec{Rules: rules},
+	}
+}
+
+func newPath(path, service string, port int32) networkingv1.HTTPIngressPath {
+	pathType := networkingv1.PathTypePrefix
+
+	return networkingv1.HTTPIngressPath{
+		Path:     path,
+		PathType: &pathType,
+		Backend: networkingv1.IngressBackend{
+			Service: &networkingv1.IngressServiceBackend{
+				Name: service,
+				Port: networkingv1.ServiceBackendPort{Number: port},
+			},
+		},
+	}
+}
@@ -0,0 +1,465 @@
+package kubernetes
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/icecave/honeycomb/backend"
+	"github.com/icecave/honeycomb/name"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+	networkinglisters "k8s.io/client-go/listers/networking/v1"
+)
+
+// route is a mapping of a server name pattern to an endpoint, produced from
+// one or more Ingress resources.
+type route struct {
+	Name     string
+	Matcher  *name.Matcher
+	Endpoint *backend.Endpoint
+}
+
+// Equal checks if two routes are identical.
+func (r route) Equal(other route) bool {
+	return r.Name == other.Name &&
+		*r.Matcher == *other.Matcher &&
+		r.Endpoint.Equal(other.Endpoint)
+}
+
+// containsRoute returns true if routes contains a route equal to r.
+func containsRoute(routes []route, r route) bool {
+	for _, other := range routes {
+		if r.Equal(other) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// routeBuilder produces routes and certificates from the current state of the
+// informer caches.
+type routeBuilder struct {
+	IngressClass string
+	Ingresses    networkinglisters.IngressLister
+	Services     corelisters.ServiceLister
+	Slices       discoverylisters.EndpointSliceLister
+	Secrets      corelisters.SecretLister
+	Logger       *log.Logger
+}
+
+// Build returns the routes and certificates for all of the Ingress resources
+// that use the builder's ingress class.
+func (builder *routeBuilder) Build() ([]route, []*tls.Certificate) {
+	ingresses, err := builder.Ingresses.List(labels.Everything())
+	if err != nil {
+		builder.Logger.Println(err)
+		return nil, nil
+	}
+
+	sort.Slice(ingresses, func(i, j int) bool {
+		return qualifiedName(&ingresses[i].ObjectMeta) < qualifiedName(&ingresses[j].ObjectMeta)
+	})
+
+	var routes []route
+	var certificates []*tls.Certificate
+	var catchAllIngress *networkingv1.Ingress
+	index := map[string]int{}
+
+	// claimCatchAll returns true if ing may add paths to the route for hosts
+	// that match no other rule. Only the first ingress, in order of name, that
+	// has a rule without a host or a default backend may do so.
+	claimCatchAll := func(ing *networkingv1.Ingress) bool {
+		if catchAllIngress == nil {
+			catchAllIngress = ing
+		}
+
+		return catchAllIngress == ing
+	}
+
+	add := func(ing *networkingv1.Ingress, host string, p backend.PathEndpoint) {
+		if host == "" {
+			host = "*"
+		}
+
+		i, ok := index[host]
+		if !ok {
+			matcher, err := name.NewMatcher(host)
+			if err != nil {
+				builder.Logger.Printf(
+					"Can not route to ingress '%s' via '%s', %s",
+					qualifiedName(&ing.ObjectMeta),
+					host,
+					err,
+				)
+				return
+			}
+
+			i = len(routes)
+			index[host] = i
+			routes = append(routes, route{
+				Matcher:  matcher,
+				Endpoint: &backend.Endpoint{},
+			})
+		}
+
+		r := &routes[i]
+		n := qualifiedName(&ing.ObjectMeta)
+		if r.Name == "" {
+			r.Name = n
+			r.Endpoint.Description = n
+		} else if !strings.HasSuffix(", "+r.Name, ", "+n) {
+			r.Name += ", " + n
+			r.Endpoint.Description = r.Name
+		}
+
+		r.Endpoint.Paths = append(r.Endpoint.Paths, p)
+	}
+
+	for _, ing := range ingresses {
+		if !builder.hasIngressClass(ing) {
+			continue
+		}
+
+		for _, t := range ing.Spec.TLS {
+			if c := builder.certificate(ing.Namespace, t.SecretName); c != nil {
+				certificates = append(certificates, c)
+			}
+		}
+
+		var defaultEndpoint *backend.Endpoint
+		if ing.Spec.DefaultBackend != nil {
+			defaultEndpoint = builder.endpoint(ing, *ing.Spec.DefaultBackend)
+		}
+
+		hosts := []string{}
+		seen := map[string]bool{}
+
+		for _, rule := range ing.Spec.Rules {
+			if rule.Host == "" && !claimCatchAll(ing) {
+				builder.Logger.Printf(
+					"Ignoring rule without a host in ingress '%s', it conflicts with ingress '%s'",
+					qualifiedName(&ing.ObjectMeta),
+					qualifiedName(&catchAllIngress.ObjectMeta),
+				)
+				continue
+			}
+
+			if !seen[rule.Host] {
+				seen[rule.Host] = true
+				hosts = append(hosts, rule.Host)
+			}
+
+			if rule.HTTP == nil {
+				continue
+			}
+
+			for _, p := range rule.HTTP.Paths {
+				path := p.Path
+				if path == "" {
+					path = "/"
+				}
+
+				add(ing, rule.Host, backend.PathEndpoint{
+					Path:     path,
+					Exact:    p.PathType != nil && *p.PathType == networkingv1.PathTypeExact,
+					Endpoint: builder.endpoint(ing, p.Backend),
+				})
+			}
+		}
+
+		// The default backend is added last so that explicit "/" paths take
+		// precedence over it.
+		if ing.Spec.DefaultBackend != nil {
+			if !claimCatchAll(ing) {
+				builder.Logger.Printf(
+					"Ignoring default backend of ingress '%s' for unmatched hosts, it conflicts with ingress '%s'",
+					qualifiedName(&ing.ObjectMeta),
+					qualifiedName(&catchAllIngress.ObjectMeta),
+				)
+			} else if !seen[""] {
+				hosts = append(hosts, "")
+			}
+
+			for _, host := range hosts {
+				add(ing, host, backend.PathEndpoint{
+					Path:     "/",
+					Endpoint: defaultEndpoint,
+				})
+			}
+		}
+	}
+
+	return routes, certificates
+}
+
+// hasIngressClass returns true if ing uses the builder's ingress class.
+func (builder *routeBuilder) hasIngressClass(ing *networkingv1.Ingress) bool {
+	if builder.IngressClass == "" {
+		return true
+	}
+
+	if ing.Spec.IngressClassName != nil {
+		return *ing.Spec.IngressClassName == builder.IngressClass
+	}
+
+	return ing.Annotations[ingressClassAnnotation] == builder.IngressClass
+}
+
+// endpoint returns the endpoint for an Ingress backend. If the service has
+// several ready endpoints, requests are distributed between them as
+// alternatives. It returns nil if the backend can not be routed to, or has no
+// ready endpoints.
+func (builder *routeBuilder) endpoint(
+	ing *networkingv1.Ingress,
+	b networkingv1.IngressBackend,
+) *backend.Endpoint {
+	if b.Service == nil {
+		builder.Logger.Printf(
+			"Can not route to ingress '%s', only service backends are supported",
+			qualifiedName(&ing.ObjectMeta),
+		)
+		return nil
+	}
+
+	svc, err := builder.Services.Services(ing.Namespace).Get(b.Service.Name)
+	if err != nil {
+		builder.Logger.Printf(
+			"Can not route to ingress '%s' via service '%s', %s",
+			qualifiedName(&ing.ObjectMeta),
+			b.Service.Name,
+			err,
+		)
+		return nil
+	}
+
+	port, ok := servicePort(svc, b.Service.Port)
+	if !ok {
+		builder.Logger.Printf(
+			"Can not route to ingress '%s' via service '%s', the service does not expose the requested port",
+			qualifiedName(&ing.ObjectMeta),
+			b.Service.Name,
+		)
+		return nil
+	}
+
+	addresses := builder.readyAddresses(svc, port)
+	if len(addresses) == 0 {
+		return nil
+	}
+
+	tlsMode, err := tlsMode(ing, port)
+	if err != nil {
+		builder.Logger.Printf(
+			"Can not route to ingress '%s' via service '%s', %s",
+			qualifiedName(&ing.ObjectMeta),
+			b.Service.Name,
+			err,
+		)
+		return nil
+	}
+
+	description := ing.Annotations[descriptionAnnotation]
+	if description == "" {
+		description = qualifiedName(&svc.ObjectMeta)
+	}
+
+	var eps []*backend.Endpoint
+	for _, addr := range addresses {
+		eps = append(eps, &backend.Endpoint{
+			Description: description,
+			Address:     addr,
+			TLSMode:     tlsMode,
+		})
+	}
+
+	if len(eps) == 1 {
+		return eps[0]
+	}
+
+	for _, ep := range eps {
+		ep.Weight = 1
+	}
+
+	return &backend.Endpoint{
+		Description:  description,
+		Alternatives: eps,
+	}
+}
+
+// readyAddresses returns the network addresses of the ready endpoints of svc
+// for the given service port, in sorted order.
+func (builder *routeBuilder) readyAddresses(
+	svc *corev1.Service,
+	port corev1.ServicePort,
+) []string {
+	slices, err := builder.Slices.EndpointSlices(svc.Namespace).List(
+		labels.SelectorFromSet(labels.Set{
+			discoveryv1.LabelServiceName: svc.Name,
+		}),
+	)
+	if err != nil {
+		return nil
+	}
+
+	seen := map[string]struct{}{}
+	var addresses []string
+
+	for _, slice := range slices {
+		p, ok := slicePort(slice, port)
+		if !ok {
+			continue
+		}
+
+		for _, ep := range slice.Endpoints {
+			// A nil condition indicates an unknown state, which is
+			// interpreted as ready. All addresses of an endpoint refer to the
+			// same pod, so only the first is used.
+			if ep.Conditions.Ready != nil && !*ep.Conditions.Ready {
+				continue
+			}
+
+			if len(ep.Addresses) == 0 {
+				continue
+			}
+
+			addr := net.JoinHostPort(ep.Addresses[0], strconv.Itoa(int(p)))
+			if _, ok := seen[addr]; !ok {
+				seen[addr] = struct{}{}
+				addresses = append(addresses, addr)
+			}
+		}
+	}
+
+	sort.Strings(addresses)
+
+	return addresses
+}
+
+// slicePort returns the port number that the endpoints in slice use for the
+// given service port, which may differ from the service's own port number.
+func slicePort(
+	slice *discoveryv1.EndpointSlice,
+	port corev1.ServicePort,
+) (int32, bool) {
+	for _, p := range slice.Ports {
+		if p.Port == nil {
+			continue
+		}
+
+		if p.Protocol != nil && *p.Protocol != corev1.ProtocolTCP {
+			continue
+		}
+
+		n := ""
+		if p.Name != nil {
+			n = *p.Name
+		}
+
+		if n == port.Name {
+			return *p.Port, true
+		}
+	}
+
+	return 0, false
+}
+
+// certificate loads the TLS certificate from the given secret. It returns nil
+// if the secret can not be loaded.
+func (builder *routeBuilder) certificate(namespace, secretName string) *tls.Certificate {
+	if secretName == "" {
+		return nil
+	}
+
+	secret, err := builder.Secrets.Secrets(namespace).Get(secretName)
+	if err == nil {
+		var c tls.Certificate
+		c, err = tls.X509KeyPair(
+			secret.Data[corev1.TLSCertKey],
+			secret.Data[corev1.TLSPrivateKeyKey],
+		)
+
+		if err == nil {
+			c.Leaf, err = x509.ParseCertificate(c.Certificate[0])
+			if err == nil {
+				return &c
+			}
+		}
+	}
+
+	builder.Logger.Printf(
+		"Can not load certificate from secret '%s/%s', %s",
+		namespace,
+		secretName,
+		err,
+	)
+
+	return nil
+}
+
+// qualifiedName returns the namespace-qualified name of a resource.
+func qualifiedName(meta *metav1.ObjectMeta) string {
+	return meta.Namespace + "/" + meta.Name
+}
+
+// servicePort returns the port of svc that is referenced by p.
+func servicePort(
+	svc *corev1.Service,
+	p networkingv1.ServiceBackendPort,
+) (corev1.ServicePort, bool) {
+	for _, port := range svc.Spec.Ports {
+		if p.Name != "" && port.Name == p.Name {
+			return port, true
+		} else if p.Name == "" && port.Port == p.Number {
+			return port, true
+		}
+	}
+
+	return corev1.ServicePort{}, false
+}
+
+// tlsMode returns the TLS mode to use for the given service port, which may be
+// overridden by an annotation on the ingress.
+func tlsMode(
+	ing *networkingv1.Ingress,
+	port corev1.ServicePort,
+) (backend.TLSMode, error) {
+	if value, ok := ing.Annotations[tlsAnnotation]; ok {
+		if mode, ok := backend.ParseTLSMode(value); ok {
+			return mode, nil
+		}
+
+		return backend.TLSDisabled, fmt.Errorf(
+			"invalid '%s' annotation (%s), expected 'enabled', 'disabled', 'insecure' or 'h2c'",
+			tlsAnnotation,
+			value,
+		)
+	}
+
+	protocol := port.Name
+	if port.AppProtocol != nil {
+		protocol = *port.AppProtocol
+	}
+
+	switch {
+	case strings.EqualFold(protocol, "https"):
+		return backend.TLSEnabled, nil
+	case strings.EqualFold(protocol, "h2c"):
+		return backend.TLSDisabledH2C, nil
+	case port.Port == 443 || port.Port == 8443:
+		return backend.TLSEnabled, nil
+	default:
+		return backend.TLSDisabled, nil
+	}
+}
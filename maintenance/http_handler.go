@@ -0,0 +1,145 @@
+package maintenance
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/icecave/honeycomb/backend"
+	"github.com/icecave/honeycomb/name"
+)
+
+const requestHost = "localhost"
+const requestPath = "/.honeycomb/maintenance"
+
+// HTTPHandler is a http.Handler/frontend.ConditionalHandler that places server
+// name patterns into or out of maintenance mode.
+//
+// Requests must include the token as a bearer token in the Authorization
+// header. If the token is empty, the handler does not handle any requests.
+//
+//	GET     lists the patterns that are in maintenance mode
+//	PUT     enables maintenance mode for the "pattern" parameter, with the
+//	        optional "message", "retry-after" and "allow" parameters
+//	DELETE  disables maintenance mode for the "pattern" parameter
+type HTTPHandler struct {
+	Registry *Registry
+	Token    string
+	Logger   *log.Logger
+}
+
+// CanHandle returns true if request can be served by this handler.
+func (handler *HTTPHandler) CanHandle(request *http.Request) bool {
+	if handler.Token == "" {
+		return false
+	}
+
+	serverName, _ := name.FromHTTP(request)
+	return serverName.Unicode == requestHost && request.URL.Path == requestPath
+}
+
+func (handler *HTTPHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	if !handler.authorized(request) {
+		writer.Header().Set("WWW-Authenticate", "Bearer")
+		writer.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(writer, "A valid bearer token is required.")
+		return
+	}
+
+	switch request.Method {
+	case http.MethodGet:
+		writer.WriteHeader(http.StatusOK)
+		for _, p := range handler.Registry.Patterns() {
+			io.WriteString(writer, p+"\n")
+		}
+	case http.MethodPut:
+		handler.enable(writer, request)
+	case http.MethodDelete:
+		handler.disable(writer, request)
+	default:
+		writer.Header().Set("Allow", "GET, PUT, DELETE")
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (handler *HTTPHandler) enable(writer http.ResponseWriter, request *http.Request) {
+	pattern := request.FormValue("pattern")
+	m := &backend.Maintenance{
+		Message: request.FormValue("message"),
+	}
+
+	err := func() (err error) {
+		if pattern == "" {
+			return fmt.Errorf("the 'pattern' parameter is required")
+		}
+
+		if v := request.FormValue("retry-after"); v != "" {
+			m.RetryAfter, err = time.ParseDuration(v)
+			if err != nil || m.RetryAfter < 0 {
+				return fmt.Errorf("invalid 'retry-after' parameter (%s), expected a duration, such as '15m'", v)
+			}
+		}
+
+		if v := request.FormValue("allow"); v != "" {
+			m.AllowedNetworks, err = backend.ParseNetworks(v)
+			if err != nil {
+				return fmt.Errorf("invalid 'allow' parameter (%s), %s", v, err)
+			}
+		}
+
+		return handler.Registry.Enable(pattern, m)
+	}()
+
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		io.WriteString(writer, err.Error())
+		return
+	}
+
+	handler.log("Enabled maintenance mode for '%s'", pattern)
+
+	writer.WriteHeader(http.StatusOK)
+	io.WriteString(writer, fmt.Sprintf("Maintenance mode enabled for '%s'.", pattern))
+}
+
+func (handler *HTTPHandler) disable(writer http.ResponseWriter, request *http.Request) {
+	pattern := request.FormValue("pattern")
+
+	if !handler.Registry.Disable(pattern) {
+		writer.WriteHeader(http.StatusNotFound)
+		io.WriteString(writer, fmt.Sprintf("Maintenance mode is not enabled for '%s'.", pattern))
+		return
+	}
+
+	handler.log("Disabled maintenance mode for '%s'", pattern)
+
+	writer.WriteHeader(http.StatusOK)
+	io.WriteString(writer, fmt.Sprintf("Maintenance mode disabled for '%s'.", pattern))
+}
+
+// authorized returns true if request includes the correct bearer token.
+func (handler *HTTPHandler) authorized(request *http.Request) bool {
+	const prefix = "Bearer "
+
+	auth := request.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, prefix) {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(
+		[]byte(strings.TrimPrefix(auth, prefix)),
+		[]byte(handler.Token),
+	) == 1
+}
+
+func (handler *HTTPHandler) log(format string, args ...interface{}) {
+	if handler.Logger != nil {
+		handler.Logger.Printf(format, args...)
+	}
+}
@@ -0,0 +1,104 @@
+package maintenance_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/icecave/honeycomb/maintenance"
+	"github.com/icecave/honeycomb/name"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HTTPHandler", func() {
+	var (
+		maintenanceURL = "https://localhost/.honeycomb/maintenance"
+		registry       *maintenance.Registry
+		subject        *maintenance.HTTPHandler
+	)
+
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		writer := httptest.NewRecorder()
+		request := httptest.NewRequest(method, target, nil)
+		request.Header.Set("Authorization", "Bearer <token>")
+		subject.ServeHTTP(writer, request)
+		return writer
+	}
+
+	BeforeEach(func() {
+		registry = &maintenance.Registry{}
+		subject = &maintenance.HTTPHandler{
+			Registry: registry,
+			Token:    "<token>",
+		}
+	})
+
+	DescribeTable(
+		"CanHandle",
+		func(target string, expected bool) {
+			request := httptest.NewRequest(http.MethodGet, target, nil)
+			Expect(subject.CanHandle(request)).To(Equal(expected))
+		},
+		Entry("maintenance URL", maintenanceURL, true),
+		Entry("incorrect host", "https://www.domain.tld/.honeycomb/maintenance", false),
+		Entry("incorrect path", "https://localhost/.honeycomb/elsewhere", false),
+	)
+
+	It("does not handle requests when there is no token", func() {
+		subject.Token = ""
+		request := httptest.NewRequest(http.MethodGet, maintenanceURL, nil)
+		Expect(subject.CanHandle(request)).To(BeFalse())
+	})
+
+	It("rejects requests without the correct token", func() {
+		writer := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPut, maintenanceURL+"?pattern=app.*", nil)
+		request.Header.Set("Authorization", "Bearer <incorrect>")
+		subject.ServeHTTP(writer, request)
+
+		Expect(writer.Code).To(Equal(http.StatusUnauthorized))
+		Expect(registry.Patterns()).To(BeEmpty())
+	})
+
+	It("enables maintenance mode", func() {
+		writer := serve(
+			http.MethodPut,
+			maintenanceURL+"?pattern=app.*&message=Back+soon.&retry-after=10m&allow=10.0.0.0/8",
+		)
+		Expect(writer.Code).To(Equal(http.StatusOK))
+
+		m := registry.Maintenance(name.Parse("app.example.com"))
+		Expect(m).NotTo(BeNil())
+		Expect(m.Message).To(Equal("Back soon."))
+		Expect(m.RetryAfter).To(Equal(10 * time.Minute))
+		Expect(m.Allows(net.ParseIP("10.0.0.1"))).To(BeTrue())
+	})
+
+	It("rejects invalid parameters", func() {
+		writer := serve(http.MethodPut, maintenanceURL+"?pattern=app.*&retry-after=soon")
+		Expect(writer.Code).To(Equal(http.StatusBadRequest))
+		Expect(registry.Patterns()).To(BeEmpty())
+	})
+
+	It("disables maintenance mode", func() {
+		serve(http.MethodPut, maintenanceURL+"?pattern=app.*")
+
+		writer := serve(http.MethodDelete, maintenanceURL+"?pattern=app.*")
+		Expect(writer.Code).To(Equal(http.StatusOK))
+		Expect(registry.Patterns()).To(BeEmpty())
+
+		writer = serve(http.MethodDelete, maintenanceURL+"?pattern=app.*")
+		Expect(writer.Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("lists the patterns in maintenance mode", func() {
+		serve(http.MethodPut, maintenanceURL+"?pattern=app.*")
+
+		writer := serve(http.MethodGet, maintenanceURL)
+		Expect(writer.Code).To(Equal(http.StatusOK))
+		Expect(writer.Body.String()).To(Equal("app.*\n"))
+	})
+})
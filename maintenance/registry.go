@@ -0,0 +1,93 @@
+package maintenance
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/icecave/honeycomb/backend"
+	"github.com/icecave/honeycomb/name"
+)
+
+// Registry holds server name patterns that have been placed into maintenance
+// mode at runtime. It is safe for concurrent use.
+type Registry struct {
+	m       sync.RWMutex
+	entries map[string]entry
+}
+
+type entry struct {
+	Matcher     *name.Matcher
+	Maintenance *backend.Maintenance
+}
+
+// Enable places server names that match pattern into maintenance mode,
+// replacing any existing maintenance information for the same pattern.
+func (registry *Registry) Enable(pattern string, m *backend.Maintenance) error {
+	matcher, err := name.NewMatcher(pattern)
+	if err != nil {
+		return err
+	}
+
+	registry.m.Lock()
+	defer registry.m.Unlock()
+
+	if registry.entries == nil {
+		registry.entries = map[string]entry{}
+	}
+
+	registry.entries[pattern] = entry{matcher, m}
+
+	return nil
+}
+
+// Disable takes server names that match pattern out of maintenance mode. It
+// returns false if the pattern was not in maintenance mode.
+func (registry *Registry) Disable(pattern string) bool {
+	registry.m.Lock()
+	defer registry.m.Unlock()
+
+	if _, ok := registry.entries[pattern]; !ok {
+		return false
+	}
+
+	delete(registry.entries, pattern)
+
+	return true
+}
+
+// Patterns returns the patterns that are in maintenance mode, in order.
+func (registry *Registry) Patterns() []string {
+	registry.m.RLock()
+	defer registry.m.RUnlock()
+
+	patterns := make([]string, 0, len(registry.entries))
+	for p := range registry.entries {
+		patterns = append(patterns, p)
+	}
+
+	sort.Strings(patterns)
+
+	return patterns
+}
+
+// Maintenance returns the maintenance information for the given server name,
+// using the pattern with the strongest match, or nil if the server name is
+// not in maintenance mode.
+func (registry *Registry) Maintenance(serverName name.ServerName) *backend.Maintenance {
+	registry.m.RLock()
+	defer registry.m.RUnlock()
+
+	var (
+		result *backend.Maintenance
+		score  int
+	)
+
+	for _, e := range registry.entries {
+		if s := e.Matcher.Match(serverName); s > score {
+			result = e.Maintenance
+			score = s
+		}
+	}
+
+	return result
+}
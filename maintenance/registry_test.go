@@ -0,0 +1,51 @@
+package maintenance_test
+
+import (
+	"github.com/icecave/honeycomb/backend"
+	"github.com/icecave/honeycomb/maintenance"
+	"github.com/icecave/honeycomb/name"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Registry", func() {
+	var subject *maintenance.Registry
+
+	BeforeEach(func() {
+		subject = &maintenance.Registry{}
+	})
+
+	It("returns nil for server names that are not in maintenance mode", func() {
+		Expect(subject.Maintenance(name.Parse("app.example.com"))).To(BeNil())
+	})
+
+	It("returns the maintenance information for the strongest match", func() {
+		wildcard := &backend.Maintenance{Message: "wildcard"}
+		exact := &backend.Maintenance{Message: "exact"}
+
+		Expect(subject.Enable("*.example.com", wildcard)).To(Succeed())
+		Expect(subject.Enable("app.example.com", exact)).To(Succeed())
+
+		Expect(subject.Maintenance(name.Parse("app.example.com"))).To(BeIdenticalTo(exact))
+		Expect(subject.Maintenance(name.Parse("www.example.com"))).To(BeIdenticalTo(wildcard))
+	})
+
+	It("stops returning maintenance information once disabled", func() {
+		subject.Enable("app.*", &backend.Maintenance{})
+
+		Expect(subject.Disable("app.*")).To(BeTrue())
+		Expect(subject.Disable("app.*")).To(BeFalse())
+		Expect(subject.Maintenance(name.Parse("app.example.com"))).To(BeNil())
+	})
+
+	It("lists the patterns in order", func() {
+		subject.Enable("b.*", &backend.Maintenance{})
+		subject.Enable("a.*", &backend.Maintenance{})
+
+		Expect(subject.Patterns()).To(Equal([]string{"a.*", "b.*"}))
+	})
+
+	It("returns an error if the pattern is invalid", func() {
+		Expect(subject.Enable("a..b", &backend.Maintenance{})).ToNot(Succeed())
+	})
+})
@@ -0,0 +1,21 @@
+package proxy
+
+import (
+	"context"
+
+	"github.com/icecave/honeycomb/backend"
+)
+
+// Activator starts upstream servers that have been stopped while idle.
+type Activator interface {
+	// Begin records the start of a request to endpoint. It returns false if
+	// the endpoint must be started before it can serve the request.
+	Begin(endpoint *backend.Endpoint) bool
+
+	// End records the end of a request to endpoint.
+	End(endpoint *backend.Endpoint)
+
+	// Start starts the endpoint and blocks until it is ready to serve
+	// requests, or ctx is canceled.
+	Start(ctx context.Context, endpoint *backend.Endpoint) error
+}
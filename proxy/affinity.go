@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/icecave/honeycomb/backend"
+)
+
+// choose returns the endpoint to use for request from the alternatives of
+// endpoint, taking the endpoint's affinity settings into account.
+//
+// If the client is pinned to an alternative that no longer exists, or is not
+// eligible to receive requests, another alternative is chosen.
+//
+// It also returns the affinity cookie that pins the client to the chosen
+// alternative, if the client is not already pinned to it.
+func (handler *Handler) choose(
+	request *http.Request,
+	endpoint *backend.Endpoint,
+) (*backend.Endpoint, *http.Cookie) {
+	affinity := endpoint.Affinity
+	if affinity == nil || len(endpoint.Alternatives) == 0 {
+		return endpoint.Choose(), nil
+	}
+
+	switch affinity.Mode {
+	case backend.AffinityClientIP:
+		host, _, err := net.SplitHostPort(request.RemoteAddr)
+		if err != nil {
+			host = request.RemoteAddr
+		}
+
+		return endpoint.PickFor(host).Choose(), nil
+
+	case backend.AffinityCookie:
+		name := affinity.CookieName
+		if name == "" {
+			name = backend.DefaultAffinityCookieName
+		}
+
+		if cookie, err := request.Cookie(name); err == nil {
+			if id, ok := handler.verifyAffinity(name, cookie.Value); ok {
+				if alt := endpoint.Alternative(id); alt != nil {
+					return alt.Choose(), nil
+				}
+			}
+		}
+
+		alt := endpoint.Pick()
+		if alt == nil {
+			return nil, nil
+		}
+
+		cookie := &http.Cookie{
+			Name:     name,
+			Path:     "/",
+			Secure:   request.TLS != nil,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		}
+
+		var expires time.Time
+		if affinity.CookieTTL > 0 {
+			expires = time.Now().Add(affinity.CookieTTL)
+			cookie.MaxAge = int(affinity.CookieTTL / time.Second)
+		}
+
+		cookie.Value = handler.signAffinity(name, alt.ID(), expires)
+
+		return alt.Choose(), cookie
+
+	default:
+		return endpoint.Choose(), nil
+	}
+}
+
+// signAffinity returns the value of an affinity cookie that pins the client to
+// the alternative with the given ID until the given expiry time. A zero expiry
+// time indicates that the cookie does not expire.
+func (handler *Handler) signAffinity(name, id string, expires time.Time) string {
+	var exp int64
+	if !expires.IsZero() {
+		exp = expires.Unix()
+	}
+
+	payload := id + "." + strconv.FormatInt(exp, 10)
+
+	return payload + "." + handler.affinitySignature(name, payload)
+}
+
+// verifyAffinity returns the alternative ID from an affinity cookie value. It
+// returns false if the signature is invalid or the cookie has expired.
+func (handler *Handler) verifyAffinity(name, value string) (string, bool) {
+	i := strings.LastIndex(value, ".")
+	if i == -1 {
+		return "", false
+	}
+
+	payload, sig := value[:i], value[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(handler.affinitySignature(name, payload))) {
+		return "", false
+	}
+
+	i = strings.LastIndex(payload, ".")
+	if i == -1 {
+		return "", false
+	}
+
+	id := payload[:i]
+	exp, err := strconv.ParseInt(payload[i+1:], 10, 64)
+	if err != nil {
+		return "", false
+	}
+
+	if exp != 0 && time.Now().Unix() > exp {
+		return "", false
+	}
+
+	return id, true
+}
+
+// affinitySignature returns the signature of an affinity cookie payload.
+func (handler *Handler) affinitySignature(name, payload string) string {
+	handler.affinityOnce.Do(func() {
+		handler.affinityKey = handler.AffinityKey
+
+		if len(handler.affinityKey) == 0 {
+			handler.affinityKey = make([]byte, 32)
+			if _, err := rand.Read(handler.affinityKey); err != nil {
+				panic(err)
+			}
+		}
+	})
+
+	mac := hmac.New(sha256.New, handler.affinityKey)
+	mac.Write([]byte(name))
+	mac.Write([]byte{0})
+	mac.Write([]byte(payload))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
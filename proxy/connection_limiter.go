@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/icecave/honeycomb/backend"
+	"github.com/icecave/honeycomb/statuspage"
+)
+
+var (
+	errClientConnectionLimit = errors.New("too many concurrent connections from this client")
+	errRouteConnectionLimit  = errors.New("too many concurrent connections to this backend")
+)
+
+// ConnectionLimiter limits the number of concurrent websocket connections, and
+// other upgraded connections, to each route and from each client.
+//
+// It may be shared between several proxies so that the limits apply across
+// all of them.
+type ConnectionLimiter struct {
+	m       sync.Mutex
+	routes  map[routeKey]int
+	clients map[connectionKey]int
+}
+
+// routeKey identifies a route. Routes with several alternatives are
+// identified by their description, so that the connections to all of the
+// alternatives are counted together, other routes by their address.
+type routeKey struct {
+	Address     string
+	Description string
+}
+
+// newRouteKey returns the key that identifies the route to ep.
+func newRouteKey(ep *backend.Endpoint) routeKey {
+	if len(ep.Alternatives) == 0 {
+		return routeKey{Address: ep.Address}
+	}
+
+	return routeKey{Description: ep.Description}
+}
+
+// connectionKey identifies the connections from a single client to a single
+// route.
+type connectionKey struct {
+	Route  routeKey
+	Client string
+}
+
+// Acquire reserves a connection from the client that sent request to the
+// route to ep. ep is the endpoint that was located for the request, before
+// one of its alternatives was chosen. It returns an error if doing so would
+// exceed limits, otherwise it returns a function that must be called once the
+// connection is closed.
+//
+// Exceeding the per-client limit results in a "429 Too Many Requests" error,
+// exceeding the route's limit results in a "503 Service Unavailable" error.
+func (l *ConnectionLimiter) Acquire(
+	request *http.Request,
+	ep *backend.Endpoint,
+	limits backend.WebSocketLimits,
+) (func(), error) {
+	client, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		client = request.RemoteAddr
+	}
+
+	key := connectionKey{newRouteKey(ep), client}
+
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if limits.MaxConnectionsPerClient > 0 && l.clients[key] >= limits.MaxConnectionsPerClient {
+		return nil, statuspage.Error{
+			Inner:      errClientConnectionLimit,
+			StatusCode: http.StatusTooManyRequests,
+		}
+	}
+
+	if limits.MaxConnections > 0 && l.routes[key.Route] >= limits.MaxConnections {
+		return nil, statuspage.Error{
+			Inner:      errRouteConnectionLimit,
+			StatusCode: http.StatusServiceUnavailable,
+		}
+	}
+
+	if l.routes == nil {
+		l.routes = map[routeKey]int{}
+		l.clients = map[connectionKey]int{}
+	}
+
+	l.routes[key.Route]++
+	l.clients[key]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { l.release(key) })
+	}, nil
+}
+
+// release frees a connection that was reserved by Acquire().
+func (l *ConnectionLimiter) release(key connectionKey) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if l.routes[key.Route]--; l.routes[key.Route] <= 0 {
+		delete(l.routes, key.Route)
+	}
+
+	if l.clients[key]--; l.clients[key] <= 0 {
+		delete(l.clients, key)
+	}
+}
+
+// Count returns the number of connections to the route to ep that are
+// currently open.
+func (l *ConnectionLimiter) Count(ep *backend.Endpoint) int {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	return l.routes[newRouteKey(ep)]
+}
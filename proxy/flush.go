@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// flushInterval returns the interval at which the body of response is
+// flushed to the client. Event streams are always flushed immediately, other
+// responses of unknown length are flushed at the configured interval, and
+// responses of a known length are not flushed at all.
+func flushInterval(response *http.Response, configured time.Duration) time.Duration {
+	mediaType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if mediaType == "text/event-stream" {
+		return -1
+	}
+
+	if response.ContentLength == -1 {
+		return configured
+	}
+
+	return 0
+}
+
+// flushWriter is an io.Writer that flushes the data written to a client,
+// either after each write, or at a fixed interval.
+type flushWriter struct {
+	writer   io.Writer
+	flusher  http.Flusher
+	interval time.Duration
+
+	m       sync.Mutex
+	timer   *time.Timer
+	pending bool
+}
+
+// newFlushWriter returns a writer that flushes writes to writer at the given
+// interval. If interval is negative, writes are flushed immediately. If it is
+// zero, or writer can not be flushed, writer is returned unchanged.
+func newFlushWriter(writer http.ResponseWriter, interval time.Duration) io.Writer {
+	flusher, ok := writer.(http.Flusher)
+	if !ok || interval == 0 {
+		return writer
+	}
+
+	return &flushWriter{
+		writer:   writer,
+		flusher:  flusher,
+		interval: interval,
+	}
+}
+
+func (w *flushWriter) Write(p []byte) (int, error) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	n, err := w.writer.Write(p)
+	if err != nil {
+		return n, err
+	}
+
+	if w.interval < 0 {
+		w.flusher.Flush()
+	} else if !w.pending {
+		w.pending = true
+		w.timer = time.AfterFunc(w.interval, w.flush)
+	}
+
+	return n, nil
+}
+
+// flush flushes any pending writes.
+func (w *flushWriter) flush() {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if w.pending {
+		w.pending = false
+		w.flusher.Flush()
+	}
+}
+
+// Stop stops the flush timer and flushes any pending writes.
+func (w *flushWriter) Stop() {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+
+	if w.pending {
+		w.pending = false
+		w.flusher.Flush()
+	}
+}
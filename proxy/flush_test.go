@@ -0,0 +1,135 @@
+package proxy_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/icecave/honeycomb/backend"
+	"github.com/icecave/honeycomb/proxy"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// flushRecorder is a response recorder that records the body each time it is
+// flushed.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+
+	m       sync.Mutex
+	flushes []string
+}
+
+func (r *flushRecorder) Write(p []byte) (int, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return r.ResponseRecorder.Write(p)
+}
+
+func (r *flushRecorder) Flush() {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.flushes = append(r.flushes, r.ResponseRecorder.Body.String())
+}
+
+func (r *flushRecorder) Flushes() []string {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return append([]string(nil), r.flushes...)
+}
+
+var _ = Describe("HTTPProxy streaming", func() {
+	var (
+		contentType string
+		release     chan struct{}
+		server      *httptest.Server
+		endpoint    *backend.Endpoint
+		subject     *proxy.HTTPProxy
+		writer      *flushRecorder
+		done        chan struct{}
+	)
+
+	forward := func() {
+		request := httptest.NewRequest("GET", server.URL+"/", nil)
+		request.RequestURI = ""
+
+		logContext := &proxy.LogContext{Request: request, Endpoint: endpoint}
+
+		go func() {
+			defer GinkgoRecover()
+			defer close(done)
+			subject.Forward(writer, request, request, logContext)
+		}()
+	}
+
+	BeforeEach(func() {
+		contentType = "text/plain"
+		release = make(chan struct{})
+		done = make(chan struct{})
+		endpoint = &backend.Endpoint{}
+		writer = &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+		subject = &proxy.HTTPProxy{
+			Transport: &http.Transport{},
+		}
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			w.Write([]byte("data: 1\n\n"))
+			w.(http.Flusher).Flush()
+			<-release
+			w.Write([]byte("data: 2\n\n"))
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("flushes event streams immediately", func() {
+		contentType = "text/event-stream; charset=utf-8"
+
+		forward()
+
+		Eventually(writer.Flushes).Should(ContainElement("data: 1\n\n"))
+
+		close(release)
+		<-done
+		Expect(writer.Flushes()).To(ContainElement("data: 1\n\ndata: 2\n\n"))
+	})
+
+	It("flushes responses of unknown length at the configured interval", func() {
+		subject.FlushInterval = 10 * time.Millisecond
+
+		forward()
+
+		Eventually(writer.Flushes).Should(ContainElement("data: 1\n\n"))
+
+		close(release)
+		<-done
+	})
+
+	It("prefers the endpoint's flush interval", func() {
+		endpoint.FlushInterval = backend.FlushImmediately
+
+		forward()
+
+		Eventually(writer.Flushes).Should(ContainElement("data: 1\n\n"))
+
+		close(release)
+		<-done
+	})
+
+	It("does not flush responses of unknown length when flushing is disabled", func() {
+		forward()
+
+		Consistently(writer.Flushes, 50*time.Millisecond).Should(BeEmpty())
+
+		close(release)
+		<-done
+		Expect(writer.Body.String()).To(Equal("data: 1\n\ndata: 2\n\n"))
+	})
+})
@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/icecave/honeycomb/statuspage"
+)
+
+// grpcTimeout returns the deadline requested by a gRPC client, as per the
+// "grpc-timeout" header.
+func grpcTimeout(request *http.Request) (time.Duration, bool) {
+	if !statuspage.IsGRPC(request) {
+		return 0, false
+	}
+
+	value := request.Header.Get("Grpc-Timeout")
+	if len(value) < 2 || len(value) > 9 {
+		return 0, false
+	}
+
+	n, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+
+	var unit time.Duration
+	switch value[len(value)-1] {
+	case 'H':
+		unit = time.Hour
+	case 'M':
+		unit = time.Minute
+	case 'S':
+		unit = time.Second
+	case 'm':
+		unit = time.Millisecond
+	case 'u':
+		unit = time.Microsecond
+	case 'n':
+		unit = time.Nanosecond
+	default:
+		return 0, false
+	}
+
+	return time.Duration(n) * unit, true
+}
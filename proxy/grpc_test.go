@@ -0,0 +1,106 @@
+package proxy_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/icecave/honeycomb/backend"
+	"github.com/icecave/honeycomb/proxy"
+	"github.com/icecave/honeycomb/static"
+	"github.com/icecave/honeycomb/statuspage"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("gRPC", func() {
+	var (
+		server  *httptest.Server
+		release chan struct{}
+		subject *proxy.HTTPProxy
+	)
+
+	forward := func(headers http.Header) (*httptest.ResponseRecorder, error) {
+		request := httptest.NewRequest("POST", server.URL+"/pkg.Service/Method", nil)
+		request.RequestURI = ""
+		request.Header = headers
+
+		logContext := &proxy.LogContext{Request: request}
+		writer := httptest.NewRecorder()
+		err := subject.Forward(writer, request, request, logContext)
+
+		return writer, err
+	}
+
+	BeforeEach(func() {
+		release = make(chan struct{})
+		subject = &proxy.HTTPProxy{
+			Transport: &http.Transport{},
+		}
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Grpc-Timeout") != "" {
+				<-release
+			}
+
+			w.Header().Set("Content-Type", "application/grpc")
+			w.Header().Set("Trailer", "Grpc-Status")
+			w.Write([]byte("<message>"))
+			w.Header().Set("Grpc-Status", "0")
+			w.Header().Set(http.TrailerPrefix+"Grpc-Message", "OK")
+		}))
+	})
+
+	AfterEach(func() {
+		close(release)
+		server.Close()
+	})
+
+	It("forwards the response trailers", func() {
+		writer, err := forward(http.Header{
+			"Content-Type": {"application/grpc"},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		response := writer.Result()
+		Expect(response.Header.Get("Trailer")).To(Equal("Grpc-Status"))
+		Expect(writer.Body.String()).To(Equal("<message>"))
+		Expect(response.Trailer).To(Equal(http.Header{
+			"Grpc-Status":  {"0"},
+			"Grpc-Message": {"OK"},
+		}))
+	})
+
+	It("enforces the deadline requested by the client", func() {
+		_, err := forward(http.Header{
+			"Content-Type": {"application/grpc+proto"},
+			"Grpc-Timeout": {"20m"},
+		})
+		Expect(err).To(Equal(statuspage.Error{
+			Inner:      proxy.TimeoutError{Phase: "total", Duration: 20 * time.Millisecond},
+			StatusCode: http.StatusGatewayTimeout,
+		}))
+	})
+
+	It("responds to errors with a gRPC status", func() {
+		handler := &proxy.Handler{
+			Locator: static.Locator{}.With("app.*", &backend.Endpoint{
+				Address: "app:80",
+				Maintenance: &backend.Maintenance{
+					Message: "Back 100% soon.",
+				},
+			}),
+		}
+
+		request := httptest.NewRequest("POST", "https://app.example.com/pkg.Service/Method", nil)
+		request.Header.Set("Content-Type", "application/grpc")
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, request)
+
+		Expect(writer.Code).To(Equal(http.StatusOK))
+		Expect(writer.Header().Get("Content-Type")).To(Equal("application/grpc"))
+		Expect(writer.Header().Get("Grpc-Status")).To(Equal("14"))
+		Expect(writer.Header().Get("Grpc-Message")).To(Equal("Back 100%25 soon."))
+		Expect(writer.Body.Len()).To(BeZero())
+	})
+})
@@ -0,0 +1,219 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// grpcWebTrailerFlag is the flag byte that identifies a gRPC-Web frame as
+// containing the trailers.
+const grpcWebTrailerFlag = 0x80
+
+// grpcWebAllowedHeaders is the list of request headers that are allowed in
+// gRPC-Web requests if the CORS preflight request does not specify any.
+const grpcWebAllowedHeaders = "content-type, x-grpc-web, x-user-agent, grpc-timeout"
+
+// grpcWebExposedHeaders is the list of response headers that browsers expose
+// to gRPC-Web clients.
+const grpcWebExposedHeaders = "grpc-status, grpc-message"
+
+// isGRPCWebPreflight returns true if request is a CORS preflight request for a
+// gRPC-Web request.
+func isGRPCWebPreflight(request *http.Request) bool {
+	return request.Method == http.MethodOptions &&
+		request.Header.Get("Origin") != "" &&
+		request.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// writeGRPCWebPreflight responds to a CORS preflight request for a gRPC-Web
+// request.
+func writeGRPCWebPreflight(writer http.ResponseWriter, request *http.Request) {
+	allowedHeaders := request.Header.Get("Access-Control-Request-Headers")
+	if allowedHeaders == "" {
+		allowedHeaders = grpcWebAllowedHeaders
+	}
+
+	headers := writer.Header()
+	headers.Set("Access-Control-Allow-Origin", request.Header.Get("Origin"))
+	headers.Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	headers.Set("Access-Control-Allow-Headers", allowedHeaders)
+	headers.Set("Access-Control-Max-Age", "86400")
+	headers.Add("Vary", "Origin")
+
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// writeGRPCWebCORSHeaders adds the CORS headers that allow browsers to read
+// the response to a gRPC-Web request.
+func writeGRPCWebCORSHeaders(writer http.ResponseWriter, request *http.Request) {
+	origin := request.Header.Get("Origin")
+	if origin == "" {
+		return
+	}
+
+	headers := writer.Header()
+	headers.Set("Access-Control-Allow-Origin", origin)
+	headers.Set("Access-Control-Expose-Headers", grpcWebExposedHeaders)
+	headers.Add("Vary", "Origin")
+}
+
+// isGRPCWebText returns true if request is a gRPC-Web request in the base64
+// text format.
+func isGRPCWebText(request *http.Request) bool {
+	return strings.HasPrefix(
+		request.Header.Get("Content-Type"),
+		"application/grpc-web-text",
+	)
+}
+
+// prepareGRPCWebRequest modifies a gRPC-Web upstream request so that it is a
+// native gRPC request.
+func prepareGRPCWebRequest(upstreamRequest *http.Request) {
+	contentType := upstreamRequest.Header.Get("Content-Type")
+
+	if isGRPCWebText(upstreamRequest) {
+		contentType = strings.TrimPrefix(contentType, "application/grpc-web-text")
+		upstreamRequest.ContentLength = -1
+		upstreamRequest.Header.Del("Content-Length")
+
+		if body := upstreamRequest.Body; body != nil && body != http.NoBody {
+			upstreamRequest.Body = struct {
+				io.Reader
+				io.Closer
+			}{
+				base64.NewDecoder(base64.StdEncoding, body),
+				body,
+			}
+		}
+	} else {
+		contentType = strings.TrimPrefix(contentType, "application/grpc-web")
+	}
+
+	upstreamRequest.Header.Set("Content-Type", "application/grpc"+contentType)
+	upstreamRequest.Header.Set("Te", "trailers")
+	upstreamRequest.Header.Del("X-Grpc-Web")
+}
+
+// grpcWebResponseWriter is an http.ResponseWriter that translates a native
+// gRPC response into a gRPC-Web response.
+type grpcWebResponseWriter struct {
+	http.ResponseWriter
+
+	// contentType is the media type of the gRPC-Web request, without any
+	// suffix, such as "application/grpc-web-text".
+	contentType string
+	isText      bool
+	statusCode  int
+}
+
+// newGRPCWebResponseWriter returns a writer that writes a gRPC-Web response
+// to writer, in response to request.
+func newGRPCWebResponseWriter(
+	writer http.ResponseWriter,
+	request *http.Request,
+) *grpcWebResponseWriter {
+	w := &grpcWebResponseWriter{
+		ResponseWriter: writer,
+		contentType:    "application/grpc-web",
+	}
+
+	if isGRPCWebText(request) {
+		w.contentType = "application/grpc-web-text"
+		w.isText = true
+	}
+
+	return w
+}
+
+func (w *grpcWebResponseWriter) WriteHeader(statusCode int) {
+	w.statusCode = statusCode
+
+	headers := w.Header()
+	headers.Del("Trailer")
+	headers.Del("Content-Length")
+
+	if contentType := headers.Get("Content-Type"); strings.HasPrefix(contentType, "application/grpc") {
+		headers.Set(
+			"Content-Type",
+			w.contentType+strings.TrimPrefix(contentType, "application/grpc"),
+		)
+	}
+
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *grpcWebResponseWriter) Write(p []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if !w.isText {
+		return w.ResponseWriter.Write(p)
+	}
+
+	// Each write is encoded (and padded) separately, so that streamed
+	// messages can be decoded as soon as they arrive.
+	if _, err := io.WriteString(
+		w.ResponseWriter,
+		base64.StdEncoding.EncodeToString(p),
+	); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (w *grpcWebResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Finish encodes the trailers, which writeResponseTrailers() adds to the
+// headers, into the response body.
+func (w *grpcWebResponseWriter) Finish() error {
+	if w.statusCode == 0 {
+		return nil
+	}
+
+	headers := w.Header()
+
+	var names []string
+	trailers := http.Header{}
+	for name, values := range headers {
+		if strings.HasPrefix(name, http.TrailerPrefix) {
+			delete(headers, name)
+			name = strings.TrimPrefix(name, http.TrailerPrefix)
+			trailers[name] = values
+			names = append(names, name)
+		}
+	}
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	sort.Strings(names)
+
+	var frame bytes.Buffer
+	frame.Write([]byte{grpcWebTrailerFlag, 0, 0, 0, 0})
+
+	for _, name := range names {
+		for _, value := range trailers[name] {
+			frame.WriteString(strings.ToLower(name))
+			frame.WriteString(": ")
+			frame.WriteString(value)
+			frame.WriteString("\r\n")
+		}
+	}
+
+	binary.BigEndian.PutUint32(frame.Bytes()[1:5], uint32(frame.Len()-5))
+
+	_, err := w.Write(frame.Bytes())
+	return err
+}
@@ -0,0 +1,154 @@
+package proxy_test
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/icecave/honeycomb/backend"
+	"github.com/icecave/honeycomb/proxy"
+	"github.com/icecave/honeycomb/static"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("gRPC-Web", func() {
+	var (
+		server   *httptest.Server
+		origin   string
+		upstream *http.Request
+		body     string
+		endpoint *backend.Endpoint
+		subject  *proxy.Handler
+	)
+
+	// trailerFrame is the gRPC-Web frame that contains the trailers sent by
+	// the server.
+	trailerFrame := "\x80\x00\x00\x00\x22grpc-message: OK\r\ngrpc-status: 0\r\n"
+
+	serve := func(request *http.Request) *httptest.ResponseRecorder {
+		writer := httptest.NewRecorder()
+		subject.ServeHTTP(writer, request)
+		return writer
+	}
+
+	BeforeEach(func() {
+		upstream = nil
+		origin = ""
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			upstream = r
+			b, _ := ioutil.ReadAll(r.Body)
+			body = string(b)
+
+			if origin != "" {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Vary", "Accept-Encoding")
+			}
+
+			w.Header().Set("Content-Type", "application/grpc+proto")
+			w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+			w.Write([]byte("<message>"))
+			w.Header().Set("Grpc-Status", "0")
+			w.Header().Set("Grpc-Message", "OK")
+		}))
+
+		u, _ := url.Parse(server.URL)
+		endpoint = &backend.Endpoint{
+			Address: u.Host,
+			GRPCWeb: true,
+		}
+
+		p := &proxy.HTTPProxy{Transport: &http.Transport{}}
+		subject = &proxy.Handler{
+			Locator:           static.Locator{}.With("app.*", endpoint),
+			SecureHTTPProxy:   p,
+			InsecureHTTPProxy: p,
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("translates binary requests and responses", func() {
+		request := httptest.NewRequest("POST", "https://app.example.com/pkg.Service/Method", strings.NewReader("<request>"))
+		request.Header.Set("Content-Type", "application/grpc-web+proto")
+		request.Header.Set("Origin", "https://www.example.com")
+
+		writer := serve(request)
+
+		Expect(upstream.Header.Get("Content-Type")).To(Equal("application/grpc+proto"))
+		Expect(upstream.Header.Get("Te")).To(Equal("trailers"))
+		Expect(body).To(Equal("<request>"))
+
+		Expect(writer.Code).To(Equal(http.StatusOK))
+		Expect(writer.Header().Get("Content-Type")).To(Equal("application/grpc-web+proto"))
+		Expect(writer.Header().Get("Access-Control-Allow-Origin")).To(Equal("https://www.example.com"))
+		Expect(writer.Header().Get("Access-Control-Expose-Headers")).To(Equal("grpc-status, grpc-message"))
+		Expect(writer.Body.String()).To(Equal("<message>" + trailerFrame))
+		Expect(writer.Result().Trailer).To(BeEmpty())
+	})
+
+	It("uses the CORS headers sent by the upstream server", func() {
+		origin = "*"
+
+		request := httptest.NewRequest("POST", "https://app.example.com/pkg.Service/Method", strings.NewReader("<request>"))
+		request.Header.Set("Content-Type", "application/grpc-web+proto")
+		request.Header.Set("Origin", "https://www.example.com")
+
+		writer := serve(request)
+
+		Expect(writer.Code).To(Equal(http.StatusOK))
+		Expect(writer.Header().Values("Access-Control-Allow-Origin")).To(Equal([]string{"*"}))
+		Expect(writer.Header().Values("Vary")).To(ConsistOf("Origin", "Accept-Encoding"))
+	})
+
+	It("translates base64 text requests and responses", func() {
+		request := httptest.NewRequest(
+			"POST",
+			"https://app.example.com/pkg.Service/Method",
+			strings.NewReader(base64.StdEncoding.EncodeToString([]byte("<request>"))),
+		)
+		request.Header.Set("Content-Type", "application/grpc-web-text")
+
+		writer := serve(request)
+
+		Expect(upstream.Header.Get("Content-Type")).To(Equal("application/grpc"))
+		Expect(body).To(Equal("<request>"))
+
+		Expect(writer.Header().Get("Content-Type")).To(Equal("application/grpc-web-text+proto"))
+		Expect(writer.Body.String()).To(Equal(
+			base64.StdEncoding.EncodeToString([]byte("<message>")) +
+				base64.StdEncoding.EncodeToString([]byte(trailerFrame)),
+		))
+	})
+
+	It("responds to CORS preflight requests", func() {
+		request := httptest.NewRequest("OPTIONS", "https://app.example.com/pkg.Service/Method", nil)
+		request.Header.Set("Origin", "https://www.example.com")
+		request.Header.Set("Access-Control-Request-Method", "POST")
+		request.Header.Set("Access-Control-Request-Headers", "content-type, x-grpc-web")
+
+		writer := serve(request)
+
+		Expect(upstream).To(BeNil())
+		Expect(writer.Code).To(Equal(http.StatusNoContent))
+		Expect(writer.Header().Get("Access-Control-Allow-Origin")).To(Equal("https://www.example.com"))
+		Expect(writer.Header().Get("Access-Control-Allow-Methods")).To(Equal("POST, OPTIONS"))
+		Expect(writer.Header().Get("Access-Control-Allow-Headers")).To(Equal("content-type, x-grpc-web"))
+	})
+
+	It("does not translate requests when gRPC-Web is disabled", func() {
+		endpoint.GRPCWeb = false
+
+		request := httptest.NewRequest("POST", "https://app.example.com/pkg.Service/Method", strings.NewReader("<request>"))
+		request.Header.Set("Content-Type", "application/grpc-web")
+
+		serve(request)
+
+		Expect(upstream.Header.Get("Content-Type")).To(Equal("application/grpc-web"))
+	})
+})
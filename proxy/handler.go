@@ -1,17 +1,38 @@
 package proxy
 
 import (
+	"context"
 	"errors"
 	"log"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/icecave/honeycomb/backend"
 	"github.com/icecave/honeycomb/name"
+	"github.com/icecave/honeycomb/rule"
 	"github.com/icecave/honeycomb/statuspage"
 )
 
+// DefaultStartTimeout is the default maximum amount of time that a request is
+// held while an idle upstream server starts.
+const DefaultStartTimeout = 30 * time.Second
+
+// DefaultStartingMessage is the default message shown to browsers while an idle
+// upstream server starts.
+const DefaultStartingMessage = "The service is starting, this page will refresh automatically."
+
+// DefaultMaintenanceMessage is the default message shown to clients when an
+// upstream server is offline for maintenance.
+const DefaultMaintenanceMessage = "The service is offline for maintenance, please try again later."
+
+// startingRefreshInterval is the number of seconds after which a browser
+// reloads the page while an idle upstream server starts.
+const startingRefreshInterval = 5
+
 // Handler is an http.Handler that proxies requests to an upstream server.
 type Handler struct {
 	Locator                backend.Locator
@@ -22,14 +43,65 @@ type Handler struct {
 	InsecureWebSocketProxy Proxy
 	StatusPageWriter       statuspage.Writer
 	Logger                 *log.Logger
+
+	// Activator, if non-nil, is used to start upstream servers that have been
+	// stopped while idle.
+	Activator Activator
+
+	// StartTimeout is the maximum amount of time that a non-browser request
+	// is held while an idle upstream server starts. If it is zero,
+	// DefaultStartTimeout is used.
+	StartTimeout time.Duration
+
+	// StartingMessage is shown to browsers while an idle upstream server
+	// starts. If it is empty, DefaultStartingMessage is used.
+	StartingMessage string
+
+	// Rules, if non-nil, is consulted before Locator. If a rule matches the
+	// request, it takes precedence over the endpoint found by Locator.
+	Rules rule.Locator
+
+	// OutlierDetector, if non-nil, is used to stop sending requests to
+	// endpoints that fail repeatedly.
+	OutlierDetector *OutlierDetector
+
+	// AffinityKey is the key used to sign affinity cookies. If it is empty, a
+	// random key is generated, in which case clients are pinned to a new
+	// alternative whenever the server restarts.
+	AffinityKey []byte
+
+	// Maintenance, if non-nil, provides maintenance information for server
+	// names that have been placed into maintenance mode at runtime. It takes
+	// precedence over the endpoint's own maintenance information.
+	Maintenance MaintenanceSource
+
+	affinityOnce sync.Once
+	affinityKey  []byte
+}
+
+// MaintenanceSource provides maintenance information for server names.
+type MaintenanceSource interface {
+	// Maintenance returns the maintenance information for serverName, or nil
+	// if it is not in maintenance mode.
+	Maintenance(serverName name.ServerName) *backend.Maintenance
 }
 
 // ServeHTTP proxies the request to the appropriate upstream server.
 func (handler *Handler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	handler.serve(writer, request, nil)
+}
+
+// serve proxies the request to the appropriate upstream server. insecure is
+// non-nil if the request was received over plain HTTP.
+func (handler *Handler) serve(
+	writer http.ResponseWriter,
+	request *http.Request,
+	insecure *InsecureHandler,
+) {
 	logContext := &LogContext{Logger: handler.Logger, Request: request}
 	logContext.Metrics.Start()
 
-	err := handler.forward(writer, request, logContext)
+	err := handler.forward(writer, request, logContext, insecure)
 
 	// If there was an error and no response has been sent, send an error page.
 	if err != nil && logContext.StatusCode == 0 {
@@ -43,63 +115,346 @@ func (handler *Handler) forward(
 	writer http.ResponseWriter,
 	request *http.Request,
 	logContext *LogContext,
+	insecure *InsecureHandler,
 ) (err error) {
-	isWebSocket := isWebSocketUpgrade(request.Header)
+	isWebSocket := isWebSocketUpgrade(request.Header) || isWebSocketConnect(request)
 	logContext.IsWebSocket = isWebSocket
 
-	endpoint, err := handler.locate(request)
+	loc, err := handler.locate(request)
 	if err != nil {
 		return
 	}
 
+	endpoint := loc.Endpoint
+	maintenance := loc.Maintenance
 	logContext.Endpoint = endpoint
+	logContext.route = loc.Route
+
+	if insecure != nil && !insecure.serves(request, endpoint) {
+		redirectToHTTPS(writer, request, logContext)
+		return nil
+	}
+
+	if endpoint.GRPCWeb {
+		if isGRPCWebPreflight(request) {
+			logContext.Metrics.FirstByteSent()
+			defer logContext.Metrics.LastByteSent()
+
+			writeGRPCWebPreflight(writer, request)
+			logContext.StatusCode = http.StatusNoContent
+
+			return nil
+		}
+
+		writeGRPCWebCORSHeaders(writer, request)
+	}
+
+	if maintenance != nil {
+		if err = handler.checkMaintenance(writer, request, maintenance); err != nil {
+			return
+		}
+	}
+
+	if handler.Activator != nil {
+		defer handler.Activator.End(endpoint)
+
+		if !handler.Activator.Begin(endpoint) {
+			if err = handler.activate(writer, request, endpoint); err != nil {
+				return
+			}
+		}
+	}
+
+	if handler.OutlierDetector != nil {
+		ok, retryAfter := handler.OutlierDetector.Allow(endpoint)
+		if !ok {
+			return handler.circuitOpen(writer, retryAfter)
+		}
+
+		// Report the result as soon as the upstream server responds, rather
+		// than when the request completes, so that a probe request for a
+		// streaming response or an upgraded connection does not prevent
+		// other requests from being sent to the endpoint while it is open.
+		responded := false
+		logContext.onUpstreamResponse = func(statusCode int) {
+			responded = true
+			handler.OutlierDetector.Report(
+				endpoint,
+				statusCode >= http.StatusInternalServerError,
+			)
+		}
 
-	proxy := handler.selectProxy(endpoint, isWebSocket)
+		defer func() {
+			if !responded {
+				handler.reportOutcome(request, endpoint, logContext, err)
+			}
+		}()
+	}
+
+	// Other upgrade protocols are tunnelled in the same way as websockets, if
+	// the endpoint allows them.
+	isUpgrade := isWebSocket
+	if !isWebSocket {
+		if protocol := upgradeProtocol(request.Header); protocol != "" && endpoint.AllowsUpgrade(protocol) {
+			logContext.Upgrade = protocol
+			isUpgrade = true
+		}
+	}
+
+	proxy := handler.selectProxy(endpoint, isUpgrade)
+	upstreamRequest := handler.prepareUpstreamRequest(request, endpoint, isUpgrade, insecure == nil)
+
+	// Translate gRPC-Web requests into native gRPC requests, and the
+	// responses back again.
+	if endpoint.GRPCWeb && statuspage.IsGRPCWeb(request) {
+		prepareGRPCWebRequest(upstreamRequest)
+
+		w := newGRPCWebResponseWriter(writer, request)
+		writer = w
+
+		defer func() {
+			if err == nil {
+				err = w.Finish()
+			}
+		}()
+	}
+
+	// Only pin the client to the endpoint once the request is actually being
+	// sent to it.
+	if loc.Cookie != nil {
+		http.SetCookie(writer, loc.Cookie)
+	}
 
 	return proxy.Forward(
 		writer,
 		request,
-		handler.prepareUpstreamRequest(request, endpoint, isWebSocket),
+		upstreamRequest,
 		logContext,
 	)
 }
 
+// circuitOpen returns an error indicating that requests are not being sent to
+// an endpoint because it has failed repeatedly.
+func (handler *Handler) circuitOpen(
+	writer http.ResponseWriter,
+	retryAfter time.Duration,
+) error {
+	seconds := (retryAfter + time.Second - 1) / time.Second
+	writer.Header().Set("Retry-After", strconv.FormatInt(int64(seconds), 10))
+
+	return statuspage.Error{
+		Inner:      errors.New("backend is unavailable after repeated failures"),
+		StatusCode: http.StatusServiceUnavailable,
+	}
+}
+
+// reportOutcome reports the result of forwarding request to endpoint to the
+// outlier detector. Only a response from the upstream server, or a failure to
+// get one, is reported as such. Other outcomes release the request without
+// affecting the endpoint's health.
+func (handler *Handler) reportOutcome(
+	request *http.Request,
+	endpoint *backend.Endpoint,
+	logContext *LogContext,
+	err error,
+) {
+	switch {
+	case request.Context().Err() != nil:
+		// The client went away, which says nothing about the server.
+		handler.OutlierDetector.Release(endpoint)
+	case isRejection(err):
+		// The request was rejected before the server was contacted.
+		handler.OutlierDetector.Release(endpoint)
+	case logContext.StatusCode != 0:
+		handler.OutlierDetector.Report(
+			endpoint,
+			logContext.StatusCode >= http.StatusInternalServerError,
+		)
+	case err != nil:
+		handler.OutlierDetector.Report(endpoint, true)
+	default:
+		handler.OutlierDetector.Release(endpoint)
+	}
+}
+
+// location is the result of locating the endpoint for a request.
+type location struct {
+	// Route is the endpoint that was located, before one of its alternatives
+	// was chosen.
+	Route *backend.Endpoint
+
+	// Endpoint is the endpoint that the request is sent to.
+	Endpoint *backend.Endpoint
+
+	// Maintenance is the maintenance information that applies to the
+	// endpoint, if any.
+	Maintenance *backend.Maintenance
+
+	// Cookie is the affinity cookie to send to the client, if any.
+	Cookie *http.Cookie
+}
+
 // locate attempts to use the backend locator to find an endpoint for the given
 // request.
-func (handler *Handler) locate(request *http.Request) (*backend.Endpoint, error) {
+func (handler *Handler) locate(request *http.Request) (location, error) {
 	serverName, err := name.FromHTTP(request)
 	if err != nil {
-		return nil, statuspage.Error{
+		return location{}, statuspage.Error{
 			Inner:      err,
 			StatusCode: http.StatusNotFound,
 		}
 	}
 
 	endpoint, _ := handler.Locator.Locate(request.Context(), serverName)
+
+	if handler.Rules != nil {
+		if ep, score := handler.Rules.LocateRequest(request, serverName); score > 0 {
+			endpoint = ep
+		}
+	}
+
+	// Path endpoints and alternatives that are not offline for maintenance
+	// themselves inherit the maintenance of the host's route.
+	var maintenance *backend.Maintenance
+	if endpoint != nil {
+		maintenance = endpoint.Maintenance
+	}
+
+	endpoint = endpoint.ForPath(request.URL.Path)
 	if endpoint == nil {
-		return nil, statuspage.Error{
+		return location{}, statuspage.Error{
 			Inner:      errors.New("could not locate backend"),
 			StatusCode: http.StatusNotFound,
 		}
 	}
 
-	return endpoint, nil
+	if endpoint.Maintenance != nil {
+		maintenance = endpoint.Maintenance
+	}
+
+	route := endpoint
+
+	if handler.OutlierDetector != nil {
+		endpoint = endpoint.Filter(handler.OutlierDetector.Available)
+	}
+
+	endpoint, cookie := handler.choose(request, endpoint)
+	if endpoint == nil {
+		return location{}, statuspage.Error{
+			Inner:      errors.New("all backends have a weight of zero"),
+			StatusCode: http.StatusServiceUnavailable,
+		}
+	}
+
+	if endpoint.Maintenance != nil {
+		maintenance = endpoint.Maintenance
+	}
+
+	if handler.Maintenance != nil {
+		if m := handler.Maintenance.Maintenance(serverName); m != nil {
+			maintenance = m
+		}
+	}
+
+	return location{
+		Route:       route,
+		Endpoint:    endpoint,
+		Maintenance: maintenance,
+		Cookie:      cookie,
+	}, nil
+}
+
+// checkMaintenance returns an error if the client that sent request is not
+// allowed to access an endpoint that is offline for maintenance.
+func (handler *Handler) checkMaintenance(
+	writer http.ResponseWriter,
+	request *http.Request,
+	maintenance *backend.Maintenance,
+) error {
+	host, _, _ := net.SplitHostPort(request.RemoteAddr)
+	if ip := net.ParseIP(host); ip != nil && maintenance.Allows(ip) {
+		return nil
+	}
+
+	if maintenance.RetryAfter > 0 {
+		seconds := (maintenance.RetryAfter + time.Second - 1) / time.Second
+		writer.Header().Set("Retry-After", strconv.FormatInt(int64(seconds), 10))
+	}
+
+	message := maintenance.Message
+	if message == "" {
+		message = DefaultMaintenanceMessage
+	}
+
+	return statuspage.Error{
+		Inner:      errors.New("backend is offline for maintenance"),
+		StatusCode: http.StatusServiceUnavailable,
+		Message:    message,
+	}
+}
+
+// activate starts an idle endpoint. Browsers are shown a status page that
+// refreshes until the endpoint has started, other requests are held until it
+// is ready.
+func (handler *Handler) activate(
+	writer http.ResponseWriter,
+	request *http.Request,
+	endpoint *backend.Endpoint,
+) error {
+	if statuspage.PrefersHTML(request) {
+		go handler.Activator.Start(context.Background(), endpoint)
+
+		message := handler.StartingMessage
+		if message == "" {
+			message = DefaultStartingMessage
+		}
+
+		interval := strconv.Itoa(startingRefreshInterval)
+		writer.Header().Set("Refresh", interval)
+		writer.Header().Set("Retry-After", interval)
+
+		return statuspage.Error{
+			Inner:      errors.New("backend is starting"),
+			StatusCode: http.StatusServiceUnavailable,
+			Message:    message,
+		}
+	}
+
+	timeout := handler.StartTimeout
+	if timeout == 0 {
+		timeout = DefaultStartTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(request.Context(), timeout)
+	defer cancel()
+
+	if err := handler.Activator.Start(ctx, endpoint); err != nil {
+		return statuspage.Error{
+			Inner:      err,
+			StatusCode: http.StatusServiceUnavailable,
+		}
+	}
+
+	return nil
 }
 
 // prepareUpstreamRequest makes a new http.Request that uses the given endpoint
-// as the upstream server.
+// as the upstream server. isUpgrade is true if the connection is upgraded to a
+// websocket or other protocol. isSecure is true if the request was received
+// over HTTPS.
 func (handler *Handler) prepareUpstreamRequest(
 	request *http.Request,
 	endpoint *backend.Endpoint,
-	isWebSocket bool,
+	isUpgrade bool,
+	isSecure bool,
 ) *http.Request {
 	upstreamRequest := *request
-	upstreamRequest.Header = handler.prepareUpstreamHeaders(request, isWebSocket)
+	upstreamRequest.Header = handler.prepareUpstreamHeaders(request, isSecure)
 
 	upstreamURL := *request.URL
 	upstreamURL.Host = endpoint.Address
 
-	if isWebSocket {
+	if isUpgrade {
 		if endpoint.TLSMode == backend.TLSDisabled {
 			upstreamURL.Scheme = "ws"
 		} else {
@@ -119,12 +474,22 @@ func (handler *Handler) prepareUpstreamRequest(
 }
 
 // prepareUpstreamHeaders produces a copy of request.Header and modifies them so
-// that they are suitable to send to the upstream server.
-func (handler *Handler) prepareUpstreamHeaders(request *http.Request, isWebSocket bool) http.Header {
+// that they are suitable to send to the upstream server. isSecure is true if
+// the request was received over HTTPS.
+func (handler *Handler) prepareUpstreamHeaders(
+	request *http.Request,
+	isSecure bool,
+) http.Header {
 	upstreamHeaders := http.Header{}
 	forwardedFor, _, _ := net.SplitHostPort(request.RemoteAddr)
 
 	for name, values := range request.Header {
+		if strings.HasPrefix(name, ":") {
+			// Pseudo-headers, such as ":protocol" in an extended CONNECT
+			// request, are not forwarded as regular headers.
+			continue
+		}
+
 		if name == "X-Forwarded-For" {
 			forwardedFor = strings.Join(values, ", ") + ", " + forwardedFor
 		} else if !isHopByHopHeader(name) {
@@ -132,23 +497,43 @@ func (handler *Handler) prepareUpstreamHeaders(request *http.Request, isWebSocke
 		}
 	}
 
+	// gRPC servers require the "TE: trailers" header, it is hop-by-hop, but
+	// indicates that the client (and hence the proxy) accepts trailers.
+	if acceptsTrailers(request.Header) {
+		upstreamHeaders.Set("Te", "trailers")
+	}
+
 	upstreamHeaders.Set("Host", request.Host)
 	upstreamHeaders.Set("X-Forwarded-For", forwardedFor)
-	upstreamHeaders.Set("X-Forwarded-SSL", "on")
 
-	if isWebSocket {
-		upstreamHeaders.Set("X-Forwarded-Proto", "wss")
+	isWebSocket := isWebSocketUpgrade(request.Header) || isWebSocketConnect(request)
+
+	if isSecure {
+		upstreamHeaders.Set("X-Forwarded-SSL", "on")
+
+		if isWebSocket {
+			upstreamHeaders.Set("X-Forwarded-Proto", "wss")
+		} else {
+			upstreamHeaders.Set("X-Forwarded-Proto", "https")
+		}
 	} else {
-		upstreamHeaders.Set("X-Forwarded-Proto", "https")
+		upstreamHeaders.Set("X-Forwarded-SSL", "off")
+
+		if isWebSocket {
+			upstreamHeaders.Set("X-Forwarded-Proto", "ws")
+		} else {
+			upstreamHeaders.Set("X-Forwarded-Proto", "http")
+		}
 	}
 
 	return upstreamHeaders
 }
 
-// selectProxy returns the proxy used to connect to the given endpoint.
-func (handler *Handler) selectProxy(endpoint *backend.Endpoint, isWebSocket bool) Proxy {
+// selectProxy returns the proxy used to connect to the given endpoint. isUpgrade
+// is true if the connection is upgraded to a websocket or other protocol.
+func (handler *Handler) selectProxy(endpoint *backend.Endpoint, isUpgrade bool) Proxy {
 	if endpoint.TLSMode == backend.TLSDisabledH2C {
-		if isWebSocket {
+		if isUpgrade {
 			return handler.InsecureWebSocketProxy
 		}
 
@@ -156,14 +541,14 @@ func (handler *Handler) selectProxy(endpoint *backend.Endpoint, isWebSocket bool
 	}
 
 	if endpoint.TLSMode == backend.TLSInsecure {
-		if isWebSocket {
+		if isUpgrade {
 			return handler.InsecureWebSocketProxy
 		}
 
 		return handler.InsecureHTTPProxy
 	}
 
-	if isWebSocket {
+	if isUpgrade {
 		return handler.SecureWebSocketProxy
 	}
 
@@ -178,7 +563,9 @@ func (handler *Handler) writeStatusPage(
 	err error,
 ) {
 	statusWriter := handler.StatusPageWriter
-	if statusWriter == nil {
+	if statuspage.IsGRPC(request) || statuspage.IsGRPCWeb(request) {
+		statusWriter = &statuspage.GRPCWriter{}
+	} else if statusWriter == nil {
 		statusWriter = statuspage.DefaultWriter
 	}
 
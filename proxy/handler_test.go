@@ -0,0 +1,371 @@
+package proxy_test
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/icecave/honeycomb/backend"
+	"github.com/icecave/honeycomb/name"
+	"github.com/icecave/honeycomb/proxy"
+	"github.com/icecave/honeycomb/rule"
+	"github.com/icecave/honeycomb/static"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeProxy is a proxy that responds with a 200 OK.
+type fakeProxy struct {
+	Forwarded int
+}
+
+func (p *fakeProxy) Forward(
+	writer http.ResponseWriter,
+	_ *http.Request,
+	_ *http.Request,
+	log *proxy.LogContext,
+) error {
+	p.Forwarded++
+	log.StatusCode = http.StatusOK
+	writer.WriteHeader(http.StatusOK)
+	return nil
+}
+
+var _ = Describe("Handler", func() {
+	var (
+		endpoint *backend.Endpoint
+		upstream *fakeProxy
+		subject  *proxy.Handler
+	)
+
+	serve := func(remoteAddr string) *httptest.ResponseRecorder {
+		writer := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "https://app.example.com/", nil)
+		request.RemoteAddr = remoteAddr
+		subject.ServeHTTP(writer, request)
+		return writer
+	}
+
+	BeforeEach(func() {
+		endpoint = &backend.Endpoint{Address: "app:80"}
+		upstream = &fakeProxy{}
+
+		subject = &proxy.Handler{
+			Locator:           static.Locator{}.With("app.*", endpoint),
+			InsecureHTTPProxy: upstream,
+			SecureHTTPProxy:   upstream,
+		}
+	})
+
+	Context("when the endpoint is offline for maintenance", func() {
+		BeforeEach(func() {
+			networks, _ := backend.ParseNetworks("10.0.0.0/8")
+			endpoint.Maintenance = &backend.Maintenance{
+				Message:         "Back soon.",
+				RetryAfter:      90 * time.Second,
+				AllowedNetworks: networks,
+			}
+		})
+
+		It("responds with a status page", func() {
+			writer := serve("192.168.0.1:12345")
+
+			Expect(writer.Code).To(Equal(http.StatusServiceUnavailable))
+			Expect(writer.Header().Get("Retry-After")).To(Equal("90"))
+			Expect(writer.Body.String()).To(ContainSubstring("Back soon."))
+			Expect(upstream.Forwarded).To(BeZero())
+		})
+
+		It("forwards requests from allowed clients", func() {
+			writer := serve("10.1.2.3:12345")
+
+			Expect(writer.Code).To(Equal(http.StatusOK))
+			Expect(upstream.Forwarded).To(Equal(1))
+		})
+
+		It("applies to path endpoints that are not offline themselves", func() {
+			endpoint.Paths = []backend.PathEndpoint{
+				{Path: "/", Endpoint: &backend.Endpoint{Address: "app-root:80"}},
+			}
+
+			writer := serve("192.168.0.1:12345")
+
+			Expect(writer.Code).To(Equal(http.StatusServiceUnavailable))
+			Expect(writer.Body.String()).To(ContainSubstring("Back soon."))
+			Expect(upstream.Forwarded).To(BeZero())
+		})
+
+		It("applies to alternatives that are not offline themselves", func() {
+			endpoint.Address = ""
+			endpoint.Alternatives = []*backend.Endpoint{
+				{Address: "app-v1:80", Weight: 1},
+				{Address: "app-v2:80", Weight: 1},
+			}
+
+			writer := serve("192.168.0.1:12345")
+
+			Expect(writer.Code).To(Equal(http.StatusServiceUnavailable))
+			Expect(upstream.Forwarded).To(BeZero())
+		})
+	})
+
+	Context("when all of the alternatives have a weight of zero", func() {
+		It("responds with a status page", func() {
+			endpoint.Address = ""
+			endpoint.Alternatives = []*backend.Endpoint{
+				{Address: "app-v1:80", Weight: 0},
+				{Address: "app-v2:80", Weight: 0},
+			}
+
+			writer := serve("192.168.0.1:12345")
+
+			Expect(writer.Code).To(Equal(http.StatusServiceUnavailable))
+			Expect(upstream.Forwarded).To(BeZero())
+		})
+	})
+
+	Context("when the endpoint has cookie affinity", func() {
+		var logger *bytes.Buffer
+
+		serveWithCookie := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+			writer := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodGet, "https://app.example.com/", nil)
+			if cookie != nil {
+				request.AddCookie(cookie)
+			}
+			subject.ServeHTTP(writer, request)
+			return writer
+		}
+
+		BeforeEach(func() {
+			logger = &bytes.Buffer{}
+			subject.Logger = log.New(logger, "", 0)
+			subject.AffinityKey = []byte("<key>")
+
+			endpoint.Address = ""
+			endpoint.Affinity = &backend.Affinity{
+				Mode:       backend.AffinityCookie,
+				CookieName: "affinity",
+				CookieTTL:  time.Hour,
+			}
+			endpoint.Alternatives = []*backend.Endpoint{
+				{Address: "app-v1:80", Weight: 1},
+				{Address: "app-v2:80", Weight: 1},
+			}
+		})
+
+		It("sets a signed cookie", func() {
+			cookies := serveWithCookie(nil).Result().Cookies()
+
+			Expect(cookies).To(HaveLen(1))
+			Expect(cookies[0].Name).To(Equal("affinity"))
+			Expect(cookies[0].MaxAge).To(Equal(3600))
+			Expect(cookies[0].Secure).To(BeTrue())
+			Expect(cookies[0].HttpOnly).To(BeTrue())
+		})
+
+		It("does not mark the cookie as secure for plain HTTP requests", func() {
+			writer := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodGet, "http://app.example.com/", nil)
+			subject.ServeHTTP(writer, request)
+
+			cookies := writer.Result().Cookies()
+			Expect(cookies).To(HaveLen(1))
+			Expect(cookies[0].Secure).To(BeFalse())
+		})
+
+		It("does not set a cookie when the endpoint is offline for maintenance", func() {
+			endpoint.Maintenance = &backend.Maintenance{Message: "Back soon."}
+
+			writer := serveWithCookie(nil)
+
+			Expect(writer.Code).To(Equal(http.StatusServiceUnavailable))
+			Expect(writer.Result().Cookies()).To(BeEmpty())
+		})
+
+		It("routes subsequent requests to the same alternative", func() {
+			cookie := serveWithCookie(nil).Result().Cookies()[0]
+			logger.Reset()
+
+			for i := 0; i < 10; i++ {
+				writer := serveWithCookie(cookie)
+				Expect(writer.Result().Cookies()).To(BeEmpty())
+			}
+
+			v1 := bytes.Count(logger.Bytes(), []byte("app-v1:80"))
+			v2 := bytes.Count(logger.Bytes(), []byte("app-v2:80"))
+			Expect([]int{v1, v2}).To(ConsistOf(0, 10))
+		})
+
+		It("ignores cookies with an invalid signature", func() {
+			cookie := &http.Cookie{
+				Name:  "affinity",
+				Value: endpoint.Alternatives[0].ID() + ".0.<signature>",
+			}
+
+			Expect(serveWithCookie(cookie).Result().Cookies()).To(HaveLen(1))
+		})
+
+		It("chooses another alternative if the pinned one is removed", func() {
+			cookie := serveWithCookie(nil).Result().Cookies()[0]
+
+			endpoint.Alternatives = []*backend.Endpoint{
+				{Address: "app-v3:80", Weight: 1},
+			}
+			logger.Reset()
+
+			writer := serveWithCookie(cookie)
+			Expect(writer.Code).To(Equal(http.StatusOK))
+			Expect(writer.Result().Cookies()).To(HaveLen(1))
+			Expect(logger.String()).To(ContainSubstring("app-v3:80"))
+		})
+	})
+
+	Context("when the endpoint has client IP affinity", func() {
+		It("routes requests from the same client to the same alternative", func() {
+			logger := &bytes.Buffer{}
+			subject.Logger = log.New(logger, "", 0)
+
+			endpoint.Address = ""
+			endpoint.Affinity = &backend.Affinity{Mode: backend.AffinityClientIP}
+			endpoint.Alternatives = []*backend.Endpoint{
+				{Address: "app-v1:80", Weight: 1},
+				{Address: "app-v2:80", Weight: 1},
+			}
+
+			for i := 0; i < 10; i++ {
+				writer := serve("192.168.0.1:12345")
+				Expect(writer.Result().Cookies()).To(BeEmpty())
+			}
+
+			v1 := bytes.Count(logger.Bytes(), []byte("app-v1:80"))
+			v2 := bytes.Count(logger.Bytes(), []byte("app-v2:80"))
+			Expect([]int{v1, v2}).To(ConsistOf(0, 10))
+		})
+	})
+
+	Context("when a rule matches the request", func() {
+		It("forwards the request to the rule's endpoint", func() {
+			logger := &bytes.Buffer{}
+			subject.Logger = log.New(logger, "", 0)
+
+			matcher, _ := name.NewMatcher("app.*")
+			expr, _ := rule.ParseExpression("header:X-Canary=1")
+			subject.Rules = rule.Set{
+				{
+					Matcher:    matcher,
+					Expression: expr,
+					Endpoint:   &backend.Endpoint{Address: "app-canary:80"},
+				},
+			}
+
+			writer := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodGet, "https://app.example.com/", nil)
+			request.Header.Set("X-Canary", "1")
+			subject.ServeHTTP(writer, request)
+
+			Expect(writer.Code).To(Equal(http.StatusOK))
+			Expect(logger.String()).To(ContainSubstring("app-canary:80"))
+		})
+	})
+
+	Context("when the endpoint has been ejected", func() {
+		var logger *bytes.Buffer
+
+		BeforeEach(func() {
+			logger = &bytes.Buffer{}
+			subject.OutlierDetector = &proxy.OutlierDetector{
+				ConsecutiveFailures: 1,
+				BaseEjectionTime:    time.Minute,
+				Logger:              log.New(logger, "", 0),
+			}
+			subject.OutlierDetector.Report(endpoint, true)
+		})
+
+		It("responds with a status page without forwarding the request", func() {
+			writer := serve("192.168.0.1:12345")
+
+			Expect(writer.Code).To(Equal(http.StatusServiceUnavailable))
+			Expect(writer.Header().Get("Retry-After")).To(Equal("60"))
+			Expect(upstream.Forwarded).To(BeZero())
+		})
+
+		It("does not restore the endpoint if the client cancels the probe", func() {
+			subject.OutlierDetector = &proxy.OutlierDetector{
+				ConsecutiveFailures: 1,
+				BaseEjectionTime:    time.Millisecond,
+				Logger:              log.New(logger, "", 0),
+			}
+			subject.OutlierDetector.Report(endpoint, true)
+			time.Sleep(5 * time.Millisecond)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			writer := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodGet, "https://app.example.com/", nil)
+			subject.ServeHTTP(writer, request.WithContext(ctx))
+
+			Expect(upstream.Forwarded).To(Equal(1))
+			Expect(logger.String()).NotTo(ContainSubstring("Restored"))
+		})
+
+		It("allows other requests while a streaming probe is open", func() {
+			started := make(chan struct{})
+			release := make(chan struct{})
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				w.WriteHeader(http.StatusOK)
+				w.(http.Flusher).Flush()
+
+				if r.URL.Path == "/events" {
+					close(started)
+					<-release
+				}
+			}))
+			defer server.Close()
+			defer close(release)
+
+			u, _ := url.Parse(server.URL)
+			endpoint.Address = u.Host
+
+			p := &proxy.HTTPProxy{Transport: &http.Transport{}}
+			subject.InsecureHTTPProxy = p
+			subject.SecureHTTPProxy = p
+			subject.OutlierDetector = &proxy.OutlierDetector{
+				ConsecutiveFailures: 1,
+				BaseEjectionTime:    time.Millisecond,
+			}
+			subject.OutlierDetector.Report(endpoint, true)
+			time.Sleep(5 * time.Millisecond)
+
+			go subject.ServeHTTP(
+				httptest.NewRecorder(),
+				httptest.NewRequest(http.MethodGet, "https://app.example.com/events", nil),
+			)
+			Eventually(started).Should(BeClosed())
+
+			writer := serve("192.168.0.1:12345")
+			Expect(writer.Code).To(Equal(http.StatusOK))
+		})
+
+		It("routes requests to other alternatives", func() {
+			endpoint.Address = ""
+			endpoint.Alternatives = []*backend.Endpoint{
+				{Address: "app-1:80", Weight: 1},
+				{Address: "app-2:80", Weight: 1},
+			}
+			subject.OutlierDetector.Report(endpoint.Alternatives[0], true)
+
+			for i := 0; i < 10; i++ {
+				writer := serve("192.168.0.1:12345")
+				Expect(writer.Code).To(Equal(http.StatusOK))
+			}
+		})
+	})
+})
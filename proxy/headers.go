@@ -29,6 +29,34 @@ func isWebSocketUpgrade(headers http.Header) bool {
 	return false
 }
 
+// upgradeProtocol returns the protocol that the given HTTP headers request an
+// upgrade to, or an empty string if they do not request an upgrade.
+func upgradeProtocol(headers http.Header) string {
+	for _, value := range header.ParseList(headers, "Connection") {
+		if strings.EqualFold(value, "upgrade") {
+			if protocols := header.ParseList(headers, "Upgrade"); len(protocols) != 0 {
+				return protocols[0]
+			}
+
+			return ""
+		}
+	}
+
+	return ""
+}
+
+// acceptsTrailers checks whether the given HTTP headers indicate that the
+// client accepts trailers.
+func acceptsTrailers(headers http.Header) bool {
+	for _, value := range header.ParseList(headers, "Te") {
+		if strings.EqualFold(value, "trailers") {
+			return true
+		}
+	}
+
+	return false
+}
+
 // isHopByHopHeader checks if a given header name is a Hop-by-Hop header, and
 // hence should not be forwarded to back-end servers. The name must already be
 // canonicalized with http.CanonicalHeaderKey().
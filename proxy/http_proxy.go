@@ -1,14 +1,44 @@
 package proxy
 
 import (
+	"context"
 	"net/http"
+	"time"
 
+	"github.com/icecave/honeycomb/backend"
 	"github.com/icecave/honeycomb/statuspage"
 )
 
 // HTTPProxy is a proxy that handles regular non-websocket connections.
 type HTTPProxy struct {
+	// Transport is used to send requests to the upstream server. If the
+	// upstream server uses TLS, it should negotiate the protocol using ALPN.
 	Transport http.RoundTripper
+
+	// HTTP1Transport and HTTP2Transport, if non-nil, are used instead of
+	// Transport for endpoints that must use HTTP/1.1 or HTTP/2, respectively.
+	HTTP1Transport http.RoundTripper
+	HTTP2Transport http.RoundTripper
+
+	// Retry, if non-nil, determines when failed upstream requests are
+	// retried.
+	Retry *RetryPolicy
+
+	// Timeouts are the default upstream timeouts. They are overridden by the
+	// timeouts of the endpoint that the request is forwarded to.
+	Timeouts backend.Timeouts
+
+	// FlushInterval is the default interval at which streaming responses of
+	// unknown length are flushed to the client. It is overridden by the flush
+	// interval of the endpoint that the request is forwarded to. A negative
+	// value flushes after every write, zero disables flushing. Event streams
+	// are always flushed after every write.
+	FlushInterval time.Duration
+
+	// SecurityHeaders are the default security headers added to responses. They
+	// are overridden by the security headers of the endpoint that the request
+	// is forwarded to.
+	SecurityHeaders backend.SecurityHeaders
 }
 
 // Forward proxies data between the client and the upstream server.
@@ -18,17 +48,80 @@ func (proxy *HTTPProxy) Forward(
 	upstreamRequest *http.Request,
 	logContext *LogContext,
 ) error {
-	upstreamResponse, err := proxy.Transport.RoundTrip(upstreamRequest)
+	var upstreamResponse *http.Response
+	var err error
+
+	timeouts := proxy.Timeouts
+	interval := proxy.FlushInterval
+	if logContext.Endpoint != nil {
+		timeouts = logContext.Endpoint.Timeouts.Merge(timeouts)
+
+		if logContext.Endpoint.FlushInterval != 0 {
+			interval = logContext.Endpoint.FlushInterval
+		}
+	}
+
+	if d, ok := grpcTimeout(upstreamRequest); ok {
+		if timeouts.Total == 0 || d < timeouts.Total {
+			timeouts.Total = d
+		}
+	}
+
+	ctx := upstreamRequest.Context()
+	if timeouts.Total > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeouts.Total)
+		defer cancel()
+		upstreamRequest = upstreamRequest.WithContext(ctx)
+	}
+
+	transport := timeoutTransport{proxy.selectTransport(logContext.Endpoint), timeouts}
+
+	if proxy.Retry == nil {
+		logContext.Attempts = 1
+		upstreamResponse, err = transport.RoundTrip(upstreamRequest)
+	} else {
+		upstreamResponse, err = proxy.Retry.roundTrip(
+			transport,
+			upstreamRequest,
+			logContext,
+		)
+	}
+
 	if err != nil {
-		return statuspage.Error{Inner: err, StatusCode: http.StatusBadGateway}
+		err = totalTimeoutError(ctx, timeouts.Total, err)
+		return statuspage.Error{Inner: err, StatusCode: statusCodeForError(err)}
 	}
 
 	logContext.Metrics.FirstByteSent()
 	defer logContext.Metrics.LastByteSent()
 
-	logContext.StatusCode = upstreamResponse.StatusCode
+	logContext.setUpstreamResponse(upstreamResponse)
 	logContext.Metrics.BytesIn = request.ContentLength // @todo handle -1 (content-length not known)
-	logContext.Metrics.BytesOut, err = writeResponse(writer, upstreamResponse)
+	logContext.Metrics.BytesOut, err = writeResponse(
+		writer,
+		upstreamResponse,
+		interval,
+		securityHeaders(proxy.SecurityHeaders, request, logContext),
+	)
+
+	return totalTimeoutError(ctx, timeouts.Total, err)
+}
+
+// selectTransport returns the transport used to send requests to endpoint.
+func (proxy *HTTPProxy) selectTransport(endpoint *backend.Endpoint) http.RoundTripper {
+	if endpoint != nil {
+		switch endpoint.Protocol {
+		case backend.ProtocolHTTP1:
+			if proxy.HTTP1Transport != nil {
+				return proxy.HTTP1Transport
+			}
+		case backend.ProtocolHTTP2:
+			if proxy.HTTP2Transport != nil {
+				return proxy.HTTP2Transport
+			}
+		}
+	}
 
-	return err
+	return proxy.Transport
 }
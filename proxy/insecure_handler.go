@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/icecave/honeycomb/backend"
+	"github.com/icecave/honeycomb/name"
+)
+
+// InsecureHandler is an http.Handler for requests received over plain HTTP,
+// rather than HTTPS.
+//
+// Requests for endpoints that are served over plain HTTP are proxied by
+// Handler, other requests are redirected to HTTPS. Requests for server names
+// that do not have an endpoint are answered with a 404 status page.
+type InsecureHandler struct {
+	Handler *Handler
+
+	// Allowed is a list of server name patterns for which requests are served
+	// over plain HTTP, regardless of the endpoint's HTTP mode.
+	Allowed []*name.Matcher
+}
+
+// ServeHTTP proxies the request to the appropriate upstream server, or
+// redirects it to HTTPS.
+func (handler *InsecureHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	handler.Handler.serve(writer, request, handler)
+}
+
+// serves returns true if request, which is for endpoint, is proxied over plain
+// HTTP instead of being redirected to HTTPS.
+func (handler *InsecureHandler) serves(request *http.Request, endpoint *backend.Endpoint) bool {
+	if endpoint.HTTPMode == backend.HTTPServe {
+		return true
+	}
+
+	serverName, err := name.FromHTTP(request)
+	if err != nil {
+		return false
+	}
+
+	for _, m := range handler.Allowed {
+		if m.Match(serverName) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// redirectToHTTPS redirects the client to the HTTPS equivalent of the request's
+// URL.
+func redirectToHTTPS(
+	writer http.ResponseWriter,
+	request *http.Request,
+	logContext *LogContext,
+) {
+	logContext.Metrics.FirstByteSent()
+	defer logContext.Metrics.LastByteSent()
+
+	target := "https://" + request.Host + request.URL.EscapedPath()
+	if request.URL.RawQuery != "" {
+		target += "?" + request.URL.RawQuery
+	}
+
+	http.Redirect(writer, request, target, http.StatusTemporaryRedirect)
+	logContext.StatusCode = http.StatusTemporaryRedirect
+}
@@ -0,0 +1,110 @@
+package proxy_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/icecave/honeycomb/backend"
+	"github.com/icecave/honeycomb/name"
+	"github.com/icecave/honeycomb/proxy"
+	"github.com/icecave/honeycomb/static"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// recordingProxy is a proxy that records the upstream request and responds
+// with a 200 OK.
+type recordingProxy struct {
+	Request *http.Request
+}
+
+func (p *recordingProxy) Forward(
+	writer http.ResponseWriter,
+	_ *http.Request,
+	upstreamRequest *http.Request,
+	log *proxy.LogContext,
+) error {
+	p.Request = upstreamRequest
+	log.StatusCode = http.StatusOK
+	writer.WriteHeader(http.StatusOK)
+	return nil
+}
+
+var _ = Describe("InsecureHandler", func() {
+	var (
+		endpoint *backend.Endpoint
+		upstream *recordingProxy
+		subject  *proxy.InsecureHandler
+	)
+
+	serve := func(url string) *httptest.ResponseRecorder {
+		writer := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, url, nil)
+		subject.ServeHTTP(writer, request)
+		return writer
+	}
+
+	BeforeEach(func() {
+		endpoint = &backend.Endpoint{Address: "app:80"}
+		upstream = &recordingProxy{}
+
+		subject = &proxy.InsecureHandler{
+			Handler: &proxy.Handler{
+				Locator:           static.Locator{}.With("app.*", endpoint),
+				InsecureHTTPProxy: upstream,
+				SecureHTTPProxy:   upstream,
+			},
+		}
+	})
+
+	It("redirects requests to HTTPS", func() {
+		writer := serve("http://app.example.com/a%20b?c=d")
+
+		Expect(writer.Code).To(Equal(http.StatusTemporaryRedirect))
+		Expect(writer.Header().Get("Location")).To(Equal("https://app.example.com/a%20b?c=d"))
+		Expect(upstream.Request).To(BeNil())
+	})
+
+	It("does not set an affinity cookie when redirecting", func() {
+		endpoint.Address = ""
+		endpoint.Affinity = &backend.Affinity{Mode: backend.AffinityCookie}
+		endpoint.Alternatives = []*backend.Endpoint{
+			{Address: "app-v1:80", Weight: 1},
+			{Address: "app-v2:80", Weight: 1},
+		}
+
+		writer := serve("http://app.example.com/")
+
+		Expect(writer.Code).To(Equal(http.StatusTemporaryRedirect))
+		Expect(writer.Result().Cookies()).To(BeEmpty())
+	})
+
+	It("responds with a status page for unknown server names", func() {
+		writer := serve("http://unknown.example.com/")
+
+		Expect(writer.Code).To(Equal(http.StatusNotFound))
+		Expect(upstream.Request).To(BeNil())
+	})
+
+	It("proxies requests for endpoints that are served over plain HTTP", func() {
+		endpoint.HTTPMode = backend.HTTPServe
+
+		writer := serve("http://app.example.com/")
+
+		Expect(writer.Code).To(Equal(http.StatusOK))
+		Expect(upstream.Request).NotTo(BeNil())
+		Expect(upstream.Request.Header.Get("X-Forwarded-Proto")).To(Equal("http"))
+		Expect(upstream.Request.Header.Get("X-Forwarded-SSL")).To(Equal("off"))
+	})
+
+	It("proxies requests for server names in the allow-list", func() {
+		m, err := name.NewMatcher("*.example.com")
+		Expect(err).ShouldNot(HaveOccurred())
+		subject.Allowed = []*name.Matcher{m}
+
+		writer := serve("http://app.example.com/")
+
+		Expect(writer.Code).To(Equal(http.StatusOK))
+		Expect(upstream.Request).NotTo(BeNil())
+	})
+})
@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"time"
+
+	"github.com/icecave/honeycomb/backend"
 )
 
 // writeRequestHeaders writes the headers from request to writer.
@@ -26,32 +29,91 @@ func writeRequestHeaders(writer io.Writer, request *http.Request) error {
 	return err
 }
 
-// writeResponseHeaders writes the headers from response to writer.
+// writeResponseHeaders writes the headers from response to writer, along with
+// the security headers described by security.
 func writeResponseHeaders(
 	writer http.ResponseWriter,
 	response *http.Response,
-	isWebSocket bool,
+	isUpgrade bool,
+	security backend.SecurityHeaders,
 ) {
 	headers := writer.Header()
 	for name, values := range response.Header {
-		if !isHopByHopHeader(name) {
+		if isHopByHopHeader(name) {
+			continue
+		}
+
+		if isListResponseHeader(name) {
+			// Append rather than replace, so that headers set by the handler,
+			// such as cookies, are not discarded.
+			headers[name] = append(headers[name], values...)
+		} else {
+			// Otherwise the upstream server's value takes precedence over any
+			// value set by the handler, such as CORS headers.
 			headers[name] = values
 		}
 	}
 
-	if isWebSocket {
+	// Announce the trailers that the upstream server has declared. Their values
+	// are copied once the body has been written.
+	for name := range response.Trailer {
+		headers.Add("Trailer", name)
+	}
+
+	if isUpgrade {
 		headers.Set("Connection", "upgrade")
-		headers.Set("Upgrade", "websocket")
+		headers.Set("Upgrade", response.Header.Get("Upgrade"))
 	}
 
-	headers.Set("Strict-Transport-Security", "max-age=15768000")
+	writeSecurityHeaders(headers, security)
 
 	writer.WriteHeader(response.StatusCode)
 }
 
-// writeResponse writes the entirety of response to writer.
-func writeResponse(writer http.ResponseWriter, response *http.Response) (int64, error) {
+// isListResponseHeader returns true if the values of the response header with
+// the given canonical name set by the handler are combined with those sent by
+// the upstream server.
+func isListResponseHeader(name string) bool {
+	return name == "Set-Cookie" || name == "Vary"
+}
+
+// writeResponse writes the entirety of response to writer. Streaming responses
+// are flushed to the client as per flushInterval().
+func writeResponse(
+	writer http.ResponseWriter,
+	response *http.Response,
+	interval time.Duration,
+	security backend.SecurityHeaders,
+) (int64, error) {
 	defer response.Body.Close()
-	writeResponseHeaders(writer, response, false)
-	return io.Copy(writer, response.Body)
+	writeResponseHeaders(writer, response, false, security)
+
+	w := newFlushWriter(writer, flushInterval(response, interval))
+	if fw, ok := w.(*flushWriter); ok {
+		defer fw.Stop()
+
+		if fw.interval < 0 {
+			// Send the headers immediately, so that the client knows the
+			// stream has started even if the first event is some way off.
+			fw.flusher.Flush()
+		}
+	}
+
+	n, err := io.Copy(w, response.Body)
+	if err != nil {
+		return n, err
+	}
+
+	writeResponseTrailers(writer, response)
+
+	return n, nil
+}
+
+// writeResponseTrailers copies the trailers from response to writer. It must
+// be called after the response body has been read in its entirety.
+func writeResponseTrailers(writer http.ResponseWriter, response *http.Response) {
+	headers := writer.Header()
+	for name, values := range response.Trailer {
+		headers[http.TrailerPrefix+name] = values
+	}
 }
@@ -23,10 +23,44 @@ type LogContext struct {
 	Request     *http.Request
 	Endpoint    *backend.Endpoint
 
+	// Upgrade is the protocol that a non-websocket connection was upgraded
+	// to, if any.
+	Upgrade string
+
+	// Attempts is the number of attempts made to send the request to the
+	// upstream server.
+	Attempts int
+
+	// UpstreamProtocol is the protocol used to communicate with the upstream
+	// server, such as "HTTP/2.0".
+	UpstreamProtocol string
+
+	// route is the endpoint that was located for the request, before one of
+	// its alternatives was chosen.
+	route *backend.Endpoint
+
+	// onUpstreamResponse, if non-nil, is called when the response headers are
+	// received from the upstream server.
+	onUpstreamResponse func(statusCode int)
+
 	prefixLength int
 	buffer       bytes.Buffer
 }
 
+// setUpstreamResponse records the status and protocol of the response
+// received from the upstream server. It is called as soon as the response
+// headers are received, before the body or any upgraded connection is
+// proxied.
+func (ctx *LogContext) setUpstreamResponse(response *http.Response) {
+	ctx.StatusCode = response.StatusCode
+	ctx.UpstreamProtocol = response.Proto
+
+	if fn := ctx.onUpstreamResponse; fn != nil {
+		ctx.onUpstreamResponse = nil
+		fn(response.StatusCode)
+	}
+}
+
 // Log writes a log entry for the context to the logger.
 //
 // The log format consists of the following space separated fields:
@@ -42,12 +76,18 @@ type LogContext struct {
 // - time to last byte
 // - bytes inbound
 // - bytes outbound
+// - upstream attempts
+// - upstream protocol
+// - websocket messages inbound and outbound
+// - websocket close status
 // - message (optional)
 //
 // The event types are:
 // - "HTTP" - regular HTTP request
 // - "WS/CN" - websocket connected
 // - "WS/DC" - websocket disconnected
+// - "UP/CN" - upgraded connection connected
+// - "UP/DC" - upgraded connection disconnected
 //
 // All fields are always present, except for the message which is optional. If a
 // field value is unknown or not applicable, a hyphen is used in place. If a
@@ -61,12 +101,12 @@ func (ctx *LogContext) Log(err error) {
 	ctx.writePrefix()
 
 	// event type
-	if !ctx.IsWebSocket {
-		ctx.write("HTTP")
-	} else if ctx.Metrics.IsLastByteSent() {
-		ctx.write("WS/DC")
+	if ctx.IsWebSocket {
+		ctx.writeConnectionEvent("WS")
+	} else if ctx.Upgrade != "" {
+		ctx.writeConnectionEvent("UP")
 	} else {
-		ctx.write("WS/CN")
+		ctx.write("HTTP")
 	}
 
 	// status code
@@ -110,6 +150,32 @@ func (ctx *LogContext) Log(err error) {
 		ctx.write("")
 	}
 
+	// upstream attempts
+	if ctx.Attempts == 0 {
+		ctx.write("")
+	} else {
+		ctx.write("a/%d", ctx.Attempts)
+	}
+
+	// upstream protocol
+	ctx.write(ctx.UpstreamProtocol)
+
+	// websocket messages and close status
+	if ws := ctx.Metrics.WebSocket; ws != nil && ctx.Metrics.IsLastByteSent() {
+		ctx.write("m/%d:%d", ws.In.Messages(), ws.Out.Messages())
+
+		if ws.ClosedBy == "" {
+			ctx.write("")
+		} else if ws.CloseReason == "" {
+			ctx.write("c/%s/%d", ws.ClosedBy, ws.CloseCode)
+		} else {
+			ctx.write("c/%s/%d/%s", ws.ClosedBy, ws.CloseCode, ws.CloseReason)
+		}
+	} else {
+		ctx.write("")
+		ctx.write("")
+	}
+
 	// optional message
 	if err != nil {
 		ctx.write(err.Error())
@@ -119,6 +185,16 @@ func (ctx *LogContext) Log(err error) {
 	ctx.buffer.Truncate(ctx.prefixLength)
 }
 
+// writeConnectionEvent writes the event type for a connection that has been
+// upgraded from HTTP to another protocol.
+func (ctx *LogContext) writeConnectionEvent(prefix string) {
+	if ctx.Metrics.IsLastByteSent() {
+		ctx.write(prefix + "/DC")
+	} else {
+		ctx.write(prefix + "/CN")
+	}
+}
+
 // write is a helper function that writes to a string to a buffer, quoting the
 // string if it contains whitespace or special characters.
 func (ctx *LogContext) write(str string, v ...interface{}) {
@@ -1,6 +1,10 @@
 package proxy
 
-import "time"
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+)
 
 // Metrics stores basic measuresments for a request.
 type Metrics struct {
@@ -15,6 +19,10 @@ type Metrics struct {
 	StartedAt       time.Time
 	TimeToFirstByte float64
 	TimeToLastByte  float64
+
+	// WebSocket holds measurements of the websocket frames sent in each
+	// direction. It is nil unless the frame stream is inspected.
+	WebSocket *WebSocketMetrics
 }
 
 // Start the timer.
@@ -43,3 +51,57 @@ func (metrics *Metrics) LastByteSent() {
 func (metrics *Metrics) IsLastByteSent() bool {
 	return metrics.TimeToLastByte > 0
 }
+
+// WebSocketMetrics stores measurements of the websocket frames sent in each
+// direction. It is only populated if the frame stream is inspected.
+type WebSocketMetrics struct {
+	// In holds the messages received from the client, and Out holds the
+	// messages received from the upstream server.
+	In  FrameCounts
+	Out FrameCounts
+
+	// ClosedBy is the side of the connection that sent the first close frame,
+	// either "client" or "upstream". It is empty if no close frame was sent.
+	ClosedBy string
+
+	// CloseCode and CloseReason are the status code and reason from the first
+	// close frame. CloseCode is zero if the close frame did not include a
+	// status code.
+	CloseCode   int
+	CloseReason string
+
+	m sync.Mutex
+}
+
+// FrameCounts holds the number of websocket messages of each type. Fragmented
+// messages are counted once.
+type FrameCounts struct {
+	Text   int64
+	Binary int64
+	Close  int64
+	Ping   int64
+	Pong   int64
+}
+
+// Messages returns the total number of text and binary messages.
+func (c FrameCounts) Messages() int64 {
+	return c.Text + c.Binary
+}
+
+// recordClose records the close frame payload sent by the given side, if no
+// other close frame has been recorded.
+func (metrics *WebSocketMetrics) recordClose(side string, payload []byte) {
+	metrics.m.Lock()
+	defer metrics.m.Unlock()
+
+	if metrics.ClosedBy != "" {
+		return
+	}
+
+	metrics.ClosedBy = side
+
+	if len(payload) >= 2 {
+		metrics.CloseCode = int(binary.BigEndian.Uint16(payload))
+		metrics.CloseReason = string(payload[2:])
+	}
+}
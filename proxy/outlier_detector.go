@@ -0,0 +1,247 @@
+package proxy
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/icecave/honeycomb/backend"
+)
+
+const (
+	// DefaultConsecutiveFailures is the default number of consecutive
+	// failures after which an endpoint is ejected.
+	DefaultConsecutiveFailures = 5
+
+	// DefaultBaseEjectionTime is the default amount of time for which an
+	// endpoint is ejected the first time.
+	DefaultBaseEjectionTime = 10 * time.Second
+
+	// DefaultMaxEjectionTime is the default maximum amount of time for which
+	// an endpoint is ejected.
+	DefaultMaxEjectionTime = 5 * time.Minute
+)
+
+// OutlierDetector tracks failed requests to each endpoint, and ejects
+// endpoints that fail repeatedly so that requests are not sent to them.
+//
+// An endpoint is ejected after a number of consecutive failures, for a period
+// that doubles each time it is ejected again without recovering. Once the
+// period has elapsed, a single "probe" request is allowed through. If it
+// succeeds, the endpoint is restored, otherwise it is ejected again.
+//
+// The failure history of an endpoint that has not been located for longer
+// than the maximum ejection time is forgotten, so that endpoints that are no
+// longer routed to are not tracked indefinitely.
+type OutlierDetector struct {
+	// ConsecutiveFailures is the number of consecutive failures after which
+	// an endpoint is ejected. If it is zero, DefaultConsecutiveFailures is
+	// used.
+	ConsecutiveFailures int
+
+	// BaseEjectionTime is the amount of time for which an endpoint is ejected
+	// the first time. If it is zero, DefaultBaseEjectionTime is used.
+	BaseEjectionTime time.Duration
+
+	// MaxEjectionTime is the maximum amount of time for which an endpoint is
+	// ejected. If it is zero, DefaultMaxEjectionTime is used.
+	MaxEjectionTime time.Duration
+
+	Logger *log.Logger
+
+	m      sync.Mutex
+	states map[string]*outlierState
+}
+
+// outlierState is the failure history of a single endpoint.
+type outlierState struct {
+	failures     int
+	ejections    int
+	ejectedUntil time.Time
+	probing      bool
+	locatedAt    time.Time
+}
+
+// Available returns false if ep is currently ejected.
+func (d *OutlierDetector) Available(ep *backend.Endpoint) bool {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	st, ok := d.states[ep.Address]
+	if !ok {
+		return true
+	}
+
+	st.locatedAt = time.Now()
+
+	if st.ejectedUntil.IsZero() {
+		return true
+	}
+
+	return !st.probing && !time.Now().Before(st.ejectedUntil)
+}
+
+// Allow returns true if a request may be sent to ep. If ep is ejected, it
+// returns false along with the amount of time until a request will be allowed
+// again.
+//
+// Each call that returns true must be followed by a call to Report() or
+// Release().
+func (d *OutlierDetector) Allow(ep *backend.Endpoint) (bool, time.Duration) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	st, ok := d.states[ep.Address]
+	if !ok {
+		return true, 0
+	}
+
+	st.locatedAt = time.Now()
+
+	if st.ejectedUntil.IsZero() {
+		return true, 0
+	}
+
+	if remaining := time.Until(st.ejectedUntil); remaining > 0 {
+		return false, remaining
+	}
+
+	if st.probing {
+		// Another request is already probing the endpoint, wait for it to
+		// report its result.
+		return false, time.Second
+	}
+
+	st.probing = true
+
+	return true, 0
+}
+
+// Release records that a request to ep was abandoned without a response from
+// the upstream server, such as when the client disconnects or the request is
+// rejected by the proxy. It says nothing about the endpoint's health, so if
+// the request was probing an ejected endpoint, the endpoint remains ejected
+// and another probe request is allowed.
+func (d *OutlierDetector) Release(ep *backend.Endpoint) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if st, ok := d.states[ep.Address]; ok {
+		st.probing = false
+	}
+}
+
+// Report records the result of a request to ep.
+func (d *OutlierDetector) Report(ep *backend.Endpoint, failed bool) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	st, ok := d.states[ep.Address]
+
+	if !failed {
+		if ok {
+			if !st.ejectedUntil.IsZero() {
+				d.log("Restored '%s' (%s) after a successful request", ep.Address, ep.Description)
+			}
+
+			delete(d.states, ep.Address)
+		}
+
+		return
+	}
+
+	if !ok {
+		d.prune()
+
+		if d.states == nil {
+			d.states = map[string]*outlierState{}
+		}
+
+		st = &outlierState{locatedAt: time.Now()}
+		d.states[ep.Address] = st
+	}
+
+	st.failures++
+
+	threshold := d.ConsecutiveFailures
+	if threshold == 0 {
+		threshold = DefaultConsecutiveFailures
+	}
+
+	if !st.probing && st.failures < threshold {
+		return
+	}
+
+	if !st.probing && !st.ejectedUntil.IsZero() && time.Now().Before(st.ejectedUntil) {
+		// Already ejected, this is a request that was in-flight at the time.
+		return
+	}
+
+	ejection := d.ejectionTime(st.ejections)
+	st.ejections++
+	st.ejectedUntil = time.Now().Add(ejection)
+	st.probing = false
+
+	d.log(
+		"Ejected '%s' (%s) for %s after %d consecutive failure(s)",
+		ep.Address,
+		ep.Description,
+		ejection,
+		st.failures,
+	)
+}
+
+// prune removes the failure history of endpoints that have not been located
+// for longer than the maximum ejection time. d.m must be locked.
+func (d *OutlierDetector) prune() {
+	now := time.Now()
+	max := d.maxEjectionTime()
+
+	for addr, st := range d.states {
+		if st.probing || now.Before(st.ejectedUntil) {
+			continue
+		}
+
+		if now.Sub(st.locatedAt) > max {
+			delete(d.states, addr)
+		}
+	}
+}
+
+// ejectionTime returns the amount of time for which an endpoint that has
+// already been ejected n times is ejected.
+func (d *OutlierDetector) ejectionTime(n int) time.Duration {
+	base := d.BaseEjectionTime
+	if base == 0 {
+		base = DefaultBaseEjectionTime
+	}
+
+	max := d.maxEjectionTime()
+
+	t := base
+	for i := 0; i < n && t < max; i++ {
+		t *= 2
+	}
+
+	if t > max {
+		t = max
+	}
+
+	return t
+}
+
+// maxEjectionTime returns the maximum amount of time for which an endpoint is
+// ejected.
+func (d *OutlierDetector) maxEjectionTime() time.Duration {
+	if d.MaxEjectionTime == 0 {
+		return DefaultMaxEjectionTime
+	}
+
+	return d.MaxEjectionTime
+}
+
+func (d *OutlierDetector) log(format string, args ...interface{}) {
+	if d.Logger != nil {
+		d.Logger.Printf(format, args...)
+	}
+}
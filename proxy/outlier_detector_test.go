@@ -0,0 +1,124 @@
+package proxy_test
+
+import (
+	"time"
+
+	"github.com/icecave/honeycomb/backend"
+	"github.com/icecave/honeycomb/proxy"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OutlierDetector", func() {
+	var (
+		endpoint *backend.Endpoint
+		subject  *proxy.OutlierDetector
+	)
+
+	fail := func(n int) {
+		for i := 0; i < n; i++ {
+			ok, _ := subject.Allow(endpoint)
+			Expect(ok).To(BeTrue())
+			subject.Report(endpoint, true)
+		}
+	}
+
+	BeforeEach(func() {
+		endpoint = &backend.Endpoint{Address: "app:80"}
+		subject = &proxy.OutlierDetector{
+			ConsecutiveFailures: 3,
+			BaseEjectionTime:    50 * time.Millisecond,
+			MaxEjectionTime:     150 * time.Millisecond,
+		}
+	})
+
+	It("allows requests to endpoints that have not failed", func() {
+		ok, _ := subject.Allow(endpoint)
+		Expect(ok).To(BeTrue())
+		Expect(subject.Available(endpoint)).To(BeTrue())
+	})
+
+	It("ejects an endpoint after consecutive failures", func() {
+		fail(3)
+
+		ok, retryAfter := subject.Allow(endpoint)
+		Expect(ok).To(BeFalse())
+		Expect(retryAfter).To(BeNumerically(">", 0))
+		Expect(subject.Available(endpoint)).To(BeFalse())
+	})
+
+	It("does not eject an endpoint if a request succeeds in between failures", func() {
+		fail(2)
+		subject.Report(endpoint, false)
+		fail(2)
+
+		ok, _ := subject.Allow(endpoint)
+		Expect(ok).To(BeTrue())
+	})
+
+	It("allows a single probe request once the ejection time has elapsed", func() {
+		fail(3)
+		time.Sleep(60 * time.Millisecond)
+
+		ok, _ := subject.Allow(endpoint)
+		Expect(ok).To(BeTrue())
+
+		ok, _ = subject.Allow(endpoint)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("restores the endpoint if the probe succeeds", func() {
+		fail(3)
+		time.Sleep(60 * time.Millisecond)
+
+		subject.Allow(endpoint)
+		subject.Report(endpoint, false)
+
+		ok, _ := subject.Allow(endpoint)
+		Expect(ok).To(BeTrue())
+		Expect(subject.Available(endpoint)).To(BeTrue())
+	})
+
+	It("ejects the endpoint for longer if the probe fails", func() {
+		fail(3)
+		time.Sleep(60 * time.Millisecond)
+
+		subject.Allow(endpoint)
+		subject.Report(endpoint, true)
+
+		ok, retryAfter := subject.Allow(endpoint)
+		Expect(ok).To(BeFalse())
+		Expect(retryAfter).To(BeNumerically(">", 50*time.Millisecond))
+	})
+
+	It("keeps the endpoint ejected if the probe is released", func() {
+		fail(3)
+		time.Sleep(60 * time.Millisecond)
+
+		subject.Allow(endpoint)
+		subject.Release(endpoint)
+
+		ok, _ := subject.Allow(endpoint)
+		Expect(ok).To(BeTrue())
+
+		subject.Report(endpoint, true)
+
+		ok, retryAfter := subject.Allow(endpoint)
+		Expect(ok).To(BeFalse())
+		Expect(retryAfter).To(BeNumerically(">", 50*time.Millisecond))
+	})
+
+	It("forgets the failures of endpoints that are no longer located", func() {
+		fail(2)
+		time.Sleep(160 * time.Millisecond)
+
+		other := &backend.Endpoint{Address: "other:80"}
+		subject.Allow(other)
+		subject.Report(other, true)
+
+		fail(1)
+
+		ok, _ := subject.Allow(endpoint)
+		Expect(ok).To(BeTrue())
+	})
+})
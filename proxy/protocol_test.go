@@ -0,0 +1,76 @@
+package proxy_test
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/icecave/honeycomb/backend"
+	"github.com/icecave/honeycomb/proxy"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HTTPProxy protocol selection", func() {
+	var (
+		server   *httptest.Server
+		endpoint *backend.Endpoint
+		subject  *proxy.HTTPProxy
+	)
+
+	forward := func() *proxy.LogContext {
+		request := httptest.NewRequest("GET", server.URL+"/", nil)
+		request.RequestURI = ""
+
+		logContext := &proxy.LogContext{Request: request, Endpoint: endpoint}
+		err := subject.Forward(httptest.NewRecorder(), request, request, logContext)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		return logContext
+	}
+
+	BeforeEach(func() {
+		server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Proto))
+		}))
+		server.EnableHTTP2 = true
+		server.StartTLS()
+
+		tlsConfig := &tls.Config{InsecureSkipVerify: true}
+
+		endpoint = &backend.Endpoint{}
+		subject = &proxy.HTTPProxy{
+			Transport: &http.Transport{
+				TLSClientConfig:   tlsConfig,
+				ForceAttemptHTTP2: true,
+			},
+			HTTP1Transport: &http.Transport{
+				TLSClientConfig: tlsConfig,
+				TLSNextProto:    map[string]func(string, *tls.Conn) http.RoundTripper{},
+			},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("negotiates the protocol by default", func() {
+		logContext := forward()
+		Expect(logContext.UpstreamProtocol).To(Equal("HTTP/2.0"))
+	})
+
+	It("uses the transport for the endpoint's protocol", func() {
+		endpoint.Protocol = backend.ProtocolHTTP1
+
+		logContext := forward()
+		Expect(logContext.UpstreamProtocol).To(Equal("HTTP/1.1"))
+	})
+
+	It("falls back to the default transport if there is no transport for the endpoint's protocol", func() {
+		endpoint.Protocol = backend.ProtocolHTTP2
+
+		logContext := forward()
+		Expect(logContext.UpstreamProtocol).To(Equal("HTTP/2.0"))
+	})
+})
@@ -0,0 +1,271 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultRetryAttempts is the default maximum number of attempts made for
+	// each request, including the first.
+	DefaultRetryAttempts = 2
+
+	// DefaultRetryBudget is the default ratio of retries to requests.
+	DefaultRetryBudget = 0.2
+
+	// DefaultRetryBackoff is the default base delay between attempts.
+	DefaultRetryBackoff = 25 * time.Millisecond
+
+	// maxRetryBackoff is the maximum delay between attempts.
+	maxRetryBackoff = time.Second
+
+	// minRetriesPerSecond is the number of retries that are allowed each
+	// second regardless of the retry budget, so that retries are possible
+	// when there is little traffic.
+	minRetriesPerSecond = 10
+)
+
+// RetryPolicy determines when failed upstream requests are retried.
+//
+// A request is only retried if it is safe to do so, that is, if the upstream
+// server can not have received any part of the request, or if the request uses
+// an idempotent method and does not have a body.
+type RetryPolicy struct {
+	// Attempts is the maximum number of attempts made for each request,
+	// including the first. If it is zero, DefaultRetryAttempts is used.
+	Attempts int
+
+	// Budget is the maximum ratio of retries to requests, which prevents
+	// retries from overloading a struggling server. It must be greater than
+	// zero and no more than one, otherwise DefaultRetryBudget is used.
+	Budget float64
+
+	// Backoff is the base delay between attempts. The actual delay is chosen
+	// at random, up to an amount that doubles with each attempt. If it is
+	// zero, DefaultRetryBackoff is used.
+	Backoff time.Duration
+
+	// StatusCodes is a list of upstream response status codes that cause an
+	// idempotent request to be retried.
+	StatusCodes []int
+
+	m       sync.Mutex
+	tokens  float64
+	updated time.Time
+}
+
+// roundTrip sends request to the upstream server using transport, retrying
+// according to the policy. It records the number of attempts in logContext.
+func (policy *RetryPolicy) roundTrip(
+	transport http.RoundTripper,
+	request *http.Request,
+	logContext *LogContext,
+) (*http.Response, error) {
+	policy.deposit()
+
+	body := request.Body
+	var replay *replayableBody
+	if body != nil && body != http.NoBody {
+		replay = &replayableBody{ReadCloser: body}
+	}
+
+	for attempt := 1; ; attempt++ {
+		logContext.Attempts = attempt
+
+		var wrote int32
+		trace := &httptrace.ClientTrace{
+			WroteHeaderField: func(string, []string) {
+				atomic.StoreInt32(&wrote, 1)
+			},
+		}
+
+		req := request.WithContext(httptrace.WithClientTrace(request.Context(), trace))
+		if replay != nil {
+			req.Body = replay
+		}
+
+		response, err := transport.RoundTrip(req)
+
+		if attempt >= policy.attempts() ||
+			!policy.shouldRetry(request, response, err, atomic.LoadInt32(&wrote) == 1, replay) ||
+			!policy.withdraw() {
+			return response, err
+		}
+
+		if response != nil {
+			response.Body.Close()
+		}
+
+		if err := policy.wait(request.Context(), attempt); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// shouldRetry returns true if the result of an attempt to send request
+// indicates that the request should be retried.
+func (policy *RetryPolicy) shouldRetry(
+	request *http.Request,
+	response *http.Response,
+	err error,
+	wrote bool,
+	body *replayableBody,
+) bool {
+	if request.Context().Err() != nil {
+		return false
+	}
+
+	if body != nil && body.IsRead() {
+		return false
+	}
+
+	if err != nil {
+		return !wrote || (body == nil && isIdempotent(request.Method))
+	}
+
+	if body != nil || !isIdempotent(request.Method) {
+		return false
+	}
+
+	for _, code := range policy.StatusCodes {
+		if response.StatusCode == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// wait blocks for a random delay before the next attempt.
+func (policy *RetryPolicy) wait(ctx context.Context, attempt int) error {
+	backoff := policy.Backoff
+	if backoff == 0 {
+		backoff = DefaultRetryBackoff
+	}
+
+	for i := 1; i < attempt && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > maxRetryBackoff {
+		backoff = maxRetryBackoff
+	}
+
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(backoff) + 1)))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// IsValidRetryBudget returns true if budget is a valid ratio of retries to
+// requests, that is, greater than zero and no more than one.
+func IsValidRetryBudget(budget float64) bool {
+	return budget > 0 && budget <= 1
+}
+
+func (policy *RetryPolicy) attempts() int {
+	if policy.Attempts == 0 {
+		return DefaultRetryAttempts
+	}
+
+	return policy.Attempts
+}
+
+// deposit adds to the retry budget for a new request.
+func (policy *RetryPolicy) deposit() {
+	budget := policy.Budget
+	if !IsValidRetryBudget(budget) {
+		budget = DefaultRetryBudget
+	}
+
+	policy.m.Lock()
+	defer policy.m.Unlock()
+
+	policy.refill()
+	policy.tokens += budget
+}
+
+// withdraw removes a retry from the budget. It returns false if the budget is
+// exhausted.
+func (policy *RetryPolicy) withdraw() bool {
+	policy.m.Lock()
+	defer policy.m.Unlock()
+
+	policy.refill()
+
+	if policy.tokens < 1 {
+		return false
+	}
+
+	policy.tokens--
+
+	return true
+}
+
+// refill adds the retries that are allowed regardless of the budget. The
+// mutex must be locked.
+func (policy *RetryPolicy) refill() {
+	now := time.Now()
+
+	if !policy.updated.IsZero() {
+		policy.tokens += now.Sub(policy.updated).Seconds() * minRetriesPerSecond
+	} else {
+		policy.tokens += minRetriesPerSecond
+	}
+
+	if max := float64(minRetriesPerSecond) * 10; policy.tokens > max {
+		policy.tokens = max
+	}
+
+	policy.updated = now
+}
+
+// isIdempotent returns true if requests with the given method can safely be
+// sent more than once.
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet,
+		http.MethodHead,
+		http.MethodOptions,
+		http.MethodTrace,
+		http.MethodPut,
+		http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// replayableBody is a request body that can be sent again if the transport
+// fails without reading from it.
+type replayableBody struct {
+	io.ReadCloser
+	read int32
+}
+
+func (b *replayableBody) Read(p []byte) (int, error) {
+	atomic.StoreInt32(&b.read, 1)
+	return b.ReadCloser.Read(p)
+}
+
+// Close does not close the underlying body, which is closed by the HTTP server
+// once the request has been handled.
+func (b *replayableBody) Close() error {
+	return nil
+}
+
+// IsRead returns true if the transport has read from the body.
+func (b *replayableBody) IsRead() bool {
+	return atomic.LoadInt32(&b.read) == 1
+}
@@ -0,0 +1,202 @@
+package proxy_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"strings"
+	"time"
+
+	"github.com/icecave/honeycomb/proxy"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeTransport is a round-tripper that returns a pre-defined sequence of
+// results.
+type fakeTransport struct {
+	Results []fakeResult
+	Bodies  []string
+}
+
+type fakeResult struct {
+	StatusCode int
+	Err        error
+
+	// Wrote indicates that the request headers were written before the
+	// error occurred.
+	Wrote bool
+}
+
+func (t *fakeTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	r := t.Results[0]
+	t.Results = t.Results[1:]
+
+	if r.Wrote {
+		if trace := httptrace.ContextClientTrace(request.Context()); trace != nil {
+			trace.WroteHeaderField("Host", []string{request.Host})
+		}
+
+		if request.Body != nil {
+			body, _ := ioutil.ReadAll(request.Body)
+			t.Bodies = append(t.Bodies, string(body))
+		}
+	}
+
+	if r.Err != nil {
+		return nil, r.Err
+	}
+
+	return &http.Response{
+		StatusCode: r.StatusCode,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+	}, nil
+}
+
+var _ = Describe("HTTPProxy", func() {
+	var (
+		transport  *fakeTransport
+		logContext *proxy.LogContext
+		subject    *proxy.HTTPProxy
+	)
+
+	forward := func(method string, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, "https://app.example.com/", strings.NewReader(body))
+		if body == "" {
+			request.Body = http.NoBody
+		}
+
+		logContext = &proxy.LogContext{Request: request}
+		writer := httptest.NewRecorder()
+		subject.Forward(writer, request, request, logContext)
+
+		return writer
+	}
+
+	BeforeEach(func() {
+		transport = &fakeTransport{}
+		subject = &proxy.HTTPProxy{
+			Transport: transport,
+			Retry: &proxy.RetryPolicy{
+				Attempts:    3,
+				Backoff:     time.Millisecond,
+				StatusCodes: []int{http.StatusServiceUnavailable},
+			},
+		}
+	})
+
+	Describe("Forward", func() {
+		It("retries requests that fail before anything is written", func() {
+			transport.Results = []fakeResult{
+				{Err: errors.New("connection refused")},
+				{StatusCode: http.StatusOK},
+			}
+
+			writer := forward(http.MethodPost, "<body>")
+
+			Expect(writer.Code).To(Equal(http.StatusOK))
+			Expect(logContext.Attempts).To(Equal(2))
+		})
+
+		It("retries idempotent requests without a body that fail after being written", func() {
+			transport.Results = []fakeResult{
+				{Err: errors.New("connection reset"), Wrote: true},
+				{StatusCode: http.StatusOK},
+			}
+
+			writer := forward(http.MethodGet, "")
+
+			Expect(writer.Code).To(Equal(http.StatusOK))
+			Expect(logContext.Attempts).To(Equal(2))
+		})
+
+		It("does not retry non-idempotent requests that fail after being written", func() {
+			transport.Results = []fakeResult{
+				{Err: errors.New("connection reset"), Wrote: true},
+				{StatusCode: http.StatusOK},
+			}
+
+			forward(http.MethodPost, "")
+
+			Expect(logContext.Attempts).To(Equal(1))
+			Expect(transport.Results).To(HaveLen(1))
+		})
+
+		It("does not retry requests with a body that has been sent", func() {
+			transport.Results = []fakeResult{
+				{Err: errors.New("connection reset"), Wrote: true},
+				{StatusCode: http.StatusOK},
+			}
+
+			forward(http.MethodPut, "<body>")
+
+			Expect(logContext.Attempts).To(Equal(1))
+			Expect(transport.Bodies).To(Equal([]string{"<body>"}))
+		})
+
+		It("retries idempotent requests that receive a retryable status code", func() {
+			transport.Results = []fakeResult{
+				{StatusCode: http.StatusServiceUnavailable, Wrote: true},
+				{StatusCode: http.StatusOK, Wrote: true},
+			}
+
+			writer := forward(http.MethodGet, "")
+
+			Expect(writer.Code).To(Equal(http.StatusOK))
+			Expect(logContext.Attempts).To(Equal(2))
+		})
+
+		It("does not retry other status codes", func() {
+			transport.Results = []fakeResult{
+				{StatusCode: http.StatusInternalServerError, Wrote: true},
+				{StatusCode: http.StatusOK, Wrote: true},
+			}
+
+			writer := forward(http.MethodGet, "")
+
+			Expect(writer.Code).To(Equal(http.StatusInternalServerError))
+			Expect(logContext.Attempts).To(Equal(1))
+		})
+
+		It("gives up after the maximum number of attempts", func() {
+			transport.Results = []fakeResult{
+				{StatusCode: http.StatusServiceUnavailable, Wrote: true},
+				{StatusCode: http.StatusServiceUnavailable, Wrote: true},
+				{StatusCode: http.StatusServiceUnavailable, Wrote: true},
+				{StatusCode: http.StatusOK, Wrote: true},
+			}
+
+			writer := forward(http.MethodGet, "")
+
+			Expect(writer.Code).To(Equal(http.StatusServiceUnavailable))
+			Expect(logContext.Attempts).To(Equal(3))
+		})
+
+		It("uses the default budget if the budget is invalid", func() {
+			subject.Retry.Budget = -100
+			transport.Results = []fakeResult{
+				{Err: errors.New("connection refused")},
+				{StatusCode: http.StatusOK, Wrote: true},
+			}
+
+			writer := forward(http.MethodGet, "")
+
+			Expect(writer.Code).To(Equal(http.StatusOK))
+			Expect(logContext.Attempts).To(Equal(2))
+		})
+
+		It("sends the body on the retried attempt", func() {
+			transport.Results = []fakeResult{
+				{Err: errors.New("connection refused")},
+				{StatusCode: http.StatusOK, Wrote: true},
+			}
+
+			forward(http.MethodPost, "<body>")
+
+			Expect(transport.Bodies).To(Equal([]string{"<body>"}))
+		})
+	})
+})
@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/icecave/honeycomb/backend"
+)
+
+// securityHeaders returns the security headers for the response to request,
+// which is forwarded to logContext.Endpoint. defaults are overridden by the
+// endpoint's security headers.
+func securityHeaders(
+	defaults backend.SecurityHeaders,
+	request *http.Request,
+	logContext *LogContext,
+) backend.SecurityHeaders {
+	h := defaults
+	if logContext.Endpoint != nil {
+		h = logContext.Endpoint.SecurityHeaders.Merge(defaults)
+	}
+
+	// Browsers ignore HSTS policies received over plain HTTP, as they could
+	// have been injected by an attacker.
+	if request.TLS == nil {
+		h.HSTS = nil
+	}
+
+	return h
+}
+
+// writeSecurityHeaders adds the security headers described by h to headers.
+// Headers that are already present are replaced, unless h preserves them.
+func writeSecurityHeaders(headers http.Header, h backend.SecurityHeaders) {
+	set := func(name, value string) {
+		if value == "" || value == backend.HeaderDisabled {
+			return
+		}
+
+		if h.PreservesHeaders() && len(headers.Values(name)) != 0 {
+			return
+		}
+
+		headers.Set(name, value)
+	}
+
+	if h.HSTS != nil && h.HSTS.MaxAge >= 0 {
+		set("Strict-Transport-Security", h.HSTS.String())
+	}
+
+	set("Content-Security-Policy", h.ContentSecurityPolicy)
+	set("X-Frame-Options", h.FrameOptions)
+	set("X-Content-Type-Options", h.ContentTypeOptions)
+	set("Referrer-Policy", h.ReferrerPolicy)
+	set("Permissions-Policy", h.PermissionsPolicy)
+}
@@ -0,0 +1,104 @@
+package proxy_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/icecave/honeycomb/backend"
+	"github.com/icecave/honeycomb/proxy"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HTTPProxy security headers", func() {
+	var (
+		server   *httptest.Server
+		endpoint *backend.Endpoint
+		subject  *proxy.HTTPProxy
+	)
+
+	forward := func(url string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("GET", url, nil)
+		upstreamRequest := httptest.NewRequest("GET", server.URL+"/", nil)
+		upstreamRequest.RequestURI = ""
+
+		logContext := &proxy.LogContext{Request: request, Endpoint: endpoint}
+		writer := httptest.NewRecorder()
+		err := subject.Forward(writer, request, upstreamRequest, logContext)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		return writer
+	}
+
+	BeforeEach(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+		}))
+
+		endpoint = &backend.Endpoint{}
+		subject = &proxy.HTTPProxy{
+			Transport: &http.Transport{},
+			SecurityHeaders: backend.SecurityHeaders{
+				HSTS:               &backend.HSTSPolicy{MaxAge: time.Hour, IncludeSubDomains: true},
+				FrameOptions:       "DENY",
+				ContentTypeOptions: "nosniff",
+			},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("adds the default security headers, replacing those set by the server", func() {
+		writer := forward("https://app.example.com/")
+
+		Expect(writer.Header().Get("Strict-Transport-Security")).To(Equal("max-age=3600; includeSubDomains"))
+		Expect(writer.Header().Values("X-Frame-Options")).To(Equal([]string{"DENY"}))
+		Expect(writer.Header().Get("X-Content-Type-Options")).To(Equal("nosniff"))
+		Expect(writer.Header()).NotTo(HaveKey("Content-Security-Policy"))
+	})
+
+	It("prefers the endpoint's security headers", func() {
+		endpoint.SecurityHeaders = backend.SecurityHeaders{
+			HSTS:                  &backend.HSTSPolicy{MaxAge: backend.HSTSDisabled},
+			ContentSecurityPolicy: "default-src 'self'",
+			ContentTypeOptions:    backend.HeaderDisabled,
+		}
+
+		writer := forward("https://app.example.com/")
+
+		Expect(writer.Header()).NotTo(HaveKey("Strict-Transport-Security"))
+		Expect(writer.Header()).NotTo(HaveKey("X-Content-Type-Options"))
+		Expect(writer.Header().Get("Content-Security-Policy")).To(Equal("default-src 'self'"))
+		Expect(writer.Header().Get("X-Frame-Options")).To(Equal("DENY"))
+	})
+
+	It("leaves headers set by the server untouched if configured to do so", func() {
+		preserve := true
+		endpoint.SecurityHeaders.Preserve = &preserve
+
+		writer := forward("https://app.example.com/")
+
+		Expect(writer.Header().Values("X-Frame-Options")).To(Equal([]string{"SAMEORIGIN"}))
+		Expect(writer.Header().Get("X-Content-Type-Options")).To(Equal("nosniff"))
+	})
+
+	It("replaces headers set by the server if the endpoint overrides the default preservation", func() {
+		preserve, override := true, false
+		subject.SecurityHeaders.Preserve = &preserve
+		endpoint.SecurityHeaders.Preserve = &override
+
+		writer := forward("https://app.example.com/")
+
+		Expect(writer.Header().Values("X-Frame-Options")).To(Equal([]string{"DENY"}))
+	})
+
+	It("does not send an HSTS policy over plain HTTP", func() {
+		writer := forward("http://app.example.com/")
+
+		Expect(writer.Header()).NotTo(HaveKey("Strict-Transport-Security"))
+		Expect(writer.Header().Get("X-Frame-Options")).To(Equal("DENY"))
+	})
+})
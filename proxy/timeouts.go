@@ -0,0 +1,214 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/icecave/honeycomb/backend"
+)
+
+// TimeoutError indicates that a phase of an upstream request took longer than
+// the allowed amount of time.
+type TimeoutError struct {
+	// Phase is the name of the phase that timed out, such as "dial" or
+	// "response header".
+	Phase    string
+	Duration time.Duration
+}
+
+func (err TimeoutError) Error() string {
+	return fmt.Sprintf("upstream %s timeout (%s)", err.Phase, err.Duration)
+}
+
+// Timeout returns true, it allows TimeoutError to satisfy net.Error.
+func (err TimeoutError) Timeout() bool {
+	return true
+}
+
+// Temporary returns true, it allows TimeoutError to satisfy net.Error.
+func (err TimeoutError) Temporary() bool {
+	return true
+}
+
+// timeoutTransport is an http.RoundTripper that enforces per-phase timeouts.
+type timeoutTransport struct {
+	Transport http.RoundTripper
+	Timeouts  backend.Timeouts
+}
+
+// RoundTrip sends request using the underlying transport. If a phase of the
+// request times out, it returns a TimeoutError.
+func (t timeoutTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(request.Context())
+	w := &watchdog{cancel: cancel}
+
+	trace := &httptrace.ClientTrace{
+		ConnectStart: func(string, string) {
+			w.Start("dial", t.Timeouts.Dial)
+		},
+		ConnectDone: func(string, string, error) {
+			w.Stop()
+		},
+		TLSHandshakeStart: func() {
+			w.Start("TLS handshake", t.Timeouts.TLSHandshake)
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			w.Stop()
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			w.Start("response header", t.Timeouts.ResponseHeader)
+		},
+		GotFirstResponseByte: func() {
+			w.Stop()
+		},
+	}
+
+	response, err := t.Transport.RoundTrip(
+		request.WithContext(httptrace.WithClientTrace(ctx, trace)),
+	)
+	w.Stop()
+
+	if err != nil {
+		cancel()
+
+		if e := w.Err(); e != nil {
+			return nil, e
+		}
+
+		return nil, err
+	}
+
+	response.Body = &idleTimeoutBody{
+		ReadCloser: response.Body,
+		watchdog:   w,
+		timeout:    t.Timeouts.Idle,
+	}
+
+	return response, nil
+}
+
+// watchdog cancels a context if a phase of a request does not complete in
+// time.
+type watchdog struct {
+	cancel func()
+
+	m          sync.Mutex
+	timer      *time.Timer
+	generation int
+	expired    error
+}
+
+// Start begins timing a new phase.
+func (w *watchdog) Start(phase string, timeout time.Duration) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	w.stop()
+
+	if timeout <= 0 {
+		return
+	}
+
+	gen := w.generation
+	w.timer = time.AfterFunc(timeout, func() {
+		w.m.Lock()
+		defer w.m.Unlock()
+
+		if gen == w.generation && w.expired == nil {
+			w.expired = TimeoutError{phase, timeout}
+			w.cancel()
+		}
+	})
+}
+
+// Stop stops timing the current phase.
+func (w *watchdog) Stop() {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	w.stop()
+}
+
+// stop stops timing the current phase. w.m must be locked.
+func (w *watchdog) stop() {
+	w.generation++
+
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+}
+
+// Err returns a TimeoutError if a phase has timed out.
+func (w *watchdog) Err() error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	return w.expired
+}
+
+// idleTimeoutBody is a response body that enforces an idle timeout between
+// reads.
+type idleTimeoutBody struct {
+	io.ReadCloser
+	watchdog *watchdog
+	timeout  time.Duration
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	b.watchdog.Start("idle", b.timeout)
+	n, err := b.ReadCloser.Read(p)
+	b.watchdog.Stop()
+
+	if err != nil && err != io.EOF {
+		if e := b.watchdog.Err(); e != nil {
+			return n, e
+		}
+	}
+
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.watchdog.Stop()
+	b.watchdog.cancel()
+	return b.ReadCloser.Close()
+}
+
+// totalTimeoutError returns a TimeoutError if err was caused by ctx reaching
+// its deadline, otherwise it returns err unchanged.
+func totalTimeoutError(ctx context.Context, timeout time.Duration, err error) error {
+	if err != nil && timeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return TimeoutError{"total", timeout}
+	}
+
+	return err
+}
+
+// isTimeout returns true if err is caused by a timeout.
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// statusCodeForError returns the HTTP status code used to report an error that
+// occurred while communicating with the upstream server.
+func statusCodeForError(err error) int {
+	if errors.As(err, &TimeoutError{}) {
+		return http.StatusGatewayTimeout
+	}
+
+	return http.StatusBadGateway
+}
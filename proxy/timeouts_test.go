@@ -0,0 +1,123 @@
+package proxy_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/icecave/honeycomb/backend"
+	"github.com/icecave/honeycomb/proxy"
+	"github.com/icecave/honeycomb/statuspage"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HTTPProxy timeouts", func() {
+	var (
+		server   *httptest.Server
+		release  chan struct{}
+		endpoint *backend.Endpoint
+		subject  *proxy.HTTPProxy
+	)
+
+	forward := func() (*httptest.ResponseRecorder, error) {
+		request := httptest.NewRequest("GET", server.URL+"/", nil)
+		request.RequestURI = ""
+
+		logContext := &proxy.LogContext{Request: request, Endpoint: endpoint}
+		writer := httptest.NewRecorder()
+		err := subject.Forward(writer, request, request, logContext)
+
+		return writer, err
+	}
+
+	BeforeEach(func() {
+		release = make(chan struct{})
+		endpoint = &backend.Endpoint{}
+		subject = &proxy.HTTPProxy{
+			Transport: &http.Transport{},
+		}
+	})
+
+	AfterEach(func() {
+		close(release)
+		server.Close()
+	})
+
+	Context("when the server is slow to respond", func() {
+		BeforeEach(func() {
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				<-release
+			}))
+		})
+
+		It("returns a gateway timeout error when the response header timeout is exceeded", func() {
+			subject.Timeouts.ResponseHeader = 20 * time.Millisecond
+
+			_, err := forward()
+			Expect(err).To(Equal(statuspage.Error{
+				Inner:      proxy.TimeoutError{Phase: "response header", Duration: 20 * time.Millisecond},
+				StatusCode: http.StatusGatewayTimeout,
+			}))
+			Expect(err).To(MatchError("upstream response header timeout (20ms)"))
+		})
+
+		It("returns a gateway timeout error when the total timeout is exceeded", func() {
+			subject.Timeouts.Total = 20 * time.Millisecond
+
+			_, err := forward()
+			Expect(err).To(Equal(statuspage.Error{
+				Inner:      proxy.TimeoutError{Phase: "total", Duration: 20 * time.Millisecond},
+				StatusCode: http.StatusGatewayTimeout,
+			}))
+		})
+
+		It("prefers the endpoint's timeouts", func() {
+			subject.Timeouts.ResponseHeader = time.Hour
+			endpoint.Timeouts.ResponseHeader = 20 * time.Millisecond
+
+			_, err := forward()
+			Expect(err).To(MatchError("upstream response header timeout (20ms)"))
+		})
+	})
+
+	Context("when the server stops sending the response body", func() {
+		BeforeEach(func() {
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("<partial>"))
+				w.(http.Flusher).Flush()
+				<-release
+			}))
+		})
+
+		It("returns an error when the idle timeout is exceeded", func() {
+			subject.Timeouts.Idle = 20 * time.Millisecond
+
+			writer, err := forward()
+			Expect(err).To(Equal(proxy.TimeoutError{Phase: "idle", Duration: 20 * time.Millisecond}))
+			Expect(writer.Code).To(Equal(http.StatusOK))
+			Expect(writer.Body.String()).To(Equal("<partial>"))
+		})
+	})
+
+	Context("when the server responds in time", func() {
+		BeforeEach(func() {
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("<ok>"))
+			}))
+		})
+
+		It("forwards the response", func() {
+			subject.Timeouts = backend.Timeouts{
+				Dial:           time.Second,
+				ResponseHeader: time.Second,
+				Idle:           time.Second,
+				Total:          time.Second,
+			}
+
+			writer, err := forward()
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(writer.Body.String()).To(Equal("<ok>"))
+		})
+	})
+})
@@ -0,0 +1,111 @@
+package proxy_test
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/icecave/honeycomb/backend"
+	"github.com/icecave/honeycomb/proxy"
+	"github.com/icecave/honeycomb/static"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Handler upgrades", func() {
+	var (
+		upstream *httptest.Server
+		frontend *httptest.Server
+		endpoint *backend.Endpoint
+		received chan http.Header
+	)
+
+	BeforeEach(func() {
+		received = make(chan http.Header, 1)
+
+		upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received <- r.Header
+
+			if r.Header.Get("Upgrade") == "" {
+				w.Write([]byte("not upgraded"))
+				return
+			}
+
+			conn, rw, _ := w.(http.Hijacker).Hijack()
+			defer conn.Close()
+
+			rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+			rw.WriteString("Connection: upgrade\r\n")
+			rw.WriteString("Upgrade: " + r.Header.Get("Upgrade") + "\r\n\r\n")
+			rw.Flush()
+
+			line, _ := rw.ReadString('\n')
+			rw.WriteString(line)
+			rw.Flush()
+		}))
+
+		u, _ := url.Parse(upstream.URL)
+		endpoint = &backend.Endpoint{
+			Address:          u.Host,
+			UpgradeProtocols: []string{"tcp"},
+		}
+
+		httpProxy := &proxy.HTTPProxy{Transport: &http.Transport{}}
+		wsProxy := &proxy.WebSocketProxy{Dialer: &proxy.BasicWebSocketDialer{}}
+
+		frontend = httptest.NewServer(&proxy.Handler{
+			Locator:                static.Locator{}.With("app.*", endpoint),
+			SecureHTTPProxy:        httpProxy,
+			SecureWebSocketProxy:   wsProxy,
+			InsecureHTTPProxy:      httpProxy,
+			InsecureWebSocketProxy: wsProxy,
+		})
+	})
+
+	AfterEach(func() {
+		frontend.Close()
+		upstream.Close()
+	})
+
+	upgrade := func(protocol string) (*bufio.Reader, net.Conn, *http.Response) {
+		conn, err := net.Dial("tcp", frontend.Listener.Addr().String())
+		Expect(err).ShouldNot(HaveOccurred())
+
+		conn.Write([]byte(
+			"GET / HTTP/1.1\r\n" +
+				"Host: app.example.com\r\n" +
+				"Connection: Upgrade\r\n" +
+				"Upgrade: " + protocol + "\r\n\r\n",
+		))
+
+		reader := bufio.NewReader(conn)
+		response, err := http.ReadResponse(reader, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		return reader, conn, response
+	}
+
+	It("tunnels upgrade protocols that the endpoint allows", func() {
+		reader, conn, response := upgrade("tcp")
+		defer conn.Close()
+
+		Expect(response.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+		Expect(response.Header.Get("Upgrade")).To(Equal("tcp"))
+		Expect((<-received).Get("Upgrade")).To(Equal("tcp"))
+
+		conn.Write([]byte("hello\n"))
+		line, err := reader.ReadString('\n')
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(line).To(Equal("hello\n"))
+	})
+
+	It("does not tunnel other upgrade protocols", func() {
+		_, conn, response := upgrade("irc")
+		defer conn.Close()
+
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+		Expect((<-received).Get("Upgrade")).To(BeEmpty())
+	})
+})
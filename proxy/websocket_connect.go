@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/icecave/honeycomb/backend"
+)
+
+// isWebSocketConnect checks whether request is an HTTP/2 extended CONNECT
+// request for a websocket, as per RFC 8441.
+func isWebSocketConnect(request *http.Request) bool {
+	return request.Method == http.MethodConnect &&
+		request.ProtoMajor == 2 &&
+		strings.EqualFold(request.Header.Get(":protocol"), "websocket")
+}
+
+// prepareWebSocketUpgrade modifies an upstream request that was made from an
+// extended CONNECT request so that it is an HTTP/1.1 websocket upgrade
+// request.
+func prepareWebSocketUpgrade(upstreamRequest *http.Request) {
+	upstreamRequest.Method = http.MethodGet
+	upstreamRequest.Proto = "HTTP/1.1"
+	upstreamRequest.ProtoMajor = 1
+	upstreamRequest.ProtoMinor = 1
+
+	// HTTP/2 clients do not send a key, as the stream itself proves that the
+	// server understands the websocket protocol, so a random key is used to
+	// satisfy the upstream server.
+	if upstreamRequest.Header.Get("Sec-WebSocket-Key") == "" {
+		key := make([]byte, 16)
+		rand.Read(key)
+		upstreamRequest.Header.Set(
+			"Sec-WebSocket-Key",
+			base64.StdEncoding.EncodeToString(key),
+		)
+	}
+}
+
+// acceptWebSocketConnect responds to an extended CONNECT request once the
+// upstream server has accepted the HTTP/1.1 upgrade. It returns a stream that
+// reads websocket frames from the client and writes them to the client.
+func acceptWebSocketConnect(
+	writer http.ResponseWriter,
+	request *http.Request,
+	upstreamResponse *http.Response,
+	security backend.SecurityHeaders,
+) *webSocketStream {
+	response := *upstreamResponse
+	response.StatusCode = http.StatusOK
+	response.Header = response.Header.Clone()
+	response.Header.Del("Sec-Websocket-Accept")
+
+	writeResponseHeaders(writer, &response, false, security)
+
+	flusher, _ := writer.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	return &webSocketStream{
+		body:    request.Body,
+		writer:  writer,
+		flusher: flusher,
+	}
+}
+
+// webSocketStream is an io.ReadWriteCloser that reads from and writes to an
+// HTTP/2 stream.
+type webSocketStream struct {
+	body    io.ReadCloser
+	writer  io.Writer
+	flusher http.Flusher
+}
+
+func (s *webSocketStream) Read(p []byte) (int, error) {
+	return s.body.Read(p)
+}
+
+func (s *webSocketStream) Write(p []byte) (int, error) {
+	n, err := s.writer.Write(p)
+	if err == nil && s.flusher != nil {
+		s.flusher.Flush()
+	}
+
+	return n, err
+}
+
+func (s *webSocketStream) Close() error {
+	return s.body.Close()
+}
+
+// countingReader is an io.Reader that counts the number of bytes read.
+type countingReader struct {
+	io.Reader
+	count int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	atomic.AddInt64(&r.count, int64(n))
+	return n, err
+}
+
+// Count returns the number of bytes read so far.
+func (r *countingReader) Count() int64 {
+	return atomic.LoadInt64(&r.count)
+}
@@ -0,0 +1,172 @@
+package proxy_test
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/url"
+	"time"
+
+	"github.com/icecave/honeycomb/backend"
+	"github.com/icecave/honeycomb/proxy"
+	"github.com/icecave/honeycomb/statuspage"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// streamWriter is an http.ResponseWriter for an HTTP/2 stream, the body is
+// written to a pipe so that it can be read as it arrives.
+type streamWriter struct {
+	header http.Header
+	code   int
+	body   *io.PipeWriter
+}
+
+func (w *streamWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *streamWriter) WriteHeader(code int) {
+	w.code = code
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	return w.body.Write(p)
+}
+
+func (w *streamWriter) Flush() {}
+
+// stallingTransport is a round-tripper that sends the request headers, then
+// waits for the request to be canceled without responding.
+type stallingTransport struct{}
+
+func (stallingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if trace := httptrace.ContextClientTrace(request.Context()); trace != nil {
+		trace.WroteHeaders()
+	}
+
+	<-request.Context().Done()
+
+	return nil, request.Context().Err()
+}
+
+var _ = Describe("WebSocketProxy extended CONNECT", func() {
+	var (
+		server   *httptest.Server
+		upstream *http.Request
+		subject  *proxy.WebSocketProxy
+	)
+
+	BeforeEach(func() {
+		upstream = nil
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			upstream = r
+
+			conn, rw, _ := w.(http.Hijacker).Hijack()
+			defer conn.Close()
+
+			rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+			rw.WriteString("Connection: upgrade\r\n")
+			rw.WriteString("Upgrade: websocket\r\n")
+			rw.WriteString("Sec-WebSocket-Accept: <accept>\r\n\r\n")
+			rw.Flush()
+
+			// Echo a single line back to the client.
+			line, _ := rw.ReadString('\n')
+			rw.WriteString(line)
+			rw.Flush()
+		}))
+
+		subject = &proxy.WebSocketProxy{
+			Dialer: &proxy.BasicWebSocketDialer{},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("bridges the HTTP/2 stream to an HTTP/1.1 websocket", func() {
+		u, _ := url.Parse(server.URL)
+
+		clientBody, clientWriter := io.Pipe()
+		responseBody, responseWriter := io.Pipe()
+
+		request := httptest.NewRequest("CONNECT", "https://app.example.com/chat", clientBody)
+		request.Proto = "HTTP/2.0"
+		request.ProtoMajor = 2
+		request.ProtoMinor = 0
+		request.Header.Set(":protocol", "websocket")
+		request.Header.Set("Sec-WebSocket-Version", "13")
+
+		upstreamRequest := request.Clone(request.Context())
+		upstreamRequest.URL.Scheme = "ws"
+		upstreamRequest.URL.Host = u.Host
+		upstreamRequest.Header.Del(":protocol")
+		upstreamRequest.Header.Set("Host", "app.example.com")
+
+		writer := &streamWriter{header: http.Header{}, body: responseWriter}
+		logContext := &proxy.LogContext{Request: request, IsWebSocket: true}
+
+		done := make(chan error)
+		go func() {
+			done <- subject.Forward(writer, request, upstreamRequest, logContext)
+		}()
+
+		clientWriter.Write([]byte("hello\n"))
+
+		line, err := bufio.NewReader(responseBody).ReadString('\n')
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(line).To(Equal("hello\n"))
+
+		clientWriter.Close()
+		Eventually(done).Should(Receive(BeNil()))
+
+		Expect(upstream.Method).To(Equal("GET"))
+		Expect(upstream.Proto).To(Equal("HTTP/1.1"))
+		Expect(upstream.Header.Get("Upgrade")).To(Equal("websocket"))
+		Expect(upstream.Header.Get("Sec-WebSocket-Key")).ToNot(BeEmpty())
+
+		Expect(writer.code).To(Equal(http.StatusOK))
+		Expect(writer.header.Get("Sec-WebSocket-Accept")).To(BeEmpty())
+		Expect(writer.header.Get("Upgrade")).To(BeEmpty())
+		Expect(logContext.StatusCode).To(Equal(http.StatusOK))
+	})
+
+	It("applies the response header timeout to HTTP/2 upstream servers", func() {
+		subject.HTTP2Transport = stallingTransport{}
+
+		request := httptest.NewRequest("CONNECT", "https://app.example.com/chat", http.NoBody)
+		request.Proto = "HTTP/2.0"
+		request.ProtoMajor = 2
+		request.ProtoMinor = 0
+		request.Header.Set(":protocol", "websocket")
+
+		upstreamRequest := request.Clone(request.Context())
+		upstreamRequest.URL.Scheme = "wss"
+		upstreamRequest.URL.Host = "upstream.example.com"
+		upstreamRequest.Header.Del(":protocol")
+
+		logContext := &proxy.LogContext{
+			Request:     request,
+			IsWebSocket: true,
+			Endpoint: &backend.Endpoint{
+				Protocol: backend.ProtocolHTTP2,
+				Timeouts: backend.Timeouts{ResponseHeader: 10 * time.Millisecond},
+			},
+		}
+
+		err := subject.Forward(httptest.NewRecorder(), request, upstreamRequest, logContext)
+
+		Expect(err).To(Equal(statuspage.Error{
+			Inner: proxy.TimeoutError{
+				Phase:    "response header",
+				Duration: 10 * time.Millisecond,
+			},
+			StatusCode: http.StatusGatewayTimeout,
+		}))
+	})
+})
@@ -0,0 +1,371 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	opcodeContinuation = 0x0
+	opcodeText         = 0x1
+	opcodeBinary       = 0x2
+	opcodeClose        = 0x8
+	opcodePing         = 0x9
+	opcodePong         = 0xA
+)
+
+const (
+	// closeProtocolError is the close code sent when a protocol violation is
+	// detected.
+	closeProtocolError = 1002
+
+	// closeMessageTooBig is the close code sent when a message exceeds the
+	// maximum message size.
+	closeMessageTooBig = 1009
+
+	// maxControlPayload is the maximum payload length of a control frame.
+	maxControlPayload = 125
+)
+
+// pingPayload is the payload of the ping frames sent by the proxy. Pongs with
+// this payload are responses to the proxy's pings, and are not forwarded.
+var pingPayload = []byte("honeycomb")
+
+// WebSocketError indicates that one side of a websocket connection violated
+// the websocket protocol, or sent a message that was too large. The proxy
+// closes the connection when such an error occurs.
+type WebSocketError struct {
+	// Side is the side of the connection that caused the error, either
+	// "client" or "upstream".
+	Side string
+
+	// Code is the close code that was sent to both sides.
+	Code int
+
+	// Reason describes the error.
+	Reason string
+}
+
+func (err WebSocketError) Error() string {
+	return fmt.Sprintf("websocket %s error (%d): %s", err.Side, err.Code, err.Reason)
+}
+
+// frameWriter writes websocket frames to one side of a connection. It
+// serializes writes so that control frames are never written in the middle of
+// a forwarded frame.
+type frameWriter struct {
+	writer io.Writer
+
+	// mask is true if frames written by the proxy must be masked, which is
+	// the case for frames sent to the upstream server.
+	mask bool
+
+	m sync.Mutex
+}
+
+// Ping writes a ping frame.
+func (w *frameWriter) Ping() error {
+	return w.writeControl(opcodePing, pingPayload)
+}
+
+// Close writes a close frame with the given code and reason.
+func (w *frameWriter) Close(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+
+	if len(payload) > maxControlPayload {
+		payload = payload[:maxControlPayload]
+	}
+
+	return w.writeControl(opcodeClose, payload)
+}
+
+// writeControl writes a control frame with the given opcode and payload.
+func (w *frameWriter) writeControl(opcode byte, payload []byte) error {
+	frame := []byte{0x80 | opcode, byte(len(payload))}
+	payload = append([]byte(nil), payload...)
+
+	if w.mask {
+		key := make([]byte, 4)
+		rand.Read(key)
+
+		frame[1] |= 0x80
+		frame = append(frame, key...)
+		maskBytes(key, payload)
+	}
+
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	_, err := w.writer.Write(append(frame, payload...))
+	return err
+}
+
+// write writes a frame header, followed by length bytes of payload from
+// reader.
+func (w *frameWriter) write(header []byte, reader io.Reader, length int64) error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if _, err := w.writer.Write(header); err != nil {
+		return err
+	}
+
+	n, err := io.CopyN(w.writer, reader, length)
+	if err == io.EOF && n < length {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// frameCopier copies websocket frames sent by one side of a connection to the
+// other side, validating and counting them as it goes.
+type frameCopier struct {
+	// Side is the side of the connection that the frames are read from, either
+	// "client" or "upstream".
+	Side string
+
+	// Writer writes frames to the other side of the connection, and Reply
+	// writes frames back to the side that the frames are read from.
+	Writer *frameWriter
+	Reply  *frameWriter
+
+	// Masked is true if the frames read must be masked, which is the case for
+	// frames sent by the client.
+	Masked bool
+
+	// Extensions is true if a websocket extension has been negotiated, in
+	// which case the reserved bits may be used.
+	Extensions bool
+
+	// MaxMessageSize is the maximum size of a (possibly fragmented) message.
+	// A zero value indicates that there is no limit.
+	MaxMessageSize int64
+
+	// Counts, if non-nil, is updated with each message that is copied, and
+	// Metrics with any close frames.
+	Counts  *FrameCounts
+	Metrics *WebSocketMetrics
+
+	// fragmented is true if a fragmented message is in progress, and
+	// messageSize is the size of that message so far.
+	fragmented  bool
+	messageSize int64
+}
+
+// Copy copies frames from reader until EOF is reached, discarding the pongs
+// sent in response to the proxy's pings.
+//
+// If a frame violates the protocol, or the message size limit, a close frame
+// is sent to both sides and a WebSocketError is returned.
+func (c *frameCopier) Copy(reader io.Reader) (int64, error) {
+	var total int64
+
+	for {
+		header, length, err := readFrameHeader(reader)
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+
+			return total, err
+		}
+
+		total += int64(len(header)) + length
+
+		if err := c.check(header, length); err != nil {
+			c.Writer.Close(err.Code, err.Reason)
+			c.Reply.Close(err.Code, err.Reason)
+			return total, *err
+		}
+
+		opcode := header[0] & 0x0f
+		isMasked := header[1]&0x80 != 0
+
+		if opcode < opcodeClose {
+			c.count(opcode)
+
+			if err := c.Writer.write(header, reader, length); err != nil {
+				return total, err
+			}
+
+			continue
+		}
+
+		// Control frames are small enough to be read entirely so that their
+		// payload can be inspected.
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return total, unexpectedEOF(err)
+		}
+
+		unmasked := append([]byte(nil), payload...)
+		if isMasked {
+			maskBytes(header[len(header)-4:], unmasked)
+		}
+
+		if opcode == opcodePong && bytes.Equal(unmasked, pingPayload) {
+			continue
+		}
+
+		c.count(opcode)
+
+		if opcode == opcodeClose && c.Metrics != nil {
+			c.Metrics.recordClose(c.Side, unmasked)
+		}
+
+		if err := c.Writer.write(header, bytes.NewReader(payload), length); err != nil {
+			return total, err
+		}
+	}
+}
+
+// check returns an error if the frame with the given header violates the
+// protocol or the message size limit.
+func (c *frameCopier) check(header []byte, length int64) *WebSocketError {
+	fail := func(code int, reason string, v ...interface{}) *WebSocketError {
+		return &WebSocketError{c.Side, code, fmt.Sprintf(reason, v...)}
+	}
+
+	isFinal := header[0]&0x80 != 0
+	reserved := header[0] & 0x70
+	opcode := header[0] & 0x0f
+	isMasked := header[1]&0x80 != 0
+
+	if reserved != 0 && !c.Extensions {
+		return fail(closeProtocolError, "reserved bits set without a negotiated extension")
+	}
+
+	if isMasked != c.Masked {
+		if c.Masked {
+			return fail(closeProtocolError, "frame is not masked")
+		}
+
+		return fail(closeProtocolError, "frame is masked")
+	}
+
+	switch opcode {
+	case opcodeContinuation:
+		if !c.fragmented {
+			return fail(closeProtocolError, "unexpected continuation frame")
+		}
+
+	case opcodeText, opcodeBinary:
+		if c.fragmented {
+			return fail(closeProtocolError, "new message started before the previous message was finished")
+		}
+
+		c.messageSize = 0
+
+	case opcodeClose, opcodePing, opcodePong:
+		if !isFinal {
+			return fail(closeProtocolError, "fragmented control frame")
+		}
+
+		if length > maxControlPayload {
+			return fail(closeProtocolError, "control frame payload is too large (%d bytes)", length)
+		}
+
+		if opcode == opcodeClose && length == 1 {
+			return fail(closeProtocolError, "close frame payload is too short")
+		}
+
+		return nil
+
+	default:
+		return fail(closeProtocolError, "unknown opcode (%d)", opcode)
+	}
+
+	c.fragmented = !isFinal
+	c.messageSize += length
+
+	if c.MaxMessageSize > 0 && c.messageSize > c.MaxMessageSize {
+		return fail(closeMessageTooBig, "message exceeds the maximum size of %d bytes", c.MaxMessageSize)
+	}
+
+	return nil
+}
+
+// count records a message with the given opcode. Continuation frames are not
+// counted, as they are part of a message that has already been counted.
+func (c *frameCopier) count(opcode byte) {
+	if c.Counts == nil {
+		return
+	}
+
+	switch opcode {
+	case opcodeText:
+		c.Counts.Text++
+	case opcodeBinary:
+		c.Counts.Binary++
+	case opcodeClose:
+		c.Counts.Close++
+	case opcodePing:
+		c.Counts.Ping++
+	case opcodePong:
+		c.Counts.Pong++
+	}
+}
+
+// readFrameHeader reads a websocket frame header from reader. It returns the
+// raw header, including the masking key if present, and the payload length.
+// It returns io.EOF only if reader is exhausted before the frame begins.
+func readFrameHeader(reader io.Reader) ([]byte, int64, error) {
+	header := make([]byte, 2, 14)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, 0, err
+	}
+
+	length := int64(header[1] & 0x7f)
+	extra := 0
+
+	switch length {
+	case 126:
+		extra = 2
+	case 127:
+		extra = 8
+	}
+
+	if header[1]&0x80 != 0 {
+		extra += 4
+	}
+
+	if extra > 0 {
+		header = header[:2+extra]
+		if _, err := io.ReadFull(reader, header[2:]); err != nil {
+			return nil, 0, unexpectedEOF(err)
+		}
+	}
+
+	switch length {
+	case 126:
+		length = int64(binary.BigEndian.Uint16(header[2:4]))
+	case 127:
+		length = int64(binary.BigEndian.Uint64(header[2:10]) &^ (1 << 63))
+	}
+
+	return header, length, nil
+}
+
+// maskBytes applies the websocket masking algorithm to data in place. It is
+// its own inverse.
+func maskBytes(key []byte, data []byte) {
+	for i := range data {
+		data[i] ^= key[i%4]
+	}
+}
+
+// unexpectedEOF converts io.EOF to io.ErrUnexpectedEOF.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
@@ -0,0 +1,243 @@
+package proxy_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/icecave/honeycomb/backend"
+	"github.com/icecave/honeycomb/proxy"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// serveWebSocketEcho is an HTTP handler that accepts a websocket upgrade and
+// echoes each frame back to the client. It only supports payloads of up to 125
+// bytes.
+func serveWebSocketEcho(w http.ResponseWriter, r *http.Request) {
+	conn, rw, _ := w.(http.Hijacker).Hijack()
+	defer conn.Close()
+
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	rw.WriteString("Connection: upgrade\r\n")
+	rw.WriteString("Upgrade: websocket\r\n\r\n")
+	rw.Flush()
+
+	for {
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(rw, header); err != nil {
+			return
+		}
+
+		var key []byte
+		if header[1]&0x80 != 0 {
+			key = make([]byte, 4)
+			io.ReadFull(rw, key)
+		}
+
+		payload := make([]byte, header[1]&0x7f)
+		io.ReadFull(rw, payload)
+
+		for i := range payload {
+			if key != nil {
+				payload[i] ^= key[i%4]
+			}
+		}
+
+		rw.Write([]byte{header[0], byte(len(payload))})
+		rw.Write(payload)
+		rw.Flush()
+
+		if header[0]&0x0f == 0x8 {
+			return
+		}
+	}
+}
+
+// clientFrame returns a final, masked websocket frame, as sent by a client.
+func clientFrame(opcode byte, payload string) []byte {
+	return fragment(0x80|opcode, payload)
+}
+
+// fragment returns a masked websocket frame with the given first byte.
+func fragment(b byte, payload string) []byte {
+	key := []byte{1, 2, 3, 4}
+	f := append([]byte{b, 0x80 | byte(len(payload))}, key...)
+
+	for i := range payload {
+		f = append(f, payload[i]^key[i%4])
+	}
+
+	return f
+}
+
+// readServerFrame reads an unmasked websocket frame with a short payload.
+func readServerFrame(reader io.Reader) (byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return 0, nil, err
+	}
+
+	payload := make([]byte, header[1]&0x7f)
+	_, err := io.ReadFull(reader, payload)
+
+	return header[0] & 0x0f, payload, err
+}
+
+var _ = Describe("WebSocketProxy frame inspection", func() {
+	type result struct {
+		LogContext *proxy.LogContext
+		Err        error
+	}
+
+	var (
+		upstream *httptest.Server
+		frontend *httptest.Server
+		endpoint *backend.Endpoint
+		results  chan result
+		logs     *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		upstream = httptest.NewServer(http.HandlerFunc(serveWebSocketEcho))
+
+		u, _ := url.Parse(upstream.URL)
+		endpoint = &backend.Endpoint{
+			Address:   u.Host,
+			WebSocket: backend.WebSocketLimits{Inspect: true},
+		}
+
+		results = make(chan result, 1)
+		logs = &bytes.Buffer{}
+		subject := &proxy.WebSocketProxy{Dialer: &proxy.BasicWebSocketDialer{}}
+
+		frontend = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			upstreamRequest := r.Clone(r.Context())
+			upstreamRequest.URL.Scheme = "ws"
+			upstreamRequest.URL.Host = u.Host
+			upstreamRequest.Header.Set("Host", r.Host)
+
+			logContext := &proxy.LogContext{
+				Logger:      log.New(logs, "", 0),
+				Request:     r,
+				Endpoint:    endpoint,
+				IsWebSocket: true,
+			}
+			logContext.Metrics.Start()
+
+			err := subject.Forward(w, r, upstreamRequest, logContext)
+			results <- result{logContext, err}
+		}))
+	})
+
+	AfterEach(func() {
+		frontend.Close()
+		upstream.Close()
+	})
+
+	connect := func() (*bufio.Reader, net.Conn) {
+		conn, err := net.Dial("tcp", frontend.Listener.Addr().String())
+		Expect(err).ShouldNot(HaveOccurred())
+
+		conn.Write([]byte(
+			"GET / HTTP/1.1\r\n" +
+				"Host: app.example.com\r\n" +
+				"Connection: Upgrade\r\n" +
+				"Upgrade: websocket\r\n\r\n",
+		))
+
+		reader := bufio.NewReader(conn)
+		response, err := http.ReadResponse(reader, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+		return reader, conn
+	}
+
+	It("counts messages and records the close status", func() {
+		reader, conn := connect()
+		defer conn.Close()
+
+		conn.Write(clientFrame(0x1, "hello"))
+		conn.Write(clientFrame(0x2, "\x00\x01"))
+		conn.Write(clientFrame(0x8, "\x03\xe8bye"))
+
+		for {
+			if _, _, err := readServerFrame(reader); err != nil {
+				break
+			}
+		}
+		conn.Close()
+
+		var r result
+		Eventually(results).Should(Receive(&r))
+		Expect(r.Err).ShouldNot(HaveOccurred())
+
+		ws := r.LogContext.Metrics.WebSocket
+		Expect(ws.In).To(Equal(proxy.FrameCounts{Text: 1, Binary: 1, Close: 1}))
+		Expect(ws.Out).To(Equal(proxy.FrameCounts{Text: 1, Binary: 1, Close: 1}))
+		Expect(ws.ClosedBy).To(Equal("client"))
+		Expect(ws.CloseCode).To(Equal(1000))
+		Expect(ws.CloseReason).To(Equal("bye"))
+
+		r.LogContext.Log(r.Err)
+		Expect(logs.String()).To(ContainSubstring(" m/2:2 c/client/1000/bye\n"))
+	})
+
+	It("closes the connection when the client violates the protocol", func() {
+		reader, conn := connect()
+		defer conn.Close()
+
+		// Client frames must be masked.
+		conn.Write([]byte("\x81\x05hello"))
+
+		opcode, payload, err := readServerFrame(reader)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(opcode).To(BeEquivalentTo(0x8))
+		Expect(binary.BigEndian.Uint16(payload)).To(BeEquivalentTo(1002))
+
+		var r result
+		Eventually(results).Should(Receive(&r))
+		Expect(r.Err).To(Equal(proxy.WebSocketError{
+			Side:   "client",
+			Code:   1002,
+			Reason: "frame is not masked",
+		}))
+	})
+
+	It("closes the connection when a message exceeds the maximum size", func() {
+		endpoint.WebSocket.MaxMessageSize = 4
+
+		reader, conn := connect()
+		defer conn.Close()
+
+		conn.Write(fragment(0x01, "he"))
+		conn.Write(fragment(0x80, "llo"))
+
+		// The echoed fragment may arrive before or after the close frame.
+		for {
+			opcode, payload, err := readServerFrame(reader)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			if opcode == 0x8 {
+				Expect(binary.BigEndian.Uint16(payload)).To(BeEquivalentTo(1009))
+				break
+			}
+		}
+
+		var r result
+		Eventually(results).Should(Receive(&r))
+		Expect(r.Err).To(MatchError(
+			"websocket client error (1009): message exceeds the maximum size of 4 bytes",
+		))
+	})
+})
@@ -0,0 +1,198 @@
+package proxy_test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/icecave/honeycomb/backend"
+	"github.com/icecave/honeycomb/proxy"
+	"github.com/icecave/honeycomb/static"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WebSocketProxy limits", func() {
+	var (
+		upstream *httptest.Server
+		frontend *httptest.Server
+		endpoint *backend.Endpoint
+		limiter  *proxy.ConnectionLimiter
+	)
+
+	BeforeEach(func() {
+		upstream = httptest.NewServer(http.HandlerFunc(serveWebSocketEcho))
+
+		u, _ := url.Parse(upstream.URL)
+		endpoint = &backend.Endpoint{Address: u.Host}
+		limiter = &proxy.ConnectionLimiter{}
+
+		httpProxy := &proxy.HTTPProxy{Transport: &http.Transport{}}
+		wsProxy := &proxy.WebSocketProxy{
+			Dialer:            &proxy.BasicWebSocketDialer{},
+			ConnectionLimiter: limiter,
+		}
+
+		frontend = httptest.NewServer(&proxy.Handler{
+			Locator:                static.Locator{}.With("app.*", endpoint),
+			SecureHTTPProxy:        httpProxy,
+			SecureWebSocketProxy:   wsProxy,
+			InsecureHTTPProxy:      httpProxy,
+			InsecureWebSocketProxy: wsProxy,
+		})
+	})
+
+	AfterEach(func() {
+		frontend.Close()
+		upstream.Close()
+	})
+
+	connect := func() (*bufio.Reader, net.Conn, *http.Response) {
+		conn, err := net.Dial("tcp", frontend.Listener.Addr().String())
+		Expect(err).ShouldNot(HaveOccurred())
+
+		conn.Write([]byte(
+			"GET / HTTP/1.1\r\n" +
+				"Host: app.example.com\r\n" +
+				"Connection: Upgrade\r\n" +
+				"Upgrade: websocket\r\n\r\n",
+		))
+
+		reader := bufio.NewReader(conn)
+		response, err := http.ReadResponse(reader, nil)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		return reader, conn, response
+	}
+
+	Context("when the number of connections is limited", func() {
+		It("responds with 429 when the per-client limit is exceeded", func() {
+			endpoint.WebSocket.MaxConnectionsPerClient = 1
+
+			_, conn, response := connect()
+			defer conn.Close()
+			Expect(response.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+
+			_, conn2, response := connect()
+			defer conn2.Close()
+			Expect(response.StatusCode).To(Equal(http.StatusTooManyRequests))
+		})
+
+		It("responds with 503 when the endpoint's limit is exceeded", func() {
+			endpoint.WebSocket.MaxConnections = 1
+
+			_, conn, response := connect()
+			defer conn.Close()
+			Expect(response.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+
+			_, conn2, response := connect()
+			defer conn2.Close()
+			Expect(response.StatusCode).To(Equal(http.StatusServiceUnavailable))
+		})
+
+		It("counts the connections to all of the route's alternatives", func() {
+			other := httptest.NewServer(http.HandlerFunc(serveWebSocketEcho))
+			defer other.Close()
+
+			u, _ := url.Parse(other.URL)
+			limits := backend.WebSocketLimits{MaxConnections: 1}
+			endpoint.Description = "app"
+			endpoint.Alternatives = []*backend.Endpoint{
+				{Address: endpoint.Address, Weight: 1, WebSocket: limits},
+				{Address: u.Host, Weight: 1, WebSocket: limits},
+			}
+			endpoint.Address = ""
+
+			accepted := 0
+			for i := 0; i < 10; i++ {
+				_, conn, response := connect()
+				defer conn.Close()
+
+				if response.StatusCode == http.StatusSwitchingProtocols {
+					accepted++
+				} else {
+					Expect(response.StatusCode).To(Equal(http.StatusServiceUnavailable))
+				}
+			}
+
+			Expect(accepted).To(Equal(1))
+			Expect(limiter.Count(endpoint)).To(Equal(1))
+		})
+
+		It("allows new connections once existing connections are closed", func() {
+			endpoint.WebSocket.MaxConnections = 1
+
+			_, conn, response := connect()
+			Expect(response.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+			Expect(limiter.Count(endpoint)).To(Equal(1))
+
+			conn.Close()
+			Eventually(func() int { return limiter.Count(endpoint) }).Should(Equal(0))
+
+			_, conn, response = connect()
+			defer conn.Close()
+			Expect(response.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+		})
+	})
+
+	It("closes connections that exceed the idle timeout", func() {
+		endpoint.WebSocket.IdleTimeout = 50 * time.Millisecond
+
+		reader, conn, response := connect()
+		defer conn.Close()
+		Expect(response.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err := reader.ReadByte()
+		Expect(err).To(Equal(io.EOF))
+	})
+
+	It("closes connections that exceed the maximum lifetime", func() {
+		endpoint.WebSocket.MaxLifetime = 50 * time.Millisecond
+
+		reader, conn, response := connect()
+		defer conn.Close()
+		Expect(response.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err := reader.ReadByte()
+		Expect(err).To(Equal(io.EOF))
+	})
+
+	Context("when pings are enabled", func() {
+		It("sends pings to the client", func() {
+			endpoint.WebSocket.PingInterval = 10 * time.Millisecond
+
+			reader, conn, response := connect()
+			defer conn.Close()
+			Expect(response.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			header := make([]byte, 2)
+			_, err := io.ReadFull(reader, header)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(header[0] & 0x0f).To(BeEquivalentTo(0x9))
+		})
+
+		It("does not forward the responses to the proxy's pings", func() {
+			endpoint.WebSocket.PingInterval = time.Hour
+
+			reader, conn, response := connect()
+			defer conn.Close()
+			Expect(response.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+
+			conn.Write(clientFrame(0xA, "honeycomb"))
+			conn.Write(clientFrame(0x1, "hello"))
+
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			echoed := make([]byte, 7)
+			_, err := io.ReadFull(reader, echoed)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(echoed).To(Equal([]byte("\x81\x05hello")))
+		})
+	})
+})
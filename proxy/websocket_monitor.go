@@ -0,0 +1,181 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/icecave/honeycomb/backend"
+)
+
+// connectionMonitor enforces the idle timeout and maximum lifetime of an
+// upgraded connection, and sends pings to both sides if required.
+type connectionMonitor struct {
+	Limits backend.WebSocketLimits
+
+	// Close is called to close both sides of the connection when a limit is
+	// exceeded.
+	Close func()
+
+	// ClientPinger and UpstreamPinger, if non-nil, are used to send pings to
+	// the client and the upstream server, respectively.
+	ClientPinger   *frameWriter
+	UpstreamPinger *frameWriter
+
+	clientActivity   int64 // unix nanoseconds, atomic
+	upstreamActivity int64 // unix nanoseconds, atomic
+
+	once sync.Once
+	stop chan struct{}
+
+	m   sync.Mutex
+	err error
+}
+
+// Start begins monitoring the connection.
+func (m *connectionMonitor) Start() {
+	now := time.Now().UnixNano()
+	m.clientActivity = now
+	m.upstreamActivity = now
+	m.stop = make(chan struct{})
+
+	go m.run()
+}
+
+// Stop stops monitoring the connection. It returns an error describing the
+// limit that was exceeded, if any.
+func (m *connectionMonitor) Stop() error {
+	m.once.Do(func() { close(m.stop) })
+
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	return m.err
+}
+
+// ClientReader returns a reader that records activity from the client. If
+// there is no idle timeout, r is returned unchanged.
+func (m *connectionMonitor) ClientReader(r io.Reader) io.Reader {
+	if m.Limits.IdleTimeout == 0 {
+		return r
+	}
+
+	return &activityReader{r, &m.clientActivity}
+}
+
+// UpstreamReader returns a reader that records activity from the upstream
+// server. If there is no idle timeout, r is returned unchanged.
+func (m *connectionMonitor) UpstreamReader(r io.Reader) io.Reader {
+	if m.Limits.IdleTimeout == 0 {
+		return r
+	}
+
+	return &activityReader{r, &m.upstreamActivity}
+}
+
+func (m *connectionMonitor) run() {
+	var idle, ping, lifetime <-chan time.Time
+
+	if m.Limits.IdleTimeout > 0 {
+		t := time.NewTicker(m.Limits.IdleTimeout / 4)
+		defer t.Stop()
+		idle = t.C
+	}
+
+	if m.Limits.PingInterval > 0 && (m.ClientPinger != nil || m.UpstreamPinger != nil) {
+		t := time.NewTicker(m.Limits.PingInterval)
+		defer t.Stop()
+		ping = t.C
+	}
+
+	if m.Limits.MaxLifetime > 0 {
+		t := time.NewTimer(m.Limits.MaxLifetime)
+		defer t.Stop()
+		lifetime = t.C
+	}
+
+	for {
+		select {
+		case <-m.stop:
+			return
+
+		case <-idle:
+			if err := m.checkIdle(); err != nil {
+				m.fail(err)
+				return
+			}
+
+		case <-ping:
+			if m.ClientPinger != nil {
+				go m.ClientPinger.Ping()
+			}
+
+			if m.UpstreamPinger != nil {
+				go m.UpstreamPinger.Ping()
+			}
+
+		case <-lifetime:
+			m.fail(fmt.Errorf(
+				"connection exceeded its maximum lifetime (%s)",
+				m.Limits.MaxLifetime,
+			))
+			return
+		}
+	}
+}
+
+// checkIdle returns an error if the connection has been idle for longer than
+// the idle timeout.
+//
+// If pings are being sent, each side must have sent data within the timeout,
+// otherwise it is sufficient for either side to have done so.
+func (m *connectionMonitor) checkIdle() error {
+	client := time.Since(time.Unix(0, atomic.LoadInt64(&m.clientActivity)))
+	upstream := time.Since(time.Unix(0, atomic.LoadInt64(&m.upstreamActivity)))
+	timeout := m.Limits.IdleTimeout
+
+	if m.ClientPinger != nil && m.Limits.PingInterval > 0 {
+		if client >= timeout {
+			return fmt.Errorf("client did not respond within the idle timeout (%s)", timeout)
+		}
+
+		if upstream >= timeout {
+			return fmt.Errorf("upstream server did not respond within the idle timeout (%s)", timeout)
+		}
+
+		return nil
+	}
+
+	if client >= timeout && upstream >= timeout {
+		return fmt.Errorf("connection exceeded the idle timeout (%s)", timeout)
+	}
+
+	return nil
+}
+
+// fail records err and closes the connection.
+func (m *connectionMonitor) fail(err error) {
+	m.m.Lock()
+	m.err = err
+	m.m.Unlock()
+
+	m.Close()
+}
+
+// activityReader is an io.Reader that records the time at which data was last
+// read.
+type activityReader struct {
+	io.Reader
+	last *int64
+}
+
+func (r *activityReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		atomic.StoreInt64(r.last, time.Now().UnixNano())
+	}
+
+	return n, err
+}
@@ -0,0 +1,89 @@
+package proxy_test
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/icecave/honeycomb/backend"
+	"github.com/icecave/honeycomb/proxy"
+	"github.com/icecave/honeycomb/static"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WebSocketProxy origin enforcement", func() {
+	var (
+		upstream *httptest.Server
+		frontend *httptest.Server
+		dialed   chan struct{}
+	)
+
+	BeforeEach(func() {
+		dialed = make(chan struct{}, 1)
+
+		upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			dialed <- struct{}{}
+			serveWebSocketEcho(w, r)
+		}))
+
+		u, _ := url.Parse(upstream.URL)
+		endpoint := &backend.Endpoint{
+			Address: u.Host,
+			Origins: &backend.OriginPolicy{
+				Allowed: []string{"https://*.example.org"},
+			},
+		}
+
+		httpProxy := &proxy.HTTPProxy{Transport: &http.Transport{}}
+		wsProxy := &proxy.WebSocketProxy{Dialer: &proxy.BasicWebSocketDialer{}}
+
+		frontend = httptest.NewServer(&proxy.Handler{
+			Locator:                static.Locator{}.With("app.*", endpoint),
+			SecureHTTPProxy:        httpProxy,
+			SecureWebSocketProxy:   wsProxy,
+			InsecureHTTPProxy:      httpProxy,
+			InsecureWebSocketProxy: wsProxy,
+		})
+	})
+
+	AfterEach(func() {
+		frontend.Close()
+		upstream.Close()
+	})
+
+	connect := func(origin string) (net.Conn, *http.Response) {
+		conn, err := net.Dial("tcp", frontend.Listener.Addr().String())
+		Expect(err).ShouldNot(HaveOccurred())
+
+		conn.Write([]byte(
+			"GET / HTTP/1.1\r\n" +
+				"Host: app.example.com\r\n" +
+				"Origin: " + origin + "\r\n" +
+				"Connection: Upgrade\r\n" +
+				"Upgrade: websocket\r\n\r\n",
+		))
+
+		response, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		return conn, response
+	}
+
+	It("accepts connections from allowed origins", func() {
+		conn, response := connect("https://www.example.org")
+		defer conn.Close()
+
+		Expect(response.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+	})
+
+	It("rejects connections from other origins without contacting the upstream server", func() {
+		conn, response := connect("https://evil.example.com")
+		defer conn.Close()
+
+		Expect(response.StatusCode).To(Equal(http.StatusForbidden))
+		Consistently(dialed).ShouldNot(Receive())
+	})
+})
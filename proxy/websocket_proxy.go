@@ -2,40 +2,136 @@ package proxy
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+	"time"
 
+	"github.com/icecave/honeycomb/backend"
 	"github.com/icecave/honeycomb/statuspage"
 )
 
-// WebSocketProxy is a proxy that handles WebSocket connections.
+// errOriginNotAllowed is returned when a websocket connection is made from an
+// origin that the endpoint does not allow.
+var errOriginNotAllowed = errors.New("websocket connections are not allowed from this origin")
+
+// WebSocketProxy is a proxy that handles WebSocket connections, and
+// connections that are upgraded to other protocols.
 type WebSocketProxy struct {
 	Dialer WebSocketDialer
+
+	// HTTP2Transport, if non-nil, is used to forward HTTP/2 websocket requests
+	// to endpoints that must use HTTP/2. It must support RFC 8441 extended
+	// CONNECT requests.
+	HTTP2Transport http.RoundTripper
+
+	// Timeouts are the default upstream timeouts. They are overridden by the
+	// timeouts of the endpoint that the request is forwarded to. The idle and
+	// total timeouts do not apply once the connection has been upgraded.
+	Timeouts backend.Timeouts
+
+	// Limits are the default limits that apply once the connection has been
+	// upgraded. They are overridden by the limits of the endpoint that the
+	// request is forwarded to.
+	Limits backend.WebSocketLimits
+
+	// ConnectionLimiter, if non-nil, is used to enforce the maximum number of
+	// concurrent connections to each endpoint, and from each client.
+	ConnectionLimiter *ConnectionLimiter
+
+	// SecurityHeaders are the default security headers added to responses. They
+	// are overridden by the security headers of the endpoint that the request
+	// is forwarded to.
+	SecurityHeaders backend.SecurityHeaders
 }
 
 // Forward proxies data between the client and the upstream server.
+//
+// Clients may use either an HTTP/1.1 upgrade, or an HTTP/2 extended CONNECT
+// request as per RFC 8441.
 func (proxy *WebSocketProxy) Forward(
 	writer http.ResponseWriter,
 	request *http.Request,
 	upstreamRequest *http.Request,
 	logContext *LogContext,
 ) error {
-	hijacker, ok := writer.(http.Hijacker)
-	if !ok {
-		return errors.New("client connection can not be hijacked")
+	isConnect := isWebSocketConnect(request)
+
+	var hijacker http.Hijacker
+	if !isConnect {
+		var ok bool
+		hijacker, ok = writer.(http.Hijacker)
+		if !ok {
+			return errors.New("client connection can not be hijacked")
+		}
+	}
+
+	timeouts := proxy.Timeouts
+	limits := proxy.Limits
+	if logContext.Endpoint != nil {
+		if origins := logContext.Endpoint.Origins; origins != nil {
+			if !origins.Allows(request.Header.Get("Origin"), request.Host) {
+				return statuspage.Error{
+					Inner:      errOriginNotAllowed,
+					StatusCode: http.StatusForbidden,
+				}
+			}
+		}
+
+		timeouts = logContext.Endpoint.Timeouts.Merge(timeouts)
+		limits = logContext.Endpoint.WebSocket.Merge(limits)
+
+		if proxy.ConnectionLimiter != nil {
+			route := logContext.route
+			if route == nil {
+				route = logContext.Endpoint
+			}
+
+			release, err := proxy.ConnectionLimiter.Acquire(request, route, limits)
+			if err != nil {
+				return err
+			}
+			defer release()
+		}
+
+		if isConnect &&
+			proxy.HTTP2Transport != nil &&
+			logContext.Endpoint.Protocol == backend.ProtocolHTTP2 {
+			return proxy.forwardHTTP2(writer, request, upstreamRequest, logContext, timeouts, limits)
+		}
 	}
 
 	// Connect to the upstream server ...
-	upstreamConnection, err := proxy.Dialer.Dial(upstreamRequest)
+	logContext.Attempts = 1
+	upstreamConnection, err := proxy.dial(upstreamRequest, timeouts)
 	if err != nil {
-		return statuspage.Error{Inner: err, StatusCode: http.StatusBadGateway}
+		return statuspage.Error{Inner: err, StatusCode: statusCodeForError(err)}
 	}
 	defer upstreamConnection.Close()
 
-	// Re-add hop-by-hop headers that are needed for websockets ...
+	if timeouts.ResponseHeader > 0 {
+		upstreamConnection.SetReadDeadline(time.Now().Add(timeouts.ResponseHeader))
+	}
+
+	// Re-add hop-by-hop headers that are needed for websockets, or the other
+	// protocol requested by the client ...
+	protocol := "websocket"
+	if !isConnect {
+		protocol = request.Header.Get("Upgrade")
+	}
+
 	upstreamRequest.Header.Set("Connection", "upgrade")
-	upstreamRequest.Header.Set("Upgrade", "websocket")
+	upstreamRequest.Header.Set("Upgrade", protocol)
+
+	// Convert an extended CONNECT request into an HTTP/1.1 upgrade ...
+	if isConnect {
+		prepareWebSocketUpgrade(upstreamRequest)
+	}
 
 	// Send the HTTP request ...
 	err = writeRequestHeaders(upstreamConnection, upstreamRequest)
@@ -47,22 +143,50 @@ func (proxy *WebSocketProxy) Forward(
 	upstreamReader := bufio.NewReader(upstreamConnection)
 	upstreamResponse, err := http.ReadResponse(upstreamReader, upstreamRequest)
 	if err != nil {
-		return statuspage.Error{Inner: err, StatusCode: http.StatusBadGateway}
+		if isTimeout(err) {
+			err = TimeoutError{"response header", timeouts.ResponseHeader}
+		}
+
+		return statuspage.Error{Inner: err, StatusCode: statusCodeForError(err)}
 	}
 
+	upstreamConnection.SetReadDeadline(time.Time{})
+
+	security := securityHeaders(proxy.SecurityHeaders, request, logContext)
+
 	logContext.Metrics.FirstByteSent()
-	logContext.StatusCode = upstreamResponse.StatusCode
+	logContext.setUpstreamResponse(upstreamResponse)
 
 	// If the server is not switching protocols, proxy its response unchanged ...
 	if upstreamResponse.StatusCode != http.StatusSwitchingProtocols {
-		logContext.Metrics.BytesOut, err = writeResponse(writer, upstreamResponse)
+		logContext.Metrics.BytesOut, err = writeResponse(writer, upstreamResponse, 0, security)
 		logContext.Metrics.LastByteSent()
 		return err
 	}
 
+	// An extended CONNECT request is accepted with a 200 OK, after which the
+	// websocket frames are sent in the HTTP/2 stream ...
+	if isConnect {
+		stream := acceptWebSocketConnect(writer, request, upstreamResponse, security)
+		logContext.StatusCode = http.StatusOK
+
+		logContext.Log(nil)
+
+		return proxy.pipe(
+			upstreamConnection,
+			upstreamReader,
+			stream,
+			bufio.NewReader(stream),
+			&logContext.Metrics,
+			limits,
+			true,
+			upstreamResponse.Header.Get("Sec-WebSocket-Extensions") != "",
+		)
+	}
+
 	// Otherwise return just the headers, then hijack the connection to proxy
 	// the websocket frames ...
-	writeResponseHeaders(writer, upstreamResponse, true)
+	writeResponseHeaders(writer, upstreamResponse, true, security)
 
 	logContext.Log(nil)
 
@@ -78,30 +202,264 @@ func (proxy *WebSocketProxy) Forward(
 		clientConnection,
 		clientIO.Reader,
 		&logContext.Metrics,
+		limits,
+		logContext.Upgrade == "",
+		upstreamResponse.Header.Get("Sec-WebSocket-Extensions") != "",
 	)
 }
 
+// forwardHTTP2 proxies an extended CONNECT request to an upstream server that
+// also supports websockets over HTTP/2.
+func (proxy *WebSocketProxy) forwardHTTP2(
+	writer http.ResponseWriter,
+	request *http.Request,
+	upstreamRequest *http.Request,
+	logContext *LogContext,
+	timeouts backend.Timeouts,
+	limits backend.WebSocketLimits,
+) error {
+	logContext.Attempts = 1
+
+	upstreamURL := *upstreamRequest.URL
+	if upstreamURL.Scheme == "ws" {
+		upstreamURL.Scheme = "http"
+	} else {
+		upstreamURL.Scheme = "https"
+	}
+
+	// Pings are not sent to HTTP/2 upstream servers, as the frames are not
+	// proxied in a way that allows them to be inserted.
+	ctx, cancel := context.WithCancel(request.Context())
+	defer cancel()
+
+	monitor := &connectionMonitor{
+		Limits: limits,
+		Close:  cancel,
+	}
+
+	body := &countingReader{Reader: monitor.ClientReader(request.Body)}
+
+	// The request body is the websocket stream, so the request is never fully
+	// written. Instead, the response header timeout starts once the headers
+	// have been sent.
+	w := &watchdog{cancel: cancel}
+	trace := &httptrace.ClientTrace{
+		WroteHeaders: func() {
+			w.Start("response header", timeouts.ResponseHeader)
+		},
+		GotFirstResponseByte: func() {
+			w.Stop()
+		},
+	}
+
+	transport := timeoutTransport{
+		proxy.HTTP2Transport,
+		backend.Timeouts{
+			Dial:         timeouts.Dial,
+			TLSHandshake: timeouts.TLSHandshake,
+		},
+	}
+
+	upstreamRequest = upstreamRequest.WithContext(httptrace.WithClientTrace(ctx, trace))
+	upstreamRequest.Method = http.MethodConnect
+	upstreamRequest.URL = &upstreamURL
+	upstreamRequest.Header.Set(":protocol", "websocket")
+	upstreamRequest.Header.Del("Host")
+	upstreamRequest.Body = ioutil.NopCloser(body)
+	upstreamRequest.ContentLength = -1
+
+	upstreamResponse, err := transport.RoundTrip(upstreamRequest)
+	w.Stop()
+
+	if err != nil {
+		if e := w.Err(); e != nil {
+			err = e
+		}
+
+		return statuspage.Error{Inner: err, StatusCode: statusCodeForError(err)}
+	}
+	defer upstreamResponse.Body.Close()
+
+	security := securityHeaders(proxy.SecurityHeaders, request, logContext)
+
+	logContext.Metrics.FirstByteSent()
+	logContext.setUpstreamResponse(upstreamResponse)
+
+	if upstreamResponse.StatusCode != http.StatusOK {
+		logContext.Metrics.BytesOut, err = writeResponse(writer, upstreamResponse, 0, security)
+		logContext.Metrics.LastByteSent()
+		return err
+	}
+
+	writeResponseHeaders(writer, upstreamResponse, false, security)
+	if f, ok := writer.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	logContext.Log(nil)
+
+	monitor.Start()
+
+	logContext.Metrics.BytesOut, err = io.Copy(
+		newFlushWriter(writer, -1),
+		monitor.UpstreamReader(upstreamResponse.Body),
+	)
+	logContext.Metrics.BytesIn = body.Count()
+	logContext.Metrics.LastByteSent()
+
+	if e := monitor.Stop(); e != nil {
+		return e
+	}
+
+	return err
+}
+
+// dial connects to the upstream server, enforcing the dial and TLS handshake
+// timeouts.
+func (proxy *WebSocketProxy) dial(
+	upstreamRequest *http.Request,
+	timeouts backend.Timeouts,
+) (net.Conn, error) {
+	phase := "dial"
+	timeout := timeouts.Dial
+
+	if upstreamRequest.URL.Scheme == "wss" {
+		if timeouts.TLSHandshake == 0 {
+			timeout = 0
+		} else {
+			phase = "dial and TLS handshake"
+			timeout += timeouts.TLSHandshake
+		}
+	}
+
+	if timeout == 0 {
+		return proxy.Dialer.Dial(upstreamRequest)
+	}
+
+	ctx, cancel := context.WithTimeout(upstreamRequest.Context(), timeout)
+	defer cancel()
+
+	conn, err := proxy.Dialer.Dial(upstreamRequest.WithContext(ctx))
+	if err != nil && isTimeout(err) {
+		return nil, TimeoutError{phase, timeout}
+	}
+
+	return conn, err
+}
+
 // pipe sends data between the upstream server and the client, first flushing
 // any data that was buffered while reading the request and response headers.
+// Both connections are closed as soon as either side closes its connection.
+//
+// If isWebSocket is true and limits require it, the data is parsed as
+// websocket frames so that they can be inspected, and pings inserted between
+// them. hasExtensions is true if the upstream server accepted a websocket
+// extension.
 func (proxy *WebSocketProxy) pipe(
 	upstreamConnection io.ReadWriteCloser,
 	upstreamReader *bufio.Reader,
 	clientConnection io.ReadWriteCloser,
 	clientReader *bufio.Reader,
 	metrics *Metrics,
+	limits backend.WebSocketLimits,
+	isWebSocket bool,
+	hasExtensions bool,
 ) error {
+	// closed is set once the connections have been closed, after which errors
+	// from the other direction are expected and not reported.
+	var closed int32
+	closeBoth := func() bool {
+		if !atomic.CompareAndSwapInt32(&closed, 0, 1) {
+			return false
+		}
+
+		upstreamConnection.Close()
+		clientConnection.Close()
+
+		return true
+	}
+
+	monitor := &connectionMonitor{
+		Limits: limits,
+		Close:  func() { closeBoth() },
+	}
+
+	copyIn := func() (int64, error) {
+		return proxy.copy(upstreamConnection, clientReader, clientConnection, monitor.ClientReader)
+	}
+
+	copyOut := func() (int64, error) {
+		return proxy.copy(clientConnection, upstreamReader, upstreamConnection, monitor.UpstreamReader)
+	}
+
+	if isWebSocket && limits.ParsesFrames() {
+		clientWriter := &frameWriter{writer: clientConnection}
+		upstreamWriter := &frameWriter{writer: upstreamConnection, mask: true}
+
+		if limits.PingInterval > 0 {
+			monitor.ClientPinger = clientWriter
+			monitor.UpstreamPinger = upstreamWriter
+		}
+
+		metrics.WebSocket = &WebSocketMetrics{}
+
+		in := &frameCopier{
+			Side:           "client",
+			Writer:         upstreamWriter,
+			Reply:          clientWriter,
+			Masked:         true,
+			Extensions:     hasExtensions,
+			MaxMessageSize: limits.MaxMessageSize,
+			Counts:         &metrics.WebSocket.In,
+			Metrics:        metrics.WebSocket,
+		}
+
+		out := &frameCopier{
+			Side:           "upstream",
+			Writer:         clientWriter,
+			Reply:          upstreamWriter,
+			Extensions:     hasExtensions,
+			MaxMessageSize: limits.MaxMessageSize,
+			Counts:         &metrics.WebSocket.Out,
+			Metrics:        metrics.WebSocket,
+		}
+
+		copyIn = func() (int64, error) {
+			return in.Copy(monitor.ClientReader(clientReader))
+		}
+
+		copyOut = func() (int64, error) {
+			return out.Copy(monitor.UpstreamReader(upstreamReader))
+		}
+	}
+
+	monitor.Start()
+
 	done := make(chan error)
 	go func() {
-		bytes, err := proxy.copy(upstreamConnection, clientReader, clientConnection)
+		bytes, err := copyIn()
 		metrics.BytesIn += bytes
+
+		if !closeBoth() {
+			err = nil
+		}
+
 		done <- err
 	}()
 
-	bytes, err := proxy.copy(clientConnection, upstreamReader, upstreamConnection)
+	bytes, err := copyOut()
 	metrics.BytesOut += bytes
 	metrics.LastByteSent()
 
+	if !closeBoth() {
+		err = nil
+	}
+
 	if e := <-done; e != nil {
+		err = e
+	}
+
+	if e := monitor.Stop(); e != nil {
 		return e
 	}
 
@@ -109,21 +467,35 @@ func (proxy *WebSocketProxy) pipe(
 }
 
 // copy first writes any buffered data from buffer to writer, then from reader
-// until EOF is reached.
+// until EOF is reached. Reads are passed through track, so that activity can
+// be monitored.
 func (proxy *WebSocketProxy) copy(
 	writer io.Writer,
 	buffer *bufio.Reader,
-	reader io.ReadCloser,
+	reader io.Reader,
+	track func(io.Reader) io.Reader,
 ) (int64, error) {
 	bufferedBytes := int64(buffer.Buffered())
 	if bufferedBytes != 0 {
-		if _, err := io.CopyN(writer, buffer, bufferedBytes); err != nil {
+		if _, err := io.CopyN(writer, track(buffer), bufferedBytes); err != nil {
 			return bufferedBytes, err
 		}
 	}
 
-	bytes, err := io.Copy(writer, reader)
-	reader.Close()
+	bytes, err := io.Copy(writer, track(reader))
 
 	return bufferedBytes + bytes, err
 }
+
+// isRejection returns true if err indicates that the websocket proxy rejected
+// the request without contacting the upstream server.
+func isRejection(err error) bool {
+	if e, ok := err.(statuspage.Error); ok {
+		switch e.Inner {
+		case errClientConnectionLimit, errRouteConnectionLimit, errOriginNotAllowed:
+			return true
+		}
+	}
+
+	return false
+}
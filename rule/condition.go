@@ -0,0 +1,172 @@
+package rule
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/icecave/honeycomb/backend"
+)
+
+// Condition is a requirement that a request must meet for a rule to match.
+type Condition interface {
+	// Match returns true if request meets the condition.
+	Match(request *http.Request) bool
+}
+
+// headerCondition requires a request header to be present, or to have a
+// specific value.
+type headerCondition struct {
+	Name  string
+	Value *string
+}
+
+func (c headerCondition) Match(request *http.Request) bool {
+	return matchValues(request.Header.Values(c.Name), c.Value)
+}
+
+// cookieCondition requires a cookie to be present, or to have a specific
+// value.
+type cookieCondition struct {
+	Name  string
+	Value *string
+}
+
+func (c cookieCondition) Match(request *http.Request) bool {
+	var values []string
+	for _, cookie := range request.Cookies() {
+		if cookie.Name == c.Name {
+			values = append(values, cookie.Value)
+		}
+	}
+
+	return matchValues(values, c.Value)
+}
+
+// queryCondition requires a query parameter to be present, or to have a
+// specific value.
+type queryCondition struct {
+	Name  string
+	Value *string
+}
+
+func (c queryCondition) Match(request *http.Request) bool {
+	return matchValues(request.URL.Query()[c.Name], c.Value)
+}
+
+// methodCondition requires the request to use one of a set of methods.
+type methodCondition []string
+
+func (c methodCondition) Match(request *http.Request) bool {
+	for _, m := range c {
+		if strings.EqualFold(m, request.Method) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// networkCondition requires the client's IP address to be within one of a set
+// of networks.
+type networkCondition []*net.IPNet
+
+func (c networkCondition) Match(request *http.Request) bool {
+	host, _, _ := net.SplitHostPort(request.RemoteAddr)
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range c {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchValues returns true if values is non-empty and either value is nil, or
+// values contains *value.
+func matchValues(values []string, value *string) bool {
+	if value == nil {
+		return len(values) > 0
+	}
+
+	for _, v := range values {
+		if v == *value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseCondition parses a single "<kind>:<spec>" term of a rule expression.
+func parseCondition(kind, spec string) (Condition, error) {
+	switch kind {
+	case "header":
+		n, v, err := parseNameValue(spec)
+		if err != nil {
+			return nil, err
+		}
+		return headerCondition{http.CanonicalHeaderKey(n), v}, nil
+	case "cookie":
+		n, v, err := parseNameValue(spec)
+		if err != nil {
+			return nil, err
+		}
+		return cookieCondition{n, v}, nil
+	case "query":
+		n, v, err := parseNameValue(spec)
+		if err != nil {
+			return nil, err
+		}
+		return queryCondition{n, v}, nil
+	case "method":
+		var methods methodCondition
+		for _, m := range strings.Split(spec, ",") {
+			if m = strings.TrimSpace(m); m != "" {
+				methods = append(methods, m)
+			}
+		}
+		if len(methods) == 0 {
+			return nil, fmt.Errorf("'%s' condition requires at least one method", kind)
+		}
+		return methods, nil
+	case "cidr":
+		networks, err := backend.ParseNetworks(spec)
+		if err != nil {
+			return nil, err
+		}
+		if len(networks) == 0 {
+			return nil, fmt.Errorf("'%s' condition requires at least one network", kind)
+		}
+		return networkCondition(networks), nil
+	default:
+		return nil, fmt.Errorf(
+			"unknown condition '%s', expected 'header', 'cookie', 'query', 'method', 'cidr' or 'score'",
+			kind,
+		)
+	}
+}
+
+// parseNameValue parses a "<name>" or "<name>=<value>" condition spec.
+func parseNameValue(spec string) (string, *string, error) {
+	n := spec
+	var v *string
+
+	if i := strings.Index(spec, "="); i != -1 {
+		n = spec[:i]
+		value := spec[i+1:]
+		v = &value
+	}
+
+	if n == "" {
+		return "", nil, fmt.Errorf("condition requires a name")
+	}
+
+	return n, v, nil
+}
@@ -0,0 +1,58 @@
+package rule
+
+import (
+	"net/http"
+
+	"github.com/icecave/honeycomb/backend"
+	"github.com/icecave/honeycomb/name"
+)
+
+// Locator finds a back-end HTTP server for a request based on routing rules.
+// It is consulted before a backend.Locator, and takes precedence when a rule
+// matches.
+type Locator interface {
+	// LocateRequest finds the back-end HTTP server for request, which was
+	// sent to serverName.
+	//
+	// It returns a score indicating the strength of the match. A value of 0
+	// or less indicates that no rule matched, in which case ep is nil.
+	LocateRequest(request *http.Request, serverName name.ServerName) (ep *backend.Endpoint, score int)
+}
+
+// Set is a Locator that uses a fixed list of rules.
+type Set []Rule
+
+// LocateRequest finds the back-end HTTP server for request, using the rule
+// with the highest score.
+func (set Set) LocateRequest(
+	request *http.Request,
+	serverName name.ServerName,
+) (ep *backend.Endpoint, score int) {
+	for _, r := range set {
+		if s := r.Match(request, serverName); s > score {
+			ep = r.Endpoint
+			score = s
+		}
+	}
+
+	return ep, score
+}
+
+// AggregateLocator combines multiple locators to find endpoints.
+type AggregateLocator []Locator
+
+// LocateRequest finds the back-end HTTP server for request, using the locator
+// that returns the highest score.
+func (locator AggregateLocator) LocateRequest(
+	request *http.Request,
+	serverName name.ServerName,
+) (ep *backend.Endpoint, score int) {
+	for _, loc := range locator {
+		if e, s := loc.LocateRequest(request, serverName); s > score {
+			ep = e
+			score = s
+		}
+	}
+
+	return ep, score
+}
@@ -0,0 +1,121 @@
+package rule
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/icecave/honeycomb/backend"
+	"github.com/icecave/honeycomb/name"
+)
+
+// hostScoreRange is larger than any score returned by name.Matcher, so that a
+// rule's own score always takes precedence over the strength of its host
+// match.
+const hostScoreRange = 1024
+
+// Rule routes requests that match a server name pattern and a set of
+// conditions to an endpoint.
+type Rule struct {
+	Matcher    *name.Matcher
+	Expression Expression
+	Endpoint   *backend.Endpoint
+}
+
+// Match checks if request, which was sent to serverName, matches the rule.
+//
+// It returns a score indicating the strength of the match. A value of 0 or less
+// indicates that no match was made. Rules with a higher expression score always
+// have a higher match score, regardless of the strength of their host match.
+func (r Rule) Match(request *http.Request, serverName name.ServerName) int {
+	hostScore := r.Matcher.Match(serverName)
+	if hostScore <= 0 || !r.Expression.Match(request) {
+		return 0
+	}
+
+	return r.Expression.Score*hostScoreRange + hostScore
+}
+
+// Expression is a set of conditions that must all be met for a rule to match.
+type Expression struct {
+	// Source is the text from which the expression was parsed.
+	Source string
+
+	Conditions []Condition
+
+	// Score is used to choose between multiple matching rules. Rules with a
+	// higher score are preferred.
+	Score int
+}
+
+// Match returns true if request meets all of the expression's conditions.
+func (e Expression) Match(request *http.Request) bool {
+	for _, c := range e.Conditions {
+		if !c.Match(request) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ParseExpression parses a rule expression.
+//
+// An expression is a semi-colon separated list of conditions in the form
+// "<kind>:<spec>", all of which must be met for the rule to match. The
+// supported conditions are:
+//
+//	header:<name>[=<value>]  a request header is present, or has a value
+//	cookie:<name>[=<value>]  a cookie is present, or has a value
+//	query:<name>[=<value>]   a query parameter is present, or has a value
+//	method:<method>,...      the request uses one of the methods
+//	cidr:<network>,...       the client's IP address is in one of the networks
+//
+// The special "score:<n>" term sets the score of the rule, which defaults to
+// the number of conditions.
+func ParseExpression(source string) (Expression, error) {
+	e := Expression{Source: source, Score: -1}
+
+	for _, term := range strings.Split(source, ";") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		i := strings.Index(term, ":")
+		if i == -1 {
+			return Expression{}, fmt.Errorf("invalid condition '%s', expected '<kind>:<spec>'", term)
+		}
+
+		kind := strings.ToLower(term[:i])
+		spec := term[i+1:]
+
+		if kind == "score" {
+			score, err := strconv.Atoi(spec)
+			if err != nil || score < 0 {
+				return Expression{}, fmt.Errorf("invalid score '%s', expected a non-negative integer", spec)
+			}
+
+			e.Score = score
+			continue
+		}
+
+		c, err := parseCondition(kind, spec)
+		if err != nil {
+			return Expression{}, err
+		}
+
+		e.Conditions = append(e.Conditions, c)
+	}
+
+	if len(e.Conditions) == 0 {
+		return Expression{}, fmt.Errorf("'%s' does not contain any conditions", source)
+	}
+
+	if e.Score == -1 {
+		e.Score = len(e.Conditions)
+	}
+
+	return e, nil
+}
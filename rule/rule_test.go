@@ -0,0 +1,135 @@
+package rule_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/icecave/honeycomb/backend"
+	"github.com/icecave/honeycomb/name"
+	"github.com/icecave/honeycomb/rule"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseExpression", func() {
+	DescribeTable(
+		"it matches requests that meet all conditions",
+		func(source string, prepare func(*http.Request), expected bool) {
+			e, err := rule.ParseExpression(source)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			request := httptest.NewRequest(http.MethodGet, "https://app.example.com/?debug=1", nil)
+			request.RemoteAddr = "10.1.2.3:12345"
+			if prepare != nil {
+				prepare(request)
+			}
+
+			Expect(e.Match(request)).To(Equal(expected))
+		},
+		Entry("header present", "header:x-canary", func(r *http.Request) {
+			r.Header.Set("X-Canary", "0")
+		}, true),
+		Entry("header absent", "header:X-Canary", nil, false),
+		Entry("header value", "header:X-Canary=1", func(r *http.Request) {
+			r.Header.Set("X-Canary", "1")
+		}, true),
+		Entry("header value mismatch", "header:X-Canary=1", func(r *http.Request) {
+			r.Header.Set("X-Canary", "0")
+		}, false),
+		Entry("cookie value", "cookie:beta=true", func(r *http.Request) {
+			r.AddCookie(&http.Cookie{Name: "beta", Value: "true"})
+		}, true),
+		Entry("cookie absent", "cookie:beta=true", nil, false),
+		Entry("query value", "query:debug=1", nil, true),
+		Entry("query absent", "query:trace", nil, false),
+		Entry("method", "method:post,get", nil, true),
+		Entry("method mismatch", "method:POST", nil, false),
+		Entry("client network", "cidr:10.0.0.0/8", nil, true),
+		Entry("client network mismatch", "cidr:192.168.0.0/16", nil, false),
+		Entry("multiple conditions", "method:GET; query:debug=1", nil, true),
+		Entry("multiple conditions, one unmet", "method:GET; query:debug=2", nil, false),
+	)
+
+	It("defaults the score to the number of conditions", func() {
+		e, err := rule.ParseExpression("method:GET;query:debug")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(e.Score).To(Equal(2))
+	})
+
+	It("uses the explicit score if present", func() {
+		e, err := rule.ParseExpression("method:GET;score:10")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(e.Score).To(Equal(10))
+	})
+
+	DescribeTable(
+		"it returns an error for invalid expressions",
+		func(source string) {
+			_, err := rule.ParseExpression(source)
+			Expect(err).Should(HaveOccurred())
+		},
+		Entry("empty", ""),
+		Entry("score only", "score:1"),
+		Entry("missing kind", "X-Canary=1"),
+		Entry("unknown kind", "path:/foo"),
+		Entry("missing name", "header:=1"),
+		Entry("invalid network", "cidr:10.0.0.0/33"),
+		Entry("invalid score", "header:X-Canary;score:-1"),
+	)
+})
+
+var _ = Describe("Set", func() {
+	newRule := func(pattern, source, address string) rule.Rule {
+		matcher, err := name.NewMatcher(pattern)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		e, err := rule.ParseExpression(source)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		return rule.Rule{
+			Matcher:    matcher,
+			Expression: e,
+			Endpoint:   &backend.Endpoint{Address: address},
+		}
+	}
+
+	It("prefers the rule with the highest score over a stronger host match", func() {
+		subject := rule.Set{
+			newRule("app.example.com", "header:X-Canary", "exact:80"),
+			newRule("*.example.com", "header:X-Canary;score:5", "wildcard:80"),
+		}
+
+		request := httptest.NewRequest(http.MethodGet, "https://app.example.com/", nil)
+		request.Header.Set("X-Canary", "1")
+
+		ep, score := subject.LocateRequest(request, name.Parse("app.example.com"))
+		Expect(score).To(BeNumerically(">", 0))
+		Expect(ep.Address).To(Equal("wildcard:80"))
+	})
+
+	It("prefers the stronger host match when the scores are equal", func() {
+		subject := rule.Set{
+			newRule("*.example.com", "header:X-Canary", "wildcard:80"),
+			newRule("app.example.com", "header:X-Canary", "exact:80"),
+		}
+
+		request := httptest.NewRequest(http.MethodGet, "https://app.example.com/", nil)
+		request.Header.Set("X-Canary", "1")
+
+		ep, _ := subject.LocateRequest(request, name.Parse("app.example.com"))
+		Expect(ep.Address).To(Equal("exact:80"))
+	})
+
+	It("does not match when the host does not match", func() {
+		subject := rule.Set{
+			newRule("app.example.com", "method:GET", "app:80"),
+		}
+
+		request := httptest.NewRequest(http.MethodGet, "https://www.example.com/", nil)
+
+		ep, score := subject.LocateRequest(request, name.Parse("www.example.com"))
+		Expect(ep).To(BeNil())
+		Expect(score).To(Equal(0))
+	})
+})
@@ -1,6 +1,7 @@
 package static
 
 import (
+	"fmt"
 	"log"
 	"net/url"
 	"os"
@@ -9,6 +10,7 @@ import (
 
 	"github.com/icecave/honeycomb/backend"
 	"github.com/icecave/honeycomb/name"
+	"github.com/icecave/honeycomb/rule"
 )
 
 // FromEnv returns static locators configured by environment variables.
@@ -44,38 +46,111 @@ func fromEnv(env []string) (Locator, error) {
 			return nil, err
 		}
 
-		u, err := url.Parse(groups[addressIndex])
+		endpoint, err := parseEndpoint(
+			groups[tagIndex],
+			groups[addressIndex],
+			groups[descriptionIndex],
+		)
 		if err != nil {
 			return nil, err
 		}
 
-		tlsMode := backend.TLSDisabled
-		if strings.EqualFold(u.Scheme, "https") || strings.EqualFold(u.Scheme, "wss") {
-			tlsMode = backend.TLSEnabled
+		locator = append(locator, matcherEndpointPair{matcher, endpoint})
+	}
+
+	return locator, nil
+}
+
+// RulesFromEnv returns static routing rules configured by environment
+// variables.
+func RulesFromEnv(logger *log.Logger) (rule.Set, error) {
+	rules, err := rulesFromEnv(os.Environ())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range rules {
+		logger.Printf(
+			"Added static route from '%s' when '%s' to '%s' (%s)",
+			r.Matcher.Pattern,
+			r.Expression.Source,
+			r.Endpoint.Address,
+			r.Endpoint.Description,
+		)
+	}
+
+	return rules, nil
+}
+
+func rulesFromEnv(env []string) (rule.Set, error) {
+	var rules rule.Set
+
+	for _, e := range env {
+		groups := rulePattern.FindStringSubmatch(e)
+		if len(groups) == 0 {
+			continue
 		}
 
-		if port := u.Port(); port == "" {
-			if tlsMode == backend.TLSDisabled {
-				u.Host += ":80"
-			} else {
-				u.Host += ":443"
-			}
+		matcher, err := name.NewMatcher(groups[ruleMatcherIndex])
+		if err != nil {
+			return nil, err
 		}
 
-		endpoint := &backend.Endpoint{
-			Description: groups[tagIndex],
-			Address:     u.Host,
-			TLSMode:     tlsMode,
+		expr, err := rule.ParseExpression(groups[ruleExpressionIndex])
+		if err != nil {
+			return nil, fmt.Errorf("RULE_%s: %s", groups[tagIndex], err)
 		}
 
-		if groups[descriptionIndex] != "" {
-			endpoint.Description = groups[descriptionIndex]
+		endpoint, err := parseEndpoint(
+			groups[tagIndex],
+			groups[ruleAddressIndex],
+			groups[ruleDescriptionIndex],
+		)
+		if err != nil {
+			return nil, err
 		}
 
-		locator = append(locator, matcherEndpointPair{matcher, endpoint})
+		rules = append(rules, rule.Rule{
+			Matcher:    matcher,
+			Expression: expr,
+			Endpoint:   endpoint,
+		})
 	}
 
-	return locator, nil
+	return rules, nil
+}
+
+// parseEndpoint returns the endpoint for a route's URL.
+func parseEndpoint(tag, address, description string) (*backend.Endpoint, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsMode := backend.TLSDisabled
+	if strings.EqualFold(u.Scheme, "https") || strings.EqualFold(u.Scheme, "wss") {
+		tlsMode = backend.TLSEnabled
+	}
+
+	if port := u.Port(); port == "" {
+		if tlsMode == backend.TLSDisabled {
+			u.Host += ":80"
+		} else {
+			u.Host += ":443"
+		}
+	}
+
+	endpoint := &backend.Endpoint{
+		Description: tag,
+		Address:     u.Host,
+		TLSMode:     tlsMode,
+	}
+
+	if description != "" {
+		endpoint.Description = description
+	}
+
+	return endpoint, nil
 }
 
 const (
@@ -85,4 +160,12 @@ const (
 	descriptionIndex
 )
 
+const (
+	ruleMatcherIndex = iota + 2
+	ruleExpressionIndex
+	ruleAddressIndex
+	ruleDescriptionIndex
+)
+
 var routePattern = regexp.MustCompile(`^ROUTE_([^\s]+)=([^\s]+) ([^\s]+)(?: (.+))?$`)
+var rulePattern = regexp.MustCompile(`^RULE_([^\s]+)=([^\s]+) ([^\s]+) ([^\s]+)(?: (.+))?$`)
@@ -118,4 +118,41 @@ var _ = Describe("FromEnv", func() {
 			Expect(err).Should(HaveOccurred())
 		})
 	})
+
+	Describe("rulesFromEnv", func() {
+		It("produces the correct rule", func() {
+			env := []string{"RULE_CANARY=app.* header:X-Canary=1;score:5 https://canary.backend.com Canary"}
+
+			rules, err := rulesFromEnv(env)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(rules).To(HaveLen(1))
+
+			r := rules[0]
+			Expect(r.Matcher.Pattern).To(Equal("app.*"))
+			Expect(r.Expression.Source).To(Equal("header:X-Canary=1;score:5"))
+			Expect(r.Expression.Score).To(Equal(5))
+			Expect(r.Endpoint).To(Equal(&backend.Endpoint{
+				Description: "Canary",
+				Address:     "canary.backend.com:443",
+				TLSMode:     backend.TLSEnabled,
+			}))
+		})
+
+		It("ignores route environment variables", func() {
+			env := []string{"ROUTE_FOO=foo.* https://foo.backend.com:1234"}
+
+			rules, err := rulesFromEnv(env)
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(rules).To(HaveLen(0))
+		})
+
+		It("returns an error if the rule expression is invalid", func() {
+			env := []string{"RULE_FOO=foo.* header https://backend"}
+
+			_, err := rulesFromEnv(env)
+
+			Expect(err).Should(HaveOccurred())
+		})
+	})
 })
@@ -0,0 +1,148 @@
+package statuspage
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gRPC status codes, as per https://grpc.github.io/grpc/core/md_doc_statuscodes.html.
+const (
+	grpcUnknown          = 2
+	grpcDeadlineExceeded = 4
+	grpcPermissionDenied = 7
+	grpcUnimplemented    = 12
+	grpcInternal         = 13
+	grpcUnavailable      = 14
+	grpcUnauthenticated  = 16
+)
+
+// GRPCWriter writes status pages as gRPC "trailers-only" responses, so that
+// they can be understood by gRPC and gRPC-Web clients.
+type GRPCWriter struct{}
+
+// Write outputs a gRPC error for statusCode to writer, in response to request.
+func (wr *GRPCWriter) Write(
+	writer http.ResponseWriter,
+	request *http.Request,
+	statusCode int,
+) (bodySize int64, err error) {
+	return wr.WriteMessage(
+		writer,
+		request,
+		statusCode,
+		StatusMessage(statusCode),
+	)
+}
+
+// WriteMessage outputs a gRPC error for statusCode to writer, in response to
+// request, including a custom message.
+func (wr *GRPCWriter) WriteMessage(
+	writer http.ResponseWriter,
+	request *http.Request,
+	statusCode int,
+	message string,
+) (int64, error) {
+	contentType := request.Header.Get("Content-Type")
+	if !IsGRPC(request) && !IsGRPCWeb(request) {
+		contentType = "application/grpc"
+	}
+
+	headers := writer.Header()
+	headers.Set("Content-Type", contentType)
+	headers.Set("Grpc-Status", strconv.Itoa(GRPCStatus(statusCode)))
+	headers.Set("Grpc-Message", encodeGRPCMessage(message))
+	writer.WriteHeader(http.StatusOK)
+
+	return 0, nil
+}
+
+// WriteError outputs a gRPC error for the given error to writer, in response to
+// request.
+func (wr *GRPCWriter) WriteError(
+	writer http.ResponseWriter,
+	request *http.Request,
+	statusErr error,
+) (statusCode int, bodySize int64, err error) {
+	statusCode = http.StatusInternalServerError
+	message := ""
+
+	if e, ok := statusErr.(Error); ok {
+		statusCode = e.StatusCode
+		message = e.Message
+	}
+
+	if message == "" {
+		message = StatusMessage(statusCode)
+	}
+
+	bodySize, err = wr.WriteMessage(writer, request, statusCode, message)
+
+	// The HTTP status code of a gRPC response is always 200 OK, the error is
+	// conveyed by the grpc-status header.
+	return http.StatusOK, bodySize, err
+}
+
+// IsGRPC returns true if request is a gRPC request.
+func IsGRPC(request *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	return mediaType == "application/grpc" ||
+		strings.HasPrefix(mediaType, "application/grpc+")
+}
+
+// IsGRPCWeb returns true if request is a gRPC-Web request, in either the binary
+// or base64 text format.
+func IsGRPCWeb(request *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	return mediaType == "application/grpc-web" ||
+		mediaType == "application/grpc-web-text" ||
+		strings.HasPrefix(mediaType, "application/grpc-web+") ||
+		strings.HasPrefix(mediaType, "application/grpc-web-text+")
+}
+
+// GRPCStatus returns the gRPC status code equivalent to the given HTTP status
+// code.
+//
+// It follows the mapping described at
+// https://github.com/grpc/grpc/blob/master/doc/http-grpc-status-mapping.md,
+// except that 504 Gateway Timeout maps to DEADLINE_EXCEEDED, as it is only
+// produced when an upstream timeout expires.
+func GRPCStatus(statusCode int) int {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return grpcInternal
+	case http.StatusUnauthorized:
+		return grpcUnauthenticated
+	case http.StatusForbidden:
+		return grpcPermissionDenied
+	case http.StatusNotFound:
+		return grpcUnimplemented
+	case http.StatusGatewayTimeout:
+		return grpcDeadlineExceeded
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable:
+		return grpcUnavailable
+	default:
+		return grpcUnknown
+	}
+}
+
+// encodeGRPCMessage percent-encodes message for use in the grpc-message
+// header.
+func encodeGRPCMessage(message string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
@@ -54,7 +54,7 @@ func (wr *TemplateWriter) WriteMessage(
 		message,
 	}
 
-	if useHTML(request) {
+	if PrefersHTML(request) {
 		tmpl := wr.HTMLTemplate
 		if tmpl == nil {
 			tmpl = defaultHTMLTemplate
@@ -118,7 +118,9 @@ func init() {
 	)
 }
 
-func useHTML(request *http.Request) bool {
+// PrefersHTML returns true if the client that sent request would rather receive
+// an HTML response than a plain-text one.
+func PrefersHTML(request *http.Request) bool {
 	htmlQ := -1.0
 	textQ := 0.0
 